@@ -0,0 +1,80 @@
+// Package blobtest runs blob servers for tests, on temporary directories and
+// a loopback port, with a client ready to call them.
+package blobtest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/client"
+)
+
+// SecretKey is the secret key of servers that aren't given one.
+const SecretKey = "blobtest-secret"
+
+// Server is a running blob server.
+type Server struct {
+	*blob.Server
+	URL    string         // Base URL, like "http://127.0.0.1:41234".
+	Client *client.Client // Authenticated with the secret key, without retries.
+}
+
+// NewServer starts a server configured by config, whose unset fields default
+// to a 1MB MaxChunkSize, SecretKey and temporary directories. It's shut down
+// when the test ends, so it may be used by parallel subtests.
+func NewServer(tb testing.TB, config blob.ServerConfig) *Server {
+	tb.Helper()
+	if config.MaxChunkSize == 0 {
+		config.MaxChunkSize = 1 * blob.MB
+	}
+	if config.SecretKey == "" {
+		config.SecretKey = SecretKey
+	}
+	if config.MetadataDir == "" {
+		config.MetadataDir = tb.TempDir()
+	}
+	if config.Storage == nil && config.RootDir == "" {
+		config.RootDir = tb.TempDir()
+	}
+	if err := config.Validate(); err != nil {
+		tb.Fatal("invalid config: ", err)
+	}
+
+	s, err := blob.New(config)
+	if err != nil {
+		tb.Fatal("error creating server: ", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		s.Close()
+		tb.Fatal("error listening: ", err)
+	}
+	go s.Serve(ln)
+	tb.Cleanup(func() {
+		// The clients' spare keep-alive connections, which may never have
+		// sent a request, would hold up Shutdown.
+		http.DefaultClient.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := s.Shutdown(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			// Connections still open, like unread downloads, are cut off.
+			err = s.Close()
+		}
+		if err != nil {
+			tb.Error("error shutting down: ", err)
+		}
+	})
+
+	url := "http://" + ln.Addr().String()
+	return &Server{
+		Server: s,
+		URL:    url,
+		Client: client.New(client.Config{URL: url, SecretKey: config.SecretKey, MaxRetries: -1}),
+	}
+}
@@ -0,0 +1,185 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	headerContentMD5 = "Content-MD5"
+	headerDigest     = "Digest"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// blobHasher computes the checksums of a blob incrementally. Its state is
+// persisted between writes, so chunks uploaded in separate requests keep
+// extending the same checksums.
+type blobHasher struct {
+	sha256 hash.Hash
+	crc32c hash.Hash
+	md5    hash.Hash
+}
+
+// newBlobHasher restores a hasher from a state returned by blobHasher.state.
+// A nil state starts from an empty blob.
+func newBlobHasher(state []byte) (*blobHasher, error) {
+	me := &blobHasher{
+		sha256: sha256.New(),
+		crc32c: crc32.New(crc32cTable),
+		md5:    md5.New(),
+	}
+	for _, h := range me.hashes() {
+		if len(state) == 0 {
+			break
+		}
+		n, read := binary.Uvarint(state)
+		if read <= 0 || uint64(len(state)-read) < n {
+			return nil, errors.New("corrupted hash state")
+		}
+		state = state[read:]
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state[:n]); err != nil {
+			return nil, err
+		}
+		state = state[n:]
+	}
+	return me, nil
+}
+
+func (me *blobHasher) hashes() []hash.Hash {
+	return []hash.Hash{me.sha256, me.crc32c, me.md5}
+}
+
+func (me *blobHasher) Write(p []byte) (int, error) {
+	for _, h := range me.hashes() {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// state serializes the hasher, so it can be restored with newBlobHasher.
+func (me *blobHasher) state() ([]byte, error) {
+	var state []byte
+	for _, h := range me.hashes() {
+		b, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		state = binary.AppendUvarint(state, uint64(len(b)))
+		state = append(state, b...)
+	}
+	return state, nil
+}
+
+// sum sets the hex encoded checksums of everything written so far on blob.
+func (me *blobHasher) sum(blob *Blob) {
+	blob.Sha256 = hex.EncodeToString(me.sha256.Sum(nil))
+	blob.Crc32c = hex.EncodeToString(me.crc32c.Sum(nil))
+	blob.Md5 = hex.EncodeToString(me.md5.Sum(nil))
+}
+
+// hashContent returns a hasher of the first size bytes of the object at key.
+func (me *Server) hashContent(key string, size int) (*blobHasher, error) {
+	hasher, err := newBlobHasher(nil)
+	if err != nil {
+		return nil, err
+	}
+	file, err := me.storage.OpenReader(key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.Copy(hasher, io.NewSectionReader(file, 0, int64(size))); err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+// backfillChecksums hashes the blobs that were stored before their checksums
+// were recorded. It runs on startup, before any request is served.
+func (me *Server) backfillChecksums() error {
+	blobs, err := me.metadata.getBlobsWithoutChecksums()
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		hasher, err := me.hashContent(blobKey(blob.BucketId, blob.Id), blob.Size)
+		if err != nil {
+			return fmt.Errorf("blob %s/%s: %w", blob.BucketId, blob.Id, err)
+		}
+		state, err := hasher.state()
+		if err != nil {
+			return err
+		}
+		hasher.sum(blob)
+		if err := me.metadata.setBlobChecksums(blob, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyChunk checks chunk against the digests a client sent with it, in a
+// Content-MD5 header and/or a Digest header (RFC 3230) like "sha-256=<base64>".
+func verifyChunk(c *fiber.Ctx, chunk []byte) error {
+	if value := strings.TrimSpace(c.Get(headerContentMD5)); value != "" {
+		sum := md5.Sum(chunk)
+		if value != base64.StdEncoding.EncodeToString(sum[:]) {
+			return utils.BadRequestError("chunk doesn't match Content-MD5")
+		}
+	}
+
+	value := strings.TrimSpace(c.Get(headerDigest))
+	if value == "" {
+		return nil
+	}
+	for _, digest := range strings.Split(value, ",") {
+		algorithm, encoded, ok := strings.Cut(strings.TrimSpace(digest), "=")
+		if !ok {
+			return utils.BadRequestError("invalid value for header Digest")
+		}
+		expected, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return utils.BadRequestError("invalid value for header Digest")
+		}
+		var sum []byte
+		switch strings.ToLower(algorithm) {
+		case "sha-256":
+			s := sha256.Sum256(chunk)
+			sum = s[:]
+		case "md5":
+			s := md5.Sum(chunk)
+			sum = s[:]
+		default:
+			continue // unsupported algorithms are ignored, as RFC 3230 allows.
+		}
+		if !bytes.Equal(sum, expected) {
+			return utils.BadRequestError(fmt.Sprintf("chunk doesn't match %s digest", algorithm))
+		}
+	}
+	return nil
+}
+
+// setDigestHeader sets the Digest header of a full blob response.
+func setDigestHeader(c *fiber.Ctx, blob *Blob) error {
+	sum, err := hex.DecodeString(blob.Sha256)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	c.Set(headerDigest, "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	return nil
+}
@@ -0,0 +1,659 @@
+// Package client is a Go client of the blob server API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/assaidy/blob/types"
+	"github.com/assaidy/blob/utils"
+)
+
+const (
+	DefaultMaxRetries = 3
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+	DefaultURLExpiry  = time.Hour
+)
+
+// Config holds the configuration for initializing a Client instance.
+type Config struct {
+	URL        string        // Base URL of the server, like "http://localhost:3000".
+	SecretKey  string        // Root key of the server. Takes precedence over APIKey.
+	APIKey     string        // Token of an API key, like "<id>.<secret>".
+	HTTPClient *http.Client  // Defaults to http.DefaultClient.
+	MaxRetries int           // Retries of failed idempotent requests. Defaults to DefaultMaxRetries, negative disables them.
+	MinBackoff time.Duration // Wait before the first retry, doubled on every retry. Defaults to DefaultMinBackoff.
+	MaxBackoff time.Duration // Upper bound of the wait between retries. Defaults to DefaultMaxBackoff.
+}
+
+// Client calls the API of a blob server. It's safe for concurrent use.
+type Client struct {
+	config Config
+
+	mu   sync.Mutex
+	info *types.Info // Cached by Info.
+}
+
+// New initializes a new Client instance based on the provided configuration.
+func New(config Config) *Client {
+	config.URL = strings.TrimRight(config.URL, "/")
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	return &Client{config: config}
+}
+
+// StatusCode returns the HTTP status of an error returned by the server, or 0
+// if err didn't come from the server.
+func StatusCode(err error) int {
+	var apiE *utils.APIError
+	if errors.As(err, &apiE) {
+		return apiE.Code
+	}
+	return 0
+}
+
+// request is an API call. Its body is kept in memory, so it can be resent.
+type request struct {
+	method string
+	path   string // Relative to the base URL, may include a query.
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// do sends req, retrying it on network errors and transient server errors with
+// exponential backoff if it's idempotent. Responses with an error status are
+// returned along with their decoded *utils.APIError.
+func (me *Client) do(ctx context.Context, req request) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, body, err := me.send(ctx, req)
+		if err == nil && resp.StatusCode < 400 {
+			return resp, body, nil
+		}
+		if err == nil {
+			err = decodeError(resp, body)
+		}
+		if attempt >= me.config.MaxRetries || ctx.Err() != nil || !idempotent(req) || !retryable(resp) {
+			return resp, body, err
+		}
+
+		wait := me.backoff(attempt)
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+				wait = time.Duration(seconds) * time.Second
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, body, err
+		case <-timer.C:
+		}
+	}
+}
+
+// idempotent reports whether req may be sent again after failing, without
+// creating or changing anything twice. POSTs may have succeeded before the
+// error, e.g. creating a bucket, so they're sent once. PATCHes append at their
+// Upload-Offset, which the server checks.
+func idempotent(req request) bool {
+	switch req.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPatch:
+		return req.header.Get("Upload-Offset") != ""
+	}
+	return false
+}
+
+func (me *Client) send(ctx context.Context, req request) (*http.Response, []byte, error) {
+	target := me.config.URL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(req.body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	if me.config.SecretKey != "" {
+		httpReq.Header.Set("Secret-Key", me.config.SecretKey)
+	} else if me.config.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+me.config.APIKey)
+	}
+
+	resp, err := me.config.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+// retryable reports whether a failed request may succeed when it's resent. A
+// nil resp stands for a network error.
+func retryable(resp *http.Response) bool {
+	if resp == nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns how long to wait before a retry, with jitter so clients that
+// failed together don't retry together.
+func (me *Client) backoff(attempt int) time.Duration {
+	wait := me.config.MaxBackoff
+	if attempt < 32 {
+		wait = min(me.config.MinBackoff<<attempt, me.config.MaxBackoff)
+	}
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// decodeError returns the *utils.APIError of an error response. Errors not sent
+// as JSON, like those of proxies, keep their body as the message.
+func decodeError(resp *http.Response, body []byte) error {
+	apiE := &utils.APIError{}
+	if err := json.Unmarshal(body, apiE); err != nil || apiE.Message == "" {
+		apiE.Message = strings.TrimSpace(string(body))
+	}
+	if apiE.Message == "" {
+		apiE.Message = http.StatusText(resp.StatusCode)
+	}
+	apiE.Code = resp.StatusCode
+	return apiE
+}
+
+// doJSON sends req and decodes the JSON body of its response into out, unless
+// it's nil.
+func (me *Client) doJSON(ctx context.Context, req request, out any) error {
+	_, body, err := me.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// Info returns the limits of the server. It's fetched once and cached.
+func (me *Client) Info(ctx context.Context) (*types.Info, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.info != nil {
+		return me.info, nil
+	}
+	info := &types.Info{}
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: "/info"}, info); err != nil {
+		return nil, err
+	}
+	me.info = info
+	return info, nil
+}
+
+// CreateBucket creates a bucket with a policy, which defaults to types.PolicyPrivate if empty.
+func (me *Client) CreateBucket(ctx context.Context, bucketId, policy string) (*types.Bucket, error) {
+	query := url.Values{"bucket_id": {bucketId}}
+	if policy != "" {
+		query.Set("policy", policy)
+	}
+	bucket := &types.Bucket{}
+	if err := me.doJSON(ctx, request{method: http.MethodPost, path: "/buckets", query: query}, bucket); err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
+
+func (me *Client) GetAllBuckets(ctx context.Context) ([]*types.Bucket, error) {
+	var buckets []*types.Bucket
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: "/buckets"}, &buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+func (me *Client) GetBucket(ctx context.Context, bucketId string) (*types.Bucket, error) {
+	bucket := &types.Bucket{}
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: bucketPath(bucketId)}, bucket); err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
+
+func (me *Client) DeleteBucket(ctx context.Context, bucketId string) error {
+	return me.doJSON(ctx, request{method: http.MethodDelete, path: bucketPath(bucketId)}, nil)
+}
+
+func (me *Client) SetBucketPolicy(ctx context.Context, bucketId, policy string) error {
+	query := url.Values{"policy": {policy}}
+	return me.doJSON(ctx, request{method: http.MethodPut, path: bucketPath(bucketId) + "/policy", query: query}, nil)
+}
+
+// CreateBlob creates an empty blob with a content type, which defaults to
+// application/octet-stream if empty.
+func (me *Client) CreateBlob(ctx context.Context, bucketId, blobId, contentType string) error {
+	query := url.Values{"blob_id": {blobId}}
+	if contentType != "" {
+		query.Set("content_type", contentType)
+	}
+	return me.doJSON(ctx, request{method: http.MethodPost, path: bucketPath(bucketId) + "/blobs", query: query}, nil)
+}
+
+func (me *Client) GetAllBlobs(ctx context.Context, bucketId string) ([]*types.Blob, error) {
+	var blobs []*types.Blob
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: bucketPath(bucketId) + "/blobs"}, &blobs); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+func (me *Client) GetBlob(ctx context.Context, bucketId, blobId string) (*types.Blob, error) {
+	b := &types.Blob{}
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: blobPath(bucketId, blobId)}, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (me *Client) DeleteBlob(ctx context.Context, bucketId, blobId string) error {
+	return me.doJSON(ctx, request{method: http.MethodDelete, path: blobPath(bucketId, blobId)}, nil)
+}
+
+// WriteChunk appends chunk to a blob of offset bytes and returns its new size.
+// The chunk can't be bigger than the server's MaxChunkSize, see Writer.
+//
+// A retried chunk that conflicts with a blob that's already chunk bigger is
+// taken as written by a previous attempt whose response was lost.
+func (me *Client) WriteChunk(ctx context.Context, bucketId, blobId string, offset int, chunk []byte) (int, error) {
+	sum := md5.Sum(chunk)
+	header := http.Header{
+		"Upload-Offset": {strconv.Itoa(offset)},
+		"Content-Md5":   {base64.StdEncoding.EncodeToString(sum[:])},
+	}
+	resp, _, err := me.do(ctx, request{method: http.MethodPut, path: blobPath(bucketId, blobId), header: header, body: chunk})
+	if resp == nil {
+		return 0, err
+	}
+	size, parseErr := strconv.Atoi(resp.Header.Get("Upload-Offset"))
+	if err != nil {
+		if resp.StatusCode == http.StatusConflict && parseErr == nil && size == offset+len(chunk) {
+			return size, nil
+		}
+		return 0, err
+	}
+	if parseErr != nil {
+		return 0, errors.New("invalid Upload-Offset header in response")
+	}
+	return size, nil
+}
+
+// ReplaceBlob replaces the content of a blob with content at once and returns
+// its new size. The content can't be bigger than the server's MaxChunkSize.
+func (me *Client) ReplaceBlob(ctx context.Context, bucketId, blobId string, content []byte) (int, error) {
+	return me.putBlob(ctx, bucketId, blobId, "replace", http.Header{}, content)
+}
+
+// WriteAt writes data over the content of a blob at offset, which can't be
+// past its end, and returns its new size.
+func (me *Client) WriteAt(ctx context.Context, bucketId, blobId string, offset int, data []byte) (int, error) {
+	header := http.Header{"Upload-Offset": {strconv.Itoa(offset)}}
+	return me.putBlob(ctx, bucketId, blobId, "write", header, data)
+}
+
+func (me *Client) putBlob(ctx context.Context, bucketId, blobId, mode string, header http.Header, body []byte) (int, error) {
+	sum := md5.Sum(body)
+	header.Set("Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	query := url.Values{"mode": {mode}}
+	resp, _, err := me.do(ctx, request{method: http.MethodPut, path: blobPath(bucketId, blobId), query: query, header: header, body: body})
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.Atoi(resp.Header.Get("Upload-Offset"))
+	if err != nil {
+		return 0, errors.New("invalid Upload-Offset header in response")
+	}
+	return size, nil
+}
+
+// TruncateBlob cuts the content of a blob down to size bytes and returns it.
+func (me *Client) TruncateBlob(ctx context.Context, bucketId, blobId string, size int) (*types.Blob, error) {
+	query := url.Values{"size": {strconv.Itoa(size)}}
+	b := &types.Blob{}
+	if err := me.doJSON(ctx, request{method: http.MethodPost, path: blobPath(bucketId, blobId) + "/truncate", query: query}, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SealBlob marks a blob complete and returns it. A non-negative size and a
+// non-empty hex sha256 are checked against its content first.
+func (me *Client) SealBlob(ctx context.Context, bucketId, blobId string, size int, sha256 string) (*types.Blob, error) {
+	query := url.Values{}
+	if size >= 0 {
+		query.Set("size", strconv.Itoa(size))
+	}
+	if sha256 != "" {
+		query.Set("sha256", sha256)
+	}
+	b := &types.Blob{}
+	if err := me.doJSON(ctx, request{method: http.MethodPost, path: blobPath(bucketId, blobId) + "/seal", query: query}, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SetBucketVersioning enables or suspends keeping older versions of the blobs
+// of a bucket. Suspending it keeps the existing older versions.
+func (me *Client) SetBucketVersioning(ctx context.Context, bucketId string, enabled bool) error {
+	query := url.Values{"enabled": {strconv.FormatBool(enabled)}}
+	return me.doJSON(ctx, request{method: http.MethodPut, path: bucketPath(bucketId) + "/versioning", query: query}, nil)
+}
+
+// GetAllVersions returns every version of every blob of a bucket, by blob and
+// newest first.
+func (me *Client) GetAllVersions(ctx context.Context, bucketId string) ([]*types.Version, error) {
+	var versions []*types.Version
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: bucketPath(bucketId) + "/versions"}, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// GetVersions returns every version of a blob, newest first, including delete
+// markers of a deleted blob.
+func (me *Client) GetVersions(ctx context.Context, bucketId, blobId string) ([]*types.Version, error) {
+	var versions []*types.Version
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: blobPath(bucketId, blobId) + "/versions"}, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// RestoreVersion makes an older version of a blob its current content and
+// returns the blob.
+func (me *Client) RestoreVersion(ctx context.Context, bucketId, blobId, versionId string) (*types.Blob, error) {
+	b := &types.Blob{}
+	if err := me.doJSON(ctx, request{method: http.MethodPost, path: versionPath(bucketId, blobId, versionId) + "/restore"}, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// PurgeVersion permanently deletes an older version or delete marker of a blob.
+func (me *Client) PurgeVersion(ctx context.Context, bucketId, blobId, versionId string) error {
+	return me.doJSON(ctx, request{method: http.MethodDelete, path: versionPath(bucketId, blobId, versionId)}, nil)
+}
+
+// SetBucketObjectLock sets how a bucket locks the content created from now on.
+func (me *Client) SetBucketObjectLock(ctx context.Context, bucketId string, objectLock types.ObjectLock) error {
+	body, err := json.Marshal(objectLock)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	return me.doJSON(ctx, request{method: http.MethodPut, path: bucketPath(bucketId) + "/object-lock", header: header, body: body}, nil)
+}
+
+// SetBlobRetention retains a blob until retainUntil and returns it. An active
+// retention can only be extended; nil clears an expired one.
+func (me *Client) SetBlobRetention(ctx context.Context, bucketId, blobId string, retainUntil *time.Time) (*types.Blob, error) {
+	body, err := json.Marshal(map[string]any{"retainUntil": retainUntil})
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	b := &types.Blob{}
+	if err := me.doJSON(ctx, request{method: http.MethodPut, path: blobPath(bucketId, blobId) + "/retention", header: header, body: body}, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SetBlobLegalHold places or releases the legal hold of a blob and returns it.
+func (me *Client) SetBlobLegalHold(ctx context.Context, bucketId, blobId string, enabled bool) (*types.Blob, error) {
+	query := url.Values{"enabled": {strconv.FormatBool(enabled)}}
+	b := &types.Blob{}
+	if err := me.doJSON(ctx, request{method: http.MethodPut, path: blobPath(bucketId, blobId) + "/legal-hold", query: query}, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GetBucketLifecycle returns the lifecycle rules of a bucket.
+func (me *Client) GetBucketLifecycle(ctx context.Context, bucketId string) ([]*types.LifecycleRule, error) {
+	var rules []*types.LifecycleRule
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: bucketPath(bucketId) + "/lifecycle"}, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// SetBucketLifecycle replaces the lifecycle rules of a bucket. No rules remove
+// them.
+func (me *Client) SetBucketLifecycle(ctx context.Context, bucketId string, rules []*types.LifecycleRule) error {
+	return me.setBucketLifecycle(ctx, bucketId, rules, nil, nil)
+}
+
+// PreviewBucketLifecycle returns what rules would do to a bucket at at,
+// without saving them.
+func (me *Client) PreviewBucketLifecycle(ctx context.Context, bucketId string, rules []*types.LifecycleRule, at time.Time) (*types.LifecycleActions, error) {
+	query := url.Values{"dry_run": {"true"}, "at": {at.UTC().Format(time.RFC3339)}}
+	actions := &types.LifecycleActions{}
+	if err := me.setBucketLifecycle(ctx, bucketId, rules, query, actions); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+func (me *Client) setBucketLifecycle(ctx context.Context, bucketId string, rules []*types.LifecycleRule, query url.Values, v any) error {
+	if rules == nil {
+		rules = []*types.LifecycleRule{}
+	}
+	body, err := json.Marshal(map[string]any{"rules": rules})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	return me.doJSON(ctx, request{method: http.MethodPut, path: bucketPath(bucketId) + "/lifecycle", query: query, header: header, body: body}, v)
+}
+
+// GetTrash returns the deleted buckets and blobs that can be restored, most
+// recently deleted first.
+func (me *Client) GetTrash(ctx context.Context) ([]*types.TrashItem, error) {
+	var items []*types.TrashItem
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: "/trash"}, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// RestoreTrashItem moves a deleted bucket or blob back out of the trash.
+func (me *Client) RestoreTrashItem(ctx context.Context, trashId string) error {
+	return me.doJSON(ctx, request{method: http.MethodPost, path: "/trash/" + trashId + "/restore"}, nil)
+}
+
+// PurgeTrashItem permanently deletes a deleted bucket or blob before it expires.
+func (me *Client) PurgeTrashItem(ctx context.Context, trashId string) error {
+	return me.doJSON(ctx, request{method: http.MethodDelete, path: "/trash/" + trashId}, nil)
+}
+
+// AccessOptions are the optional constraints of an access key. Zero values
+// leave them unset.
+type AccessOptions struct {
+	Scope        string        // types.ScopeDownload or types.ScopeUpload. Defaults to types.ScopeDownload.
+	ExpiresIn    time.Duration // Rounded down to seconds.
+	MaxDownloads int           // Download keys only.
+	MaxSize      int           // Upload keys only.
+	ContentType  string        // Upload keys only.
+}
+
+func (me *Client) CreateAccess(ctx context.Context, bucketId, blobId string, options AccessOptions) (*types.Access, error) {
+	query := url.Values{"bucket_id": {bucketId}, "blob_id": {blobId}}
+	if options.Scope != "" {
+		query.Set("scope", options.Scope)
+	}
+	if options.ExpiresIn > 0 {
+		query.Set("expires_in", strconv.Itoa(int(options.ExpiresIn/time.Second)))
+	}
+	if options.MaxDownloads > 0 {
+		query.Set("max_downloads", strconv.Itoa(options.MaxDownloads))
+	}
+	if options.MaxSize > 0 {
+		query.Set("max_size", strconv.Itoa(options.MaxSize))
+	}
+	if options.ContentType != "" {
+		query.Set("content_type", options.ContentType)
+	}
+	access := &types.Access{}
+	if err := me.doJSON(ctx, request{method: http.MethodPost, path: "/access", query: query}, access); err != nil {
+		return nil, err
+	}
+	return access, nil
+}
+
+func (me *Client) DeleteAccess(ctx context.Context, key string) error {
+	return me.doJSON(ctx, request{method: http.MethodDelete, path: "/access/" + url.PathEscape(key)}, nil)
+}
+
+// SignOptions are the optional constraints of a signed URL. Zero values leave
+// them unset.
+type SignOptions struct {
+	Scope       string        // types.ScopeDownload or types.ScopeUpload. Defaults to types.ScopeDownload.
+	ExpiresIn   time.Duration // Rounded down to seconds. Defaults to DefaultURLExpiry.
+	Range       string        // Download URLs only, like "0-1023".
+	IP          string        // The only client IP allowed to use the URL.
+	MaxSize     int           // Upload URLs only.
+	ContentType string        // Upload URLs only.
+}
+
+// SignedURL is a URL that grants access to a blob without credentials.
+type SignedURL struct {
+	URL       string    `json:"url"` // Absolute, unlike the path returned by the server.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (me *Client) SignURL(ctx context.Context, bucketId, blobId string, options SignOptions) (*SignedURL, error) {
+	signed, err := me.signURL(ctx, bucketId, blobId, options)
+	if err != nil {
+		return nil, err
+	}
+	signed.URL = me.config.URL + signed.URL
+	return signed, nil
+}
+
+// signURL is like SignURL, but the returned URL is relative to the base URL.
+func (me *Client) signURL(ctx context.Context, bucketId, blobId string, options SignOptions) (*SignedURL, error) {
+	if options.ExpiresIn <= 0 {
+		options.ExpiresIn = DefaultURLExpiry
+	}
+	query := url.Values{
+		"bucket_id":  {bucketId},
+		"blob_id":    {blobId},
+		"expires_in": {strconv.Itoa(int(options.ExpiresIn / time.Second))},
+	}
+	if options.Scope != "" {
+		query.Set("scope", options.Scope)
+	}
+	if options.Range != "" {
+		query.Set("range", options.Range)
+	}
+	if options.IP != "" {
+		query.Set("ip", options.IP)
+	}
+	if options.MaxSize > 0 {
+		query.Set("max_size", strconv.Itoa(options.MaxSize))
+	}
+	if options.ContentType != "" {
+		query.Set("content_type", options.ContentType)
+	}
+	signed := &SignedURL{}
+	if err := me.doJSON(ctx, request{method: http.MethodPost, path: "/signed-urls", query: query}, signed); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+func (me *Client) CreateAPIKey(ctx context.Context, name string, scopes, buckets []string) (*types.APIKey, error) {
+	body, err := json.Marshal(map[string]any{"name": name, "scopes": scopes, "buckets": buckets})
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	apiKey := &types.APIKey{}
+	if err := me.doJSON(ctx, request{method: http.MethodPost, path: "/keys", header: header, body: body}, apiKey); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+func (me *Client) GetAllAPIKeys(ctx context.Context) ([]*types.APIKey, error) {
+	var apiKeys []*types.APIKey
+	if err := me.doJSON(ctx, request{method: http.MethodGet, path: "/keys"}, &apiKeys); err != nil {
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+func (me *Client) DisableAPIKey(ctx context.Context, keyId string) error {
+	return me.doJSON(ctx, request{method: http.MethodPost, path: "/keys/" + url.PathEscape(keyId) + "/disable"}, nil)
+}
+
+func (me *Client) EnableAPIKey(ctx context.Context, keyId string) error {
+	return me.doJSON(ctx, request{method: http.MethodPost, path: "/keys/" + url.PathEscape(keyId) + "/enable"}, nil)
+}
+
+// RotateAPIKey replaces the secret of an API key and returns it with its new token.
+func (me *Client) RotateAPIKey(ctx context.Context, keyId string) (*types.APIKey, error) {
+	apiKey := &types.APIKey{}
+	if err := me.doJSON(ctx, request{method: http.MethodPost, path: "/keys/" + url.PathEscape(keyId) + "/rotate"}, apiKey); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+func bucketPath(bucketId string) string {
+	return "/buckets/" + url.PathEscape(bucketId)
+}
+
+func blobPath(bucketId, blobId string) string {
+	return bucketPath(bucketId) + "/blobs/" + url.PathEscape(blobId)
+}
+
+func versionPath(bucketId, blobId, versionId string) string {
+	return blobPath(bucketId, blobId) + "/versions/" + versionId
+}
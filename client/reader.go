@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// renewBefore is how long before its expiry a Reader signs a new URL.
+const renewBefore = time.Minute
+
+// Reader reads the content of a blob with ranged downloads, no bigger than the
+// server's MaxChunkSize. It implements io.ReaderAt, which is safe for concurrent
+// use, and io.ReadSeeker. Reads fail with 412 Precondition Failed once the blob
+// changes after the Reader was opened.
+type Reader struct {
+	client    *Client
+	ctx       context.Context
+	size      int64
+	etag      string
+	chunkSize int64
+	offset    int64 // Of Read and Seek.
+
+	mu        sync.Mutex
+	path      string
+	expiresAt time.Time                         // Zero if path doesn't expire.
+	renew     func() (string, time.Time, error) // Signs a new path, nil if path doesn't expire.
+}
+
+// NewReader opens the content of a blob for reading, through download URLs it
+// signs and renews as needed.
+func (me *Client) NewReader(ctx context.Context, bucketId, blobId string) (*Reader, error) {
+	renew := func() (string, time.Time, error) {
+		signed, err := me.signURL(ctx, bucketId, blobId, SignOptions{})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return signed.URL, signed.ExpiresAt, nil
+	}
+	path, expiresAt, err := renew()
+	if err != nil {
+		return nil, err
+	}
+	return me.newReader(ctx, path, expiresAt, renew)
+}
+
+// NewAccessReader opens the content of the blob of a download access key for
+// reading. Every chunk counts as a download of the key.
+func (me *Client) NewAccessReader(ctx context.Context, key string) (*Reader, error) {
+	return me.newReader(ctx, "/access/"+url.PathEscape(key), time.Time{}, nil)
+}
+
+func (me *Client) newReader(ctx context.Context, path string, expiresAt time.Time, renew func() (string, time.Time, error)) (*Reader, error) {
+	info, err := me.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := me.do(ctx, request{method: http.MethodHead, path: path})
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		client:    me,
+		ctx:       ctx,
+		size:      resp.ContentLength,
+		etag:      resp.Header.Get("ETag"),
+		chunkSize: int64(info.MaxChunkSize),
+		path:      path,
+		expiresAt: expiresAt,
+		renew:     renew,
+	}, nil
+}
+
+// Size returns the size of the blob when the Reader was opened.
+func (me *Reader) Size() int64 {
+	return me.size
+}
+
+// currentPath returns the path to download from, renewing it if it's about to
+// expire.
+func (me *Reader) currentPath() (string, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.renew != nil && time.Until(me.expiresAt) < renewBefore {
+		path, expiresAt, err := me.renew()
+		if err != nil {
+			return "", err
+		}
+		me.path, me.expiresAt = path, expiresAt
+	}
+	return me.path, nil
+}
+
+func (me *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("client.Reader.ReadAt: negative offset")
+	}
+	if off >= me.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), me.size)
+	n := 0
+	for pos := off; pos < end; {
+		path, err := me.currentPath()
+		if err != nil {
+			return n, err
+		}
+		last := min(pos+me.chunkSize, end) - 1
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", pos, last)}}
+		if me.etag != "" {
+			header.Set("If-Match", me.etag)
+		}
+		resp, body, err := me.client.do(me.ctx, request{method: http.MethodGet, path: path, header: header})
+		if err != nil {
+			return n, err
+		}
+		// Anything but the requested range, like the whole blob of a server
+		// ignoring the Range header, would be copied to the wrong offset.
+		contentRange := fmt.Sprintf("bytes %d-%d/%d", pos, last, me.size)
+		if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != contentRange {
+			return n, fmt.Errorf("client.Reader.ReadAt: expected 206 with Content-Range %q, got %d with %q", contentRange, resp.StatusCode, resp.Header.Get("Content-Range"))
+		}
+		if int64(len(body)) != last-pos+1 {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(p[n:], body)
+		pos += int64(len(body))
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (me *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := me.ReadAt(p, me.offset)
+	me.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (me *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += me.offset
+	case io.SeekEnd:
+		offset += me.size
+	default:
+		return 0, errors.New("client.Reader.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("client.Reader.Seek: negative position")
+	}
+	me.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"errors"
+)
+
+// Writer appends to a blob in chunks of the server's MaxChunkSize. Writes are
+// buffered until a chunk is full, so Close must be called to upload the rest.
+// After a failed upload, every call returns its error.
+type Writer struct {
+	client    *Client
+	ctx       context.Context
+	bucketId  string
+	blobId    string
+	chunkSize int
+	offset    int // Size of the blob, as of the last uploaded chunk.
+	buf       []byte
+	err       error
+}
+
+// NewWriter opens an existing blob for appending.
+func (me *Client) NewWriter(ctx context.Context, bucketId, blobId string) (*Writer, error) {
+	info, err := me.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := me.GetBlob(ctx, bucketId, blobId)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		client:    me,
+		ctx:       ctx,
+		bucketId:  bucketId,
+		blobId:    blobId,
+		chunkSize: info.MaxChunkSize,
+		offset:    blob.Size,
+		buf:       make([]byte, 0, info.MaxChunkSize),
+	}, nil
+}
+
+// Offset returns the size of the blob, as of the last uploaded chunk.
+func (me *Writer) Offset() int {
+	return me.offset
+}
+
+func (me *Writer) Write(p []byte) (int, error) {
+	if me.err != nil {
+		return 0, me.err
+	}
+	n := 0
+	for len(p) > 0 {
+		size := min(len(p), me.chunkSize-len(me.buf))
+		me.buf = append(me.buf, p[:size]...)
+		p, n = p[size:], n+size
+		if len(me.buf) == me.chunkSize {
+			if err := me.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close uploads the buffered rest of the writes.
+func (me *Writer) Close() error {
+	if me.err != nil {
+		return me.err
+	}
+	if err := me.flush(); err != nil {
+		return err
+	}
+	me.err = errors.New("client.Writer: write after close")
+	return nil
+}
+
+func (me *Writer) flush() error {
+	if len(me.buf) == 0 {
+		return nil
+	}
+	offset, err := me.client.WriteChunk(me.ctx, me.bucketId, me.blobId, me.offset, me.buf)
+	if err != nil {
+		me.err = err
+		return err
+	}
+	me.offset, me.buf = offset, me.buf[:0]
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/assaidy/blob/client"
+)
+
+func runAccessCreate(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("access create", flag.ContinueOnError)
+	var options client.AccessOptions
+	flags.StringVar(&options.Scope, "scope", "", "download or upload (default download)")
+	flags.DurationVar(&options.ExpiresIn, "expires-in", 0, "lifetime of the key, like 24h (default forever)")
+	flags.IntVar(&options.MaxDownloads, "max-downloads", 0, "downloads allowed with the key (default unlimited)")
+	flags.IntVar(&options.MaxSize, "max-size", 0, "size the blob may grow to with an upload key (default unlimited)")
+	flags.StringVar(&options.ContentType, "content-type", "", "content type of uploads with an upload key (default any)")
+	args, err := parseArgs(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	access, err := c.CreateAccess(ctx, args[0], args[1], options)
+	if err != nil {
+		return err
+	}
+	fmt.Println(access.Key)
+	return nil
+}
+
+func runAccessRevoke(ctx context.Context, c *client.Client, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("access revoke", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	if err := c.DeleteAccess(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("revoked access %s\n", args[0])
+	return nil
+}
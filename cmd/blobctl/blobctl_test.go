@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	"github.com/assaidy/blob/client"
+)
+
+func TestBlobctl(t *testing.T) {
+	t.Log("starting blob server...")
+	s := blobtest.NewServer(t, blob.ServerConfig{MaxChunkSize: 1 * blob.KB})
+	ctx := context.Background()
+	c := s.Client
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("This is a line.\n"), 300) // 4800 bytes, 5 chunks.
+	write := func(path string, content []byte) string {
+		t.Helper()
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	read := func(bucketId, blobId string) []byte {
+		t.Helper()
+		r, err := c.NewReader(ctx, bucketId, blobId)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", blobId, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", blobId, err)
+		}
+		return got
+	}
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// ========================================================
+
+	t.Log("resuming an interrupted upload...")
+	path := write("upload.txt", content)
+	if err := c.CreateBlob(ctx, "bucket1", "upload.txt", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket1", "upload.txt", 0, content[:1000]); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadFile(ctx, c, "bucket1", "upload.txt", path, "", false); err != nil {
+		t.Fatal("error resuming upload: ", err)
+	}
+	if got := read("bucket1", "upload.txt"); !bytes.Equal(got, content) {
+		t.Fatalf("expected the resumed upload to hold the file, got %d bytes", len(got))
+	}
+
+	t.Log("refusing to upload over another blob...")
+	other := write("other.txt", []byte("other content"))
+	if err := uploadFile(ctx, c, "bucket1", "upload.txt", other, "", false); err == nil {
+		t.Fatal("expected an upload over a blob without the start of the file to fail")
+	}
+	if err := uploadFile(ctx, c, "bucket1", "upload.txt", other, "", true); err != nil {
+		t.Fatal("error replacing upload: ", err)
+	}
+	if got := read("bucket1", "upload.txt"); string(got) != "other content" {
+		t.Fatalf("expected the replaced blob, got %q", got)
+	}
+
+	// ========================================================
+
+	t.Log("resuming an interrupted download...")
+	if err := uploadFile(ctx, c, "bucket1", "download.txt", path, "", false); err != nil {
+		t.Fatal(err)
+	}
+	partial := write("download.part", content[:2500])
+	if err := downloadFile(ctx, c, "bucket1", "download.txt", partial); err != nil {
+		t.Fatal("error resuming download: ", err)
+	}
+	if got, err := os.ReadFile(partial); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected the resumed download to hold the blob, got %d bytes, %v", len(got), err)
+	}
+
+	t.Log("detecting a corrupted download...")
+	corrupted := write("corrupted.part", []byte("This is not a line.\n"))
+	if err := downloadFile(ctx, c, "bucket1", "download.txt", corrupted); err == nil {
+		t.Fatal("expected resuming a file that isn't the start of the blob to fail")
+	}
+
+	// ========================================================
+
+	t.Log("syncing a directory...")
+	tree := t.TempDir()
+	files := map[string][]byte{
+		"a.txt":                 content,
+		"photos/cat.png":        []byte("meow"),
+		"odd names/what?#%.txt": []byte("odd"),
+	}
+	for name, content := range files {
+		path := filepath.Join(tree, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := runSync(ctx, c, []string{tree, "synced"}); err != nil {
+		t.Fatal("error syncing: ", err)
+	}
+	for name, content := range files {
+		if got := read("synced", name); !bytes.Equal(got, content) {
+			t.Fatalf("expected %s to be synced, got %q", name, got)
+		}
+	}
+
+	t.Log("syncing changes and deleting stale blobs...")
+	appended := append(bytes.Clone(content), "more"...)
+	if err := os.WriteFile(filepath.Join(tree, "a.txt"), appended, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tree, "photos", "cat.png"), []byte("purr"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(tree, "odd names", "what?#%.txt")); err != nil {
+		t.Fatal(err)
+	}
+	before, err := c.GetBlob(ctx, "synced", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runSync(ctx, c, []string{"-delete", tree, "synced"}); err != nil {
+		t.Fatal("error syncing: ", err)
+	}
+	if b, err := c.GetBlob(ctx, "synced", "a.txt"); err != nil || b.VersionId != before.VersionId || !bytes.Equal(read("synced", "a.txt"), appended) {
+		t.Fatalf("expected a.txt to be appended to, got %+v, %v", b, err)
+	}
+	if got := read("synced", "photos/cat.png"); string(got) != "purr" {
+		t.Fatalf("expected photos/cat.png to be replaced, got %q", got)
+	}
+	if _, err := c.GetBlob(ctx, "synced", "odd names/what?#%.txt"); client.StatusCode(err) != http.StatusNotFound {
+		t.Fatalf("expected the stale blob to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/assaidy/blob/client"
+	"github.com/assaidy/blob/types"
+)
+
+func runBlobList(ctx context.Context, c *client.Client, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("blob list", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	blobs, err := c.GetAllBlobs(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSIZE\tCONTENT TYPE\tUPDATED")
+	for _, b := range blobs {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", b.Id, b.Size, b.ContentType, b.UpdatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func runBlobUpload(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("blob upload", flag.ContinueOnError)
+	contentType := flags.String("content-type", "", "content type of a new blob (default by file extension)")
+	args, err := parseArgs(flags, args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	return uploadFile(ctx, c, args[0], args[1], args[2], *contentType, false)
+}
+
+// uploadFile uploads a file to a blob, which is created if it doesn't exist. A
+// blob that holds the start of the file, like after an interrupted upload, is
+// resumed. Any other blob is an error, unless replace is set.
+func uploadFile(ctx context.Context, c *client.Client, bucketId, blobId, path, contentType string, replace bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(path))
+	}
+
+	existing, err := c.GetBlob(ctx, bucketId, blobId)
+	if client.StatusCode(err) == http.StatusNotFound {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+	if existing != nil {
+		ok, err := startsWith(file, existing)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if !replace {
+				return fmt.Errorf("blob %s doesn't hold the start of %s, delete it first", blobId, path)
+			}
+			if err := c.DeleteBlob(ctx, bucketId, blobId); err != nil {
+				return err
+			}
+			existing = nil
+		}
+	}
+	if existing == nil {
+		if err := c.CreateBlob(ctx, bucketId, blobId, contentType); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.NewWriter(ctx, bucketId, blobId)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(int64(w.Offset()), io.SeekStart); err != nil {
+		return err
+	}
+	progress := newProgress(blobId, int64(w.Offset()), info.Size())
+	defer progress.done()
+	if _, err := io.Copy(w, io.TeeReader(file, progress)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// startsWith reports whether the content of a blob is the start of file, by its
+// SHA-256 checksum.
+func startsWith(file *os.File, b *types.Blob) (bool, error) {
+	hash := sha256.New()
+	n, err := io.Copy(hash, io.NewSectionReader(file, 0, int64(b.Size)))
+	if err != nil {
+		return false, err
+	}
+	return n == int64(b.Size) && hex.EncodeToString(hash.Sum(nil)) == b.Sha256, nil
+}
+
+func runBlobDownload(ctx context.Context, c *client.Client, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("blob download", flag.ContinueOnError), args, 2, 3)
+	if err != nil {
+		return err
+	}
+	bucketId, blobId := args[0], args[1]
+	path := filepath.Base(blobId)
+	if len(args) == 3 {
+		path = args[2]
+	}
+
+	return downloadFile(ctx, c, bucketId, blobId, path)
+}
+
+// downloadFile downloads a blob to a file. A file smaller than the blob, like
+// after an interrupted download, is resumed. The file is checked against the
+// blob's checksum once it's complete.
+func downloadFile(ctx context.Context, c *client.Client, bucketId, blobId, path string) error {
+	b, err := c.GetBlob(ctx, bucketId, blobId)
+	if err != nil {
+		return err
+	}
+	r, err := c.NewReader(ctx, bucketId, blobId)
+	if err != nil {
+		return err
+	}
+	info, err := c.Info(ctx)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > r.Size() {
+		return fmt.Errorf("%s is bigger than blob %s", path, blobId)
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	progress := newProgress(blobId, offset, r.Size())
+	// Every read is a ranged download, so they're as big as the server allows.
+	_, err = io.CopyBuffer(io.MultiWriter(file, progress), r, make([]byte, info.MaxChunkSize))
+	progress.done()
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, r.Size())); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != b.Sha256 {
+		return errors.New(path + " doesn't match the blob's checksum, delete it and download again")
+	}
+	return nil
+}
+
+func runBlobDelete(ctx context.Context, c *client.Client, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("blob delete", flag.ContinueOnError), args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	if err := c.DeleteBlob(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("deleted blob %s\n", args[1])
+	return nil
+}
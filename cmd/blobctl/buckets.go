@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/assaidy/blob/client"
+)
+
+func runBucketCreate(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("bucket create", flag.ContinueOnError)
+	policy := flags.String("policy", "", "private, public-read or public-list (default private)")
+	args, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := c.CreateBucket(ctx, args[0], *policy)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created bucket %s (%s)\n", bucket.Id, bucket.Policy)
+	return nil
+}
+
+func runBucketList(ctx context.Context, c *client.Client, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("bucket list", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+
+	buckets, err := c.GetAllBuckets(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPOLICY\tBLOBS\tCREATED")
+	for _, bucket := range buckets {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", bucket.Id, bucket.Policy, len(bucket.Blobs), bucket.CreatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func runBucketDelete(ctx context.Context, c *client.Client, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("bucket delete", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	if err := c.DeleteBucket(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("deleted bucket %s\n", args[0])
+	return nil
+}
@@ -0,0 +1,115 @@
+// Command blobctl runs a blob server and manages its buckets, blobs and access
+// keys from the command line.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/assaidy/blob/client"
+)
+
+// command is a subcommand like "bucket create". Its args don't include its name.
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *client.Client, args []string) error
+}
+
+var commands = map[string]command{
+	"serve":         {"serve [-config file] [flags]", runServe},
+	"bucket create": {"bucket create [-policy policy] <bucket>", runBucketCreate},
+	"bucket list":   {"bucket list", runBucketList},
+	"bucket delete": {"bucket delete <bucket>", runBucketDelete},
+	"blob list":     {"blob list <bucket>", runBlobList},
+	"blob upload":   {"blob upload [-content-type type] <bucket> <blob> <file>", runBlobUpload},
+	"blob download": {"blob download <bucket> <blob> [file]", runBlobDownload},
+	"blob delete":   {"blob delete <bucket> <blob>", runBlobDelete},
+	"access create": {"access create [-scope scope] [-expires-in duration] [-max-downloads n] <bucket> <blob>", runAccessCreate},
+	"access revoke": {"access revoke <key>", runAccessRevoke},
+	"sync":          {"sync [-delete] <dir> <bucket>", runSync},
+}
+
+// errUsage is returned by commands called with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	flags := flag.NewFlagSet("blobctl", flag.ExitOnError)
+	var config client.Config
+	flags.StringVar(&config.URL, "url", envOr("BLOB_URL", "http://localhost:3000"), "server URL ($BLOB_URL)")
+	flags.StringVar(&config.SecretKey, "secret-key", os.Getenv("BLOB_SECRET_KEY"), "root key of the server ($BLOB_SECRET_KEY)")
+	flags.StringVar(&config.APIKey, "api-key", os.Getenv("BLOB_API_KEY"), "token of an API key ($BLOB_API_KEY)")
+	flags.Usage = func() { usage(flags) }
+	flags.Parse(os.Args[1:])
+
+	name, cmd, args, ok := lookup(flags.Args())
+	if !ok {
+		usage(flags)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd.run(ctx, client.New(config), args); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "usage: blobctl %s\n", cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "blobctl %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// lookup finds the command named by the first one or two args.
+func lookup(args []string) (string, command, []string, bool) {
+	if len(args) >= 2 {
+		if cmd, ok := commands[args[0]+" "+args[1]]; ok {
+			return args[0] + " " + args[1], cmd, args[2:], true
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			return args[0], cmd, args[1:], true
+		}
+	}
+	return "", command{}, nil, false
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "usage: blobctl [flags] <command> [args]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	usages := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		usages = append(usages, cmd.usage)
+	}
+	sort.Strings(usages)
+	fmt.Fprintln(os.Stderr, "  "+strings.Join(usages, "\n  "))
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flags.PrintDefaults()
+}
+
+// parseArgs parses the flags of a command and checks it got between min and
+// max args.
+func parseArgs(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if flags.NArg() < min || flags.NArg() > max {
+		return nil, errUsage
+	}
+	return flags.Args(), nil
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
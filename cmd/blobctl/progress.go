@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// progress prints how much of a transfer is done to stderr, whenever it grows
+// by a percent. It's an io.Writer counting the transferred bytes.
+type progress struct {
+	name    string
+	current int64
+	total   int64
+	percent int64
+}
+
+func newProgress(name string, current, total int64) *progress {
+	me := &progress{name: name, current: current, total: total, percent: -1}
+	me.print()
+	return me
+}
+
+func (me *progress) Write(p []byte) (int, error) {
+	me.current += int64(len(p))
+	me.print()
+	return len(p), nil
+}
+
+func (me *progress) print() {
+	percent := int64(100)
+	if me.total > 0 {
+		percent = me.current * 100 / me.total
+	}
+	if percent == me.percent {
+		return
+	}
+	me.percent = percent
+	fmt.Fprintf(os.Stderr, "\r%s: %s / %s (%d%%)", me.name, formatSize(me.current), formatSize(me.total), percent)
+}
+
+// done ends the progress line.
+func (me *progress) done() {
+	fmt.Fprintln(os.Stderr)
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGT"[exp])
+}
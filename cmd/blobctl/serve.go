@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/assaidy/blob/client"
+	"github.com/assaidy/blob/internal/config"
+)
+
+// runServe runs a server like blobd does, configured by a YAML, JSON or TOML
+// file, BLOB_* environment variables and flags.
+func runServe(ctx context.Context, _ *client.Client, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configPath := flags.String("config", "", "YAML, JSON or TOML config file")
+	settings := config.RegisterFlags(flags)
+	if _, err := parseArgs(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*configPath, settings)
+	if err != nil {
+		return err
+	}
+	return cfg.Run(ctx)
+}
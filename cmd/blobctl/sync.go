@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+
+	"github.com/assaidy/blob/client"
+)
+
+// runSync uploads every file under a directory to a bucket, named by its slash
+// separated path relative to the directory, like "photos/cat.png". Unchanged
+// blobs are skipped.
+func runSync(ctx context.Context, c *client.Client, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	del := flags.Bool("delete", false, "delete blobs without a file in the directory")
+	args, err := parseArgs(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	dir, bucketId := args[0], args[1]
+
+	if _, err := c.GetBucket(ctx, bucketId); client.StatusCode(err) == http.StatusNotFound {
+		if _, err := c.CreateBucket(ctx, bucketId, ""); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	blobs, err := c.GetAllBlobs(ctx, bucketId)
+	if err != nil {
+		return err
+	}
+	stale := make(map[string]bool, len(blobs))
+	for _, b := range blobs {
+		stale[b.Id] = true
+	}
+
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		blobId := filepath.ToSlash(rel)
+		delete(stale, blobId)
+		return uploadFile(ctx, c, bucketId, blobId, path, "", true)
+	})
+	if err != nil {
+		return err
+	}
+
+	if *del {
+		for blobId := range stale {
+			if err := c.DeleteBlob(ctx, bucketId, blobId); err != nil {
+				return err
+			}
+			fmt.Printf("deleted blob %s\n", blobId)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Command blobd runs a blob server configured by a YAML, JSON or TOML file,
+// BLOB_* environment variables and flags, in increasing precedence. It shuts
+// down gracefully on SIGINT and SIGTERM.
+//
+// The secret key is a root key with every scope on every bucket, and it signs
+// URLs unless signingKey is set; blobd refuses to start without either. Change
+// the one below, keep the file private, or pass the key in BLOB_SECRET_KEY.
+//
+// A config file looks like:
+//
+//	addr: ":3000"
+//	maxChunkSize: 8MB
+//	secretKey: change-me
+//	rootDir: /var/lib/blob/blobs
+//	metadataDir: /var/lib/blob/metadata
+//	s3:
+//	  credentials:
+//	    AKIAEXAMPLE: secret
+//
+// Files whose name ends in ".toml" are TOML, with the same keys:
+//
+//	addr = ":3000"
+//	maxChunkSize = "8MB"
+//	secretKey = "change-me"
+//
+//	[s3.credentials]
+//	AKIAEXAMPLE = "secret"
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/assaidy/blob/internal/config"
+)
+
+func main() {
+	flags := flag.NewFlagSet("blobd", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("BLOB_CONFIG"), "YAML, JSON or TOML config file ($BLOB_CONFIG)")
+	settings := config.RegisterFlags(flags)
+	flags.Parse(os.Args[1:])
+
+	cfg, err := config.Load(*configPath, settings)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "blobd:", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cfg.Run(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "blobd:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Conditional requests follow RFC 9110 section 13. Blob contents are validated
+// by a strong ETag derived from their SHA-256 checksum, metadata responses by
+// a weak ETag derived from their JSON body.
+
+// blobETag returns the strong entity tag of a blob's content.
+func blobETag(blob *Blob) string {
+	return `"` + blob.Sha256 + `"`
+}
+
+// setValidators sets the ETag and Last-Modified headers of a response.
+func setValidators(c *fiber.Ctx, etag string, lastModified time.Time) {
+	c.Set(fiber.HeaderETag, etag)
+	if !lastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// evaluatePreconditions evaluates the conditional headers of a request against
+// the current validators of the target resource. It returns 0 if the request
+// may proceed, or the status (304 or 412) to respond with.
+func evaluatePreconditions(c *fiber.Ctx, etag string, lastModified time.Time) int {
+	lastModified = lastModified.Truncate(time.Second)
+	safe := c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead
+
+	if value := c.Get(fiber.HeaderIfMatch); value != "" {
+		if !matchETag(value, etag, false) {
+			return fiber.StatusPreconditionFailed
+		}
+	} else if date, ok := parseHTTPDate(c.Get(fiber.HeaderIfUnmodifiedSince)); ok && !lastModified.IsZero() {
+		if lastModified.After(date) {
+			return fiber.StatusPreconditionFailed
+		}
+	}
+
+	if value := c.Get(fiber.HeaderIfNoneMatch); value != "" {
+		if matchETag(value, etag, true) {
+			if safe {
+				return fiber.StatusNotModified
+			}
+			return fiber.StatusPreconditionFailed
+		}
+	} else if date, ok := parseHTTPDate(c.Get(fiber.HeaderIfModifiedSince)); ok && safe && !lastModified.IsZero() {
+		if !lastModified.After(date) {
+			return fiber.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// checkPreconditions is like evaluatePreconditions for requests that modify the
+// target resource, where any failed condition is an error.
+func checkPreconditions(c *fiber.Ctx, etag string, lastModified time.Time) error {
+	if evaluatePreconditions(c, etag, lastModified) != 0 {
+		return utils.PreconditionFailedError("precondition failed")
+	}
+	return nil
+}
+
+// ifRangeMatches reports whether a Range header should be honored given the
+// request's If-Range header, which is either an entity tag or a date.
+func ifRangeMatches(c *fiber.Ctx, etag string, lastModified time.Time) bool {
+	value := strings.TrimSpace(c.Get(fiber.HeaderIfRange))
+	if value == "" {
+		return true
+	}
+	if date, ok := parseHTTPDate(value); ok {
+		return lastModified.Truncate(time.Second).Equal(date)
+	}
+	return !strings.HasPrefix(value, "W/") && value == etag
+}
+
+// matchETag reports whether etag is in the comma separated list of entity tags
+// of a header. "*" matches any existing resource.
+func matchETag(list, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate, etag = strings.TrimPrefix(candidate, "W/"), strings.TrimPrefix(etag, "W/")
+		} else if strings.HasPrefix(candidate, "W/") || strings.HasPrefix(etag, "W/") {
+			continue
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// sendConditionalJSON responds with v as JSON, validated by a weak ETag of the
+// body and lastModified, unless it's zero. It responds with 304 Not Modified
+// if the client's copy is still fresh.
+func sendConditionalJSON(c *fiber.Ctx, v any, lastModified time.Time) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+
+	setValidators(c, etag, lastModified)
+	switch evaluatePreconditions(c, etag, lastModified) {
+	case fiber.StatusNotModified:
+		return sendNotModified(c)
+	case fiber.StatusPreconditionFailed:
+		return utils.PreconditionFailedError("precondition failed")
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Status(fiber.StatusOK).Send(body)
+}
+
+// sendNotModified responds with 304 Not Modified. Unlike c.SendStatus, it
+// doesn't set a body, which would override the Content-Length of the response.
+func sendNotModified(c *fiber.Ctx) error {
+	c.Status(fiber.StatusNotModified)
+	return nil
+}
@@ -0,0 +1,266 @@
+package blob
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/oklog/ulid/v2"
+)
+
+// API keys authenticate clients with "Authorization: Bearer <id>.<secret>".
+// Only a hash of the secret is stored. ServerConfig.SecretKey, sent in the
+// Secret-Key header, stays a root key with every scope on every bucket.
+
+const (
+	headerSecretKey    = "Secret-Key"
+	bearerPrefix       = "Bearer "
+	localsCredentials  = "credentials"
+	apiKeySecretLength = 32
+)
+
+// rootKey is the credentials of requests authenticated by ServerConfig.SecretKey.
+var rootKey = &APIKey{Id: "root", Name: "root", Scopes: []string{ScopeAdmin}, Buckets: []string{"*"}}
+
+type createAPIKeyRequest struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	Buckets []string `json:"buckets"`
+}
+
+// hasScope reports whether an API key was granted scope. Admin keys have every
+// scope.
+func hasScope(apiKey *APIKey, scope string) bool {
+	return slices.Contains(apiKey.Scopes, ScopeAdmin) || slices.Contains(apiKey.Scopes, scope)
+}
+
+// allowsBucket reports whether a bucket matches any of the patterns of an API
+// key.
+func allowsBucket(apiKey *APIKey, bucketId string) bool {
+	for _, pattern := range apiKey.Buckets {
+		if ok, _ := path.Match(pattern, bucketId); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAPIKeySecret returns the hex encoded hash of a secret. Secrets are random,
+// so a fast hash is enough.
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKeySecret() string {
+	b := make([]byte, apiKeySecretLength)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// mwWithCredentials is middleware that authenticates requests by the root
+// secret key or an API key, for authorization by mwWithScope.
+func (me *Server) mwWithCredentials(c *fiber.Ctx) error {
+	if key := strings.TrimSpace(c.Get(headerSecretKey)); key != "" {
+		if me.secretKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(me.secretKey)) != 1 {
+			return utils.UnauthorizedError()
+		}
+		c.Locals(localsCredentials, rootKey)
+		return c.Next()
+	}
+
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), bearerPrefix)
+	if !ok {
+		return utils.UnauthorizedError()
+	}
+	id, secret, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return utils.UnauthorizedError()
+	}
+	apiKey, secretHash, err := me.metadata.getAPIKey(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.UnauthorizedError()
+		}
+		return utils.InternalServerError(err)
+	}
+	if apiKey.Disabled || subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(secretHash)) != 1 {
+		return utils.UnauthorizedError()
+	}
+
+	c.Locals(localsCredentials, apiKey)
+	return c.Next()
+}
+
+// mwWithScope is middleware that authorizes requests whose credentials have
+// scope on the bucket of the route, if any.
+func (me *Server) mwWithScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		bucketId := pathParam(c, "bucket_id")
+		if bucketId == "" {
+			bucketId = strings.TrimSpace(c.Query("bucket_id"))
+		}
+		if err := authorize(c, scope, bucketId); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// authorize checks that the credentials of a request have scope on a bucket.
+// An empty bucketId only checks the scope.
+func authorize(c *fiber.Ctx, scope, bucketId string) error {
+	apiKey := credentials(c)
+	if !hasScope(apiKey, scope) {
+		return utils.ForbiddenError("api key lacks scope " + scope)
+	}
+	if bucketId != "" && !allowsBucket(apiKey, bucketId) {
+		return utils.ForbiddenError("api key isn't allowed on bucket " + bucketId)
+	}
+	return nil
+}
+
+// accessScope returns the API key scope needed to grant an access key or a
+// signed URL with scope.
+func accessScope(scope string) string {
+	if scope == ScopeUpload {
+		return ScopeWrite
+	}
+	return ScopeRead
+}
+
+// credentials returns the API key a request was authenticated with.
+func credentials(c *fiber.Ctx) *APIKey {
+	apiKey, _ := c.Locals(localsCredentials).(*APIKey)
+	if apiKey == nil {
+		return &APIKey{}
+	}
+	return apiKey
+}
+
+// mwWithKeyManagement is middleware that authorizes requests managing API keys,
+// which needs an admin key unrestricted to some buckets, so keys can't be used
+// to grant more than they have.
+func (me *Server) mwWithKeyManagement(c *fiber.Ctx) error {
+	apiKey := credentials(c)
+	if !hasScope(apiKey, ScopeAdmin) || !slices.Contains(apiKey.Buckets, "*") {
+		return utils.ForbiddenError("managing api keys needs an admin key on all buckets")
+	}
+	return c.Next()
+}
+
+func (me *Server) handleCreateAPIKey(c *fiber.Ctx) error {
+	var req createAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.InvalidJsonRequestError()
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return utils.BadRequestError("name must not be empty")
+	}
+	if len(req.Scopes) == 0 {
+		return utils.BadRequestError("scopes must not be empty")
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains([]string{ScopeRead, ScopeWrite, ScopeDelete, ScopeAdmin}, scope) {
+			return utils.BadRequestError("unknown scope " + scope)
+		}
+	}
+	if len(req.Buckets) == 0 {
+		return utils.BadRequestError("buckets must not be empty")
+	}
+	for _, pattern := range req.Buckets {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return utils.BadRequestError("invalid bucket pattern " + pattern)
+		}
+	}
+
+	secret := newAPIKeySecret()
+	apiKey := &APIKey{
+		Id:        ulid.Make().String(),
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		Buckets:   req.Buckets,
+		CreatedAt: time.Now().UTC(),
+	}
+	apiKey.RotatedAt = apiKey.CreatedAt
+
+	if err := me.metadata.createAPIKey(apiKey, hashAPIKeySecret(secret)); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	apiKey.Token = apiKey.Id + "." + secret
+	return c.Status(fiber.StatusCreated).JSON(apiKey)
+}
+
+func (me *Server) handleGetAllAPIKeys(c *fiber.Ctx) error {
+	apiKeys, err := me.metadata.getAllAPIKeys()
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	return c.Status(fiber.StatusOK).JSON(apiKeys)
+}
+
+func (me *Server) handleDisableAPIKey(c *fiber.Ctx) error {
+	return me.setAPIKeyDisabled(c, true)
+}
+
+func (me *Server) handleEnableAPIKey(c *fiber.Ctx) error {
+	return me.setAPIKeyDisabled(c, false)
+}
+
+func (me *Server) setAPIKeyDisabled(c *fiber.Ctx, disabled bool) error {
+	keyId := pathParam(c, "key_id")
+	if keyId == "" {
+		return utils.BadRequestError("invalid value for path param key_id")
+	}
+
+	if exists, err := me.metadata.checkIfAPIKeyExists(keyId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("api key not found")
+	}
+
+	if err := me.metadata.setAPIKeyDisabled(keyId, disabled); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleRotateAPIKey replaces the secret of an API key. The old secret stops
+// working immediately.
+func (me *Server) handleRotateAPIKey(c *fiber.Ctx) error {
+	keyId := pathParam(c, "key_id")
+	if keyId == "" {
+		return utils.BadRequestError("invalid value for path param key_id")
+	}
+
+	if exists, err := me.metadata.checkIfAPIKeyExists(keyId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("api key not found")
+	}
+
+	secret := newAPIKeySecret()
+	if err := me.metadata.rotateAPIKey(keyId, hashAPIKeySecret(secret), time.Now().UTC()); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	apiKey, _, err := me.metadata.getAPIKey(keyId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	apiKey.Token = apiKey.Id + "." + secret
+	return c.Status(fiber.StatusOK).JSON(apiKey)
+}
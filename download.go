@@ -0,0 +1,206 @@
+package blob
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gotd/contrib/http_range"
+)
+
+// setBlobHeaders sets the headers describing a blob's content, shared by GET
+// and HEAD responses.
+func setBlobHeaders(c *fiber.Ctx, blob *Blob) {
+	setValidators(c, blobETag(blob), blob.UpdatedAt)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set(fiber.HeaderContentType, blob.ContentType)
+	c.Set(headerUploadOffset, strconv.Itoa(blob.Size))
+}
+
+// serveBlobHead responds to a HEAD request for the content of a blob, with the
+// headers a GET request would get but without the body.
+func (me *Server) serveBlobHead(c *fiber.Ctx, blob *Blob) error {
+	setBlobHeaders(c, blob)
+	switch evaluatePreconditions(c, blobETag(blob), blob.UpdatedAt) {
+	case fiber.StatusNotModified:
+		return sendNotModified(c)
+	case fiber.StatusPreconditionFailed:
+		return utils.PreconditionFailedError("precondition failed")
+	}
+
+	// Content-Length survives since HEAD responses never carry a body.
+	c.Response().Header.SetContentLength(blob.Size)
+	c.Status(fiber.StatusOK)
+	return nil
+}
+
+// serveOptions restrict and account for what serveContent sends.
+type serveOptions struct {
+	// beforeSend is called, if set, right before a 200 or 206 response is sent.
+	// If it fails, its error is sent instead.
+	beforeSend func() error
+	// allowedRange, if set, is the only single byte range, like "0-1023", that
+	// may be sent. Requests without a range, or whose If-Range doesn't match,
+	// get it instead of the whole content.
+	allowedRange string
+}
+
+// serveBlob responds with the content of a blob, honoring conditional and range
+// requests. Several ranges are answered with a multipart/byteranges body.
+func (me *Server) serveBlob(c *fiber.Ctx, blob *Blob) error {
+	return me.serveContent(c, blob, blobKey(blob.BucketId, blob.Id), serveOptions{})
+}
+
+// serveContent is like serveBlob, with the content stored at key, e.g. that
+// of an older version, and options.
+func (me *Server) serveContent(c *fiber.Ctx, blob *Blob, key string, options serveOptions) error {
+	if options.beforeSend == nil {
+		options.beforeSend = func() error { return nil }
+	}
+
+	if err := me.checkDownloadable(blob); err != nil {
+		return err
+	}
+
+	etag := blobETag(blob)
+	setBlobHeaders(c, blob)
+	switch evaluatePreconditions(c, etag, blob.UpdatedAt) {
+	case fiber.StatusNotModified:
+		return sendNotModified(c)
+	case fiber.StatusPreconditionFailed:
+		return utils.PreconditionFailedError("precondition failed")
+	}
+
+	requestRange := strings.TrimSpace(c.Get("Range"))
+	if !ifRangeMatches(c, etag, blob.UpdatedAt) {
+		requestRange = "" // the client's copy is outdated -> it needs the whole file
+	}
+	if options.allowedRange != "" {
+		var err error
+		if requestRange, err = restrictRange(requestRange, options.allowedRange, int64(blob.Size)); err != nil {
+			return err
+		}
+	}
+	if requestRange == "" { // no range specified -> stream the whole file
+		file, err := me.storage.OpenReader(key)
+		if err != nil {
+			return storageError(err)
+		}
+
+		if err := setDigestHeader(c, blob); err != nil {
+			file.Close()
+			return err
+		}
+		if err := options.beforeSend(); err != nil {
+			file.Close()
+			return err
+		}
+
+		// the stream sets Content-Length and is closed once the body is sent.
+		return c.Status(fiber.StatusOK).SendStream(newSectionReadCloser(file, 0, int64(blob.Size)), blob.Size)
+	}
+
+	// handle ranged request
+	ranges, err := http_range.ParseRange(requestRange, int64(blob.Size))
+	if err != nil {
+		return utils.BadRequestError("invalid range header")
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		if r.Length > int64(me.maxChunkSize) {
+			return utils.BadRequestError("range length exceeds server's max chunk size")
+		}
+		file, err := me.storage.OpenReader(key)
+		if err != nil {
+			return storageError(err)
+		}
+		if err := options.beforeSend(); err != nil {
+			file.Close()
+			return err
+		}
+
+		c.Set(fiber.HeaderContentRange, r.ContentRange(int64(blob.Size)))
+
+		return c.Status(fiber.StatusPartialContent).SendStream(newSectionReadCloser(file, r.Start, r.Length), int(r.Length))
+	}
+
+	file, err := me.storage.OpenReader(key)
+	if err != nil {
+		return storageError(err)
+	}
+	body, size, boundary := multipartRanges(file, ranges, int64(blob.Size), blob.ContentType)
+	if size > int64(me.maxChunkSize) {
+		body.Close()
+		return utils.BadRequestError("ranges length exceeds server's max chunk size")
+	}
+	if err := options.beforeSend(); err != nil {
+		body.Close()
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "multipart/byteranges; boundary="+boundary)
+
+	return c.Status(fiber.StatusPartialContent).SendStream(body, int(size))
+}
+
+// restrictRange checks that requestRange fits in allowed, returning allowed for
+// an empty requestRange.
+func restrictRange(requestRange, allowed string, size int64) (string, error) {
+	allowedRanges, err := http_range.ParseRange("bytes="+allowed, size)
+	if err != nil || len(allowedRanges) != 1 {
+		return "", utils.ForbiddenError("allowed range doesn't fit the blob")
+	}
+	if requestRange == "" {
+		return "bytes=" + allowed, nil
+	}
+
+	ranges, err := http_range.ParseRange(requestRange, size)
+	if err != nil {
+		return "", utils.BadRequestError("invalid range header")
+	}
+	start, end := allowedRanges[0].Start, allowedRanges[0].Start+allowedRanges[0].Length
+	for _, r := range ranges {
+		if r.Start < start || r.Start+r.Length > end {
+			return "", utils.ForbiddenError("range is outside of the allowed range")
+		}
+	}
+	return requestRange, nil
+}
+
+// multipartRanges returns a multipart/byteranges body of the given ranges of
+// file, along with its length and boundary. Closing the body closes file.
+func multipartRanges(file ReadAtCloser, ranges []http_range.Range, total int64, contentType string) (io.ReadCloser, int64, string) {
+	var (
+		buf     bytes.Buffer
+		mw      = multipart.NewWriter(&buf)
+		readers = make([]io.Reader, 0, 2*len(ranges)+1)
+		size    int64
+	)
+	// The writer only produces the boundaries and part headers; the ranges are
+	// read from file, in between them, while the body is sent.
+	for _, r := range ranges {
+		mw.CreatePart(textproto.MIMEHeader{
+			fiber.HeaderContentRange: {r.ContentRange(total)},
+			fiber.HeaderContentType:  {contentType},
+		})
+		readers = append(readers, bytes.NewReader(bytes.Clone(buf.Bytes())), io.NewSectionReader(file, r.Start, r.Length))
+		size += int64(buf.Len()) + r.Length
+		buf.Reset()
+	}
+	mw.Close()
+	readers = append(readers, bytes.NewReader(bytes.Clone(buf.Bytes())))
+	size += int64(buf.Len())
+
+	body := &struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(readers...), file}
+
+	return body, size, mw.Boundary()
+}
@@ -3,10 +3,12 @@ module github.com/assaidy/blob
 go 1.23.4
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/gofiber/fiber/v2 v2.52.6
 	github.com/gotd/contrib v0.21.0
 	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/oklog/ulid/v2 v2.1.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
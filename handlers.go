@@ -1,23 +1,57 @@
 package blob
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
+	"bytes"
+	"database/sql"
+	"errors"
+	"mime"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/assaidy/blob/utils"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gotd/contrib/http_range"
 	"github.com/oklog/ulid/v2"
 )
 
+// pathParam returns the unescaped value of a path param, so ids with characters
+// like "/", "?" or " " can be escaped in paths. Invalid escapes and control
+// characters give "".
+func pathParam(c *fiber.Ctx, name string) string {
+	value, err := url.PathUnescape(c.Params(name))
+	if err != nil || hasControl(value) {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+// hasControl reports whether s has control characters, like newlines, which
+// ids must not have.
+func hasControl(s string) bool {
+	return strings.ContainsFunc(s, unicode.IsControl)
+}
+
+// validBucketId reports whether id can name a bucket. Keys starting with "."
+// are reserved for internal storage, see uploadsPrefix.
+func validBucketId(id string) bool {
+	return id != "" && !strings.HasPrefix(id, ".") && !strings.Contains(id, "/") && !hasControl(id)
+}
+
+func (me *Server) handleGetInfo(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(Info{MaxChunkSize: int(me.maxChunkSize)})
+}
+
 func (me *Server) handleCreateBucket(c *fiber.Ctx) error {
 	bucketId := strings.TrimSpace(c.Query("bucket_id"))
 	if bucketId == "" {
 		return utils.BadRequestError("invalid value for query param bucket_id")
 	}
+	if !validBucketId(bucketId) {
+		return utils.BadRequestError("bucket_id must not start with '.' or contain '/' or control characters")
+	}
 
 	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
 		return utils.InternalServerError(err)
@@ -27,16 +61,28 @@ func (me *Server) handleCreateBucket(c *fiber.Ctx) error {
 
 	bucket := &Bucket{
 		Id:        bucketId,
+		Policy:    strings.TrimSpace(c.Query("policy", PolicyPrivate)),
 		CreatedAt: time.Now().UTC(),
 		Blobs:     []*Blob{},
 	}
-
-	if err := me.metadata.createBucket(bucket); err != nil {
-		return utils.InternalServerError(err)
+	if !validPolicy(bucket.Policy) {
+		return utils.BadRequestError("invalid value for query param policy")
+	}
+	if value := strings.TrimSpace(c.Query("versioning")); value != "" {
+		versioning, err := strconv.ParseBool(value)
+		if err != nil {
+			return utils.BadRequestError("invalid value for query param versioning")
+		}
+		bucket.Versioning = versioning
+	}
+	// Publishing a bucket takes the same scope as changing its policy.
+	if bucket.Policy != PolicyPrivate {
+		if err := authorize(c, ScopeAdmin, bucketId); err != nil {
+			return err
+		}
 	}
 
-	path := filepath.Join(me.rootDir, bucket.Id)
-	if err := os.Mkdir(path, os.ModePerm); err != nil {
+	if err := me.metadata.createBucket(bucket); err != nil {
 		return utils.InternalServerError(err)
 	}
 
@@ -48,13 +94,18 @@ func (me *Server) handleGetAllBuckets(c *fiber.Ctx) error {
 	if err != nil {
 		return utils.InternalServerError(err)
 	}
-	return c.Status(fiber.StatusOK).JSON(buckets)
+	// Only list the buckets the API key is allowed on.
+	apiKey := credentials(c)
+	buckets = slices.DeleteFunc(buckets, func(bucket *Bucket) bool {
+		return !allowsBucket(apiKey, bucket.Id)
+	})
+	return sendConditionalJSON(c, buckets, time.Time{})
 }
 
 func (me *Server) handleGetBucket(c *fiber.Ctx) error {
-	bucketId := strings.TrimSpace(c.Query("bucket_id"))
+	bucketId := pathParam(c, "bucket_id")
 	if bucketId == "" {
-		return utils.BadRequestError("invalid value for query param bucket_id")
+		return utils.BadRequestError("invalid value for path param bucket_id")
 	}
 
 	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
@@ -68,11 +119,18 @@ func (me *Server) handleGetBucket(c *fiber.Ctx) error {
 		return utils.InternalServerError(err)
 	}
 
-	return c.Status(fiber.StatusOK).JSON(bucket)
+	lastModified := bucket.CreatedAt
+	for _, blob := range bucket.Blobs {
+		if blob.UpdatedAt.After(lastModified) {
+			lastModified = blob.UpdatedAt
+		}
+	}
+
+	return sendConditionalJSON(c, bucket, lastModified)
 }
 
 func (me *Server) handleDeleteBucket(c *fiber.Ctx) error {
-	bucketId := strings.TrimSpace(c.Params("bucket_id"))
+	bucketId := pathParam(c, "bucket_id")
 	if bucketId == "" {
 		return utils.BadRequestError("invalid value for query param bucket_id")
 	}
@@ -83,22 +141,29 @@ func (me *Server) handleDeleteBucket(c *fiber.Ctx) error {
 		return utils.NotFoundError("but not found")
 	}
 
-	if err := me.metadata.deleteBucket(bucketId); err != nil {
-		return utils.InternalServerError(err)
-	}
-
-	path := filepath.Join(me.rootDir, bucketId)
-	if err := os.RemoveAll(path); err != nil {
-		return utils.InternalServerError(err)
+	if err := me.removeBucket(bucketId); err != nil {
+		return err
 	}
 
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
+// deleteBucketContent deletes the content of every blob and older version in a
+// bucket.
+func (me *Server) deleteBucketContent(bucketId string) error {
+	for _, prefix := range []string{bucketPrefix(bucketId), versionsBucketPrefix(bucketId)} {
+		if err := me.storage.DeletePrefix(prefix); err != nil {
+			return storageError(err)
+		}
+	}
+	return nil
+}
+
 func (me *Server) handleCreateBlob(c *fiber.Ctx) error {
 	var (
-		bucketId = strings.TrimSpace(c.Params("bucket_id"))
-		blobId   = strings.TrimSpace(c.Query("blob_id"))
+		bucketId    = pathParam(c, "bucket_id")
+		blobId      = strings.TrimSpace(c.Query("blob_id"))
+		contentType = strings.TrimSpace(c.Query("content_type", fiber.MIMEOctetStream))
 	)
 	if bucketId == "" {
 		return utils.BadRequestError("invalid value for path param bucket_id")
@@ -106,6 +171,9 @@ func (me *Server) handleCreateBlob(c *fiber.Ctx) error {
 	if blobId == "" {
 		return utils.BadRequestError("invalid value for query param blob_id")
 	}
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return utils.BadRequestError("invalid value for query param content_type")
+	}
 
 	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
 		return utils.InternalServerError(err)
@@ -113,30 +181,73 @@ func (me *Server) handleCreateBlob(c *fiber.Ctx) error {
 		return utils.NotFoundError("bucket not found")
 	}
 
+	overwrite := false
+	if value := strings.TrimSpace(c.Query("overwrite")); value != "" {
+		var err error
+		if overwrite, err = strconv.ParseBool(value); err != nil {
+			return utils.BadRequestError("invalid value for query param overwrite")
+		}
+	}
+
 	if exists, err := me.metadata.checkIfBlobExists(bucketId, blobId); err != nil {
 		return utils.InternalServerError(err)
-	} else if exists {
+	} else if exists && !overwrite {
 		return utils.ConflictError("blob already exists")
+	} else if exists {
+		// Overwriting starts a new, open version of the blob, and the old content
+		// is kept in versioned buckets. Sealed blobs are complete for good.
+		if _, err := me.replaceBlob(bucketId, blobId, contentType, BlobOpen, bytes.NewReader(nil), checkOpen(), checkWritePreconditions(c)); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusCreated)
 	}
 
+	if _, err := me.createEmptyBlob(bucketId, blobId, contentType); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// createEmptyBlob creates a blob without content.
+func (me *Server) createEmptyBlob(bucketId, blobId, contentType string) (*Blob, error) {
 	blob := &Blob{
-		Id:        blobId,
-		BucketId:  bucketId,
-		Size:      0,
-		CreatedAt: time.Now().UTC(),
+		Id:          blobId,
+		BucketId:    bucketId,
+		Size:        0,
+		ContentType: contentType,
+		Version:     1,
+		VersionId:   newVersionId(),
+		State:       BlobOpen,
+		CreatedAt:   time.Now().UTC(),
+	}
+	blob.UpdatedAt = blob.CreatedAt
+	hasher, err := newBlobHasher(nil)
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+	hasher.sum(blob)
+
+	// Create the empty object upfront, so reads never race the first write.
+	file, err := me.storage.OpenAppend(blobKey(bucketId, blobId))
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, utils.InternalServerError(err)
 	}
 
 	if err := me.metadata.createBlob(blob); err != nil {
-		return utils.InternalServerError(err)
+		return nil, utils.InternalServerError(err)
 	}
 
-	return c.SendStatus(fiber.StatusCreated)
+	return blob, nil
 }
 
 func (me *Server) handleWriteToBlob(c *fiber.Ctx) error {
 	var (
-		bucketId = strings.TrimSpace(c.Params("bucket_id"))
-		blobId   = strings.TrimSpace(c.Params("blob_id"))
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
 	)
 	if bucketId == "" {
 		return utils.BadRequestError("invalid value for path param bucket_id")
@@ -151,28 +262,50 @@ func (me *Server) handleWriteToBlob(c *fiber.Ctx) error {
 		return utils.NotFoundError("blob not found")
 	}
 
-	path := filepath.Join(me.rootDir, bucketId, blobId)
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.ModePerm)
-	if err != nil {
-		return utils.InternalServerError(err)
+	switch strings.TrimSpace(c.Query("mode", writeModeAppend)) {
+	case writeModeAppend:
+		return me.writeToBlob(c, bucketId, blobId)
+	case writeModeReplace:
+		return me.replaceWithBody(c, bucketId, blobId)
+	case writeModeWrite:
+		return me.writeAtOffset(c, bucketId, blobId)
+	default:
+		return utils.BadRequestError("mode must be " + writeModeAppend + ", " + writeModeReplace + " or " + writeModeWrite)
 	}
-	defer file.Close()
+}
 
-	chunk := c.Body()
-	if _, err := file.Write(chunk); err != nil {
-		return utils.InternalServerError(err)
+// handleTruncateBlob cuts the content of a blob down to the size query param.
+func (me *Server) handleTruncateBlob(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(c.Query("size")))
+	if err != nil || size < 0 {
+		return utils.BadRequestError("invalid value for query param size")
 	}
 
-	// OPTIM: might replace with updateBlob() in future
-	if err := me.metadata.incrementBlobSize(bucketId, blobId, len(chunk)); err != nil {
-		return utils.InternalServerError(err)
+	blob, err := me.changeBlob(bucketId, blobId, func(key string, blob *Blob) (int, error) {
+		if err := me.storage.Truncate(key, int64(size)); err != nil {
+			return 0, storageError(err)
+		}
+		return size, nil
+	}, checkWithinSize(size), checkWritePreconditions(c))
+	if err != nil {
+		return err
 	}
 
-	return c.SendStatus(fiber.StatusOK)
+	return c.Status(fiber.StatusOK).JSON(blob)
 }
 
 func (me *Server) handleGetAllBlobs(c *fiber.Ctx) error {
-	bucketId := strings.TrimSpace(c.Params("bucket_id"))
+	bucketId := pathParam(c, "bucket_id")
 	if bucketId == "" {
 		return utils.BadRequestError("invalid value for path param bucket_id")
 	}
@@ -188,13 +321,13 @@ func (me *Server) handleGetAllBlobs(c *fiber.Ctx) error {
 		return utils.InternalServerError(err)
 	}
 
-	return c.Status(fiber.StatusOK).JSON(blobs)
+	return sendConditionalJSON(c, blobs, time.Time{})
 }
 
 func (me *Server) handleGetBlob(c *fiber.Ctx) error {
 	var (
-		bucketId = strings.TrimSpace(c.Params("bucket_id"))
-		blobId   = strings.TrimSpace(c.Params("blob_id"))
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
 	)
 	if bucketId == "" {
 		return utils.BadRequestError("invalid value for path param bucket_id")
@@ -214,13 +347,13 @@ func (me *Server) handleGetBlob(c *fiber.Ctx) error {
 		return utils.InternalServerError(err)
 	}
 
-	return c.Status(fiber.StatusOK).JSON(blob)
+	return sendConditionalJSON(c, blob, blob.UpdatedAt)
 }
 
-func (me *Server) handleDeleteBlob(c *fiber.Ctx) error {
+func (me *Server) handleHeadBlob(c *fiber.Ctx) error {
 	var (
-		bucketId = strings.TrimSpace(c.Params("bucket_id"))
-		blobId   = strings.TrimSpace(c.Params("blob_id"))
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
 	)
 	if bucketId == "" {
 		return utils.BadRequestError("invalid value for path param bucket_id")
@@ -235,18 +368,109 @@ func (me *Server) handleDeleteBlob(c *fiber.Ctx) error {
 		return utils.NotFoundError("blob not found")
 	}
 
-	if err := me.metadata.deleteBlob(bucketId, blobId); err != nil {
+	blob, err := me.metadata.getBlob(bucketId, blobId)
+	if err != nil {
 		return utils.InternalServerError(err)
 	}
 
-	path := filepath.Join(me.rootDir, bucketId, blobId)
-	if err := os.Remove(path); err != nil {
+	// tus clients resume uploads based on these headers.
+	c.Set(headerTusResumable, tusVersion)
+	c.Set(headerUploadDeferLen, "1")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	return me.serveBlobHead(c, blob)
+}
+
+func (me *Server) handleDeleteBlob(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, blobId); err != nil {
 		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("blob not found")
+	}
+
+	if err := me.removeBlob(bucketId, blobId); err != nil {
+		return err
 	}
 
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
+// removeBlob deletes a blob along with its uploads and content. In versioned
+// buckets, the content is kept as an older version and a delete marker is
+// recorded. Otherwise, the blob is moved into the trash unless it's disabled.
+// Locked blobs aren't deleted, and checks run before anything is.
+func (me *Server) removeBlob(bucketId, blobId string, checks ...blobCheck) error {
+	unlock := me.blobLocks.lock(blobKey(bucketId, blobId))
+	defer unlock()
+
+	blob, err := me.metadata.getBlob(bucketId, blobId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.NotFoundError("blob not found")
+		}
+		return utils.InternalServerError(err)
+	}
+	checks = append([]blobCheck{checkUnlocked(time.Now().UTC())}, checks...)
+	for _, check := range checks {
+		if err := check(blob); err != nil {
+			return err
+		}
+	}
+
+	uploads, err := me.metadata.getUploadsPerBlob(bucketId, blobId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	if err := me.discardUploads(uploads...); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if versioning, err := me.metadata.getBucketVersioning(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !versioning && me.trashRetention > 0 {
+		return me.trashBlob(blob, now)
+	}
+
+	versioned, err := me.archiveContent(blob, now)
+	if err != nil {
+		return err
+	}
+	if !versioned {
+		if err := me.storage.Delete(blobKey(bucketId, blobId)); err != nil {
+			return storageError(err)
+		}
+	}
+
+	if err := me.metadata.deleteBlob(bucketId, blobId); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	if versioned {
+		marker := &Version{
+			Blob:         Blob{Id: blobId, BucketId: bucketId, VersionId: newVersionId(), CreatedAt: now, UpdatedAt: now},
+			DeleteMarker: true,
+			ArchivedAt:   &now,
+		}
+		if err := me.metadata.createVersion(marker); err != nil {
+			return utils.InternalServerError(err)
+		}
+	}
+
+	return nil
+}
+
 func (me *Server) handleCreateAccess(c *fiber.Ctx) error {
 	var (
 		bucketId = strings.TrimSpace(c.Query("bucket_id"))
@@ -259,17 +483,88 @@ func (me *Server) handleCreateAccess(c *fiber.Ctx) error {
 		return utils.BadRequestError("invalid value for query param blob_id")
 	}
 
+	access := &Access{
+		Key:       ulid.Make().String(),
+		BucketId:  bucketId,
+		BlobId:    blobId,
+		Scope:     ScopeDownload,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if value := strings.TrimSpace(c.Query("scope")); value != "" {
+		if value != ScopeDownload && value != ScopeUpload {
+			return utils.BadRequestError("scope must be " + ScopeDownload + " or " + ScopeUpload)
+		}
+		access.Scope = value
+	}
+	if err := authorize(c, accessScope(access.Scope), bucketId); err != nil {
+		return err
+	}
+
 	if exists, err := me.metadata.checkIfBlobExists(bucketId, blobId); err != nil {
 		return utils.InternalServerError(err)
 	} else if !exists {
 		return utils.NotFoundError("blob not found")
 	}
+	if access.Scope == ScopeDownload {
+		blob, err := me.metadata.getBlob(bucketId, blobId)
+		if err != nil {
+			return utils.InternalServerError(err)
+		}
+		if err := me.checkDownloadable(blob); err != nil {
+			return err
+		}
+	}
 
-	access := &Access{
-		Key:       ulid.Make().String(),
-		BucketId:  bucketId,
-		BlobId:    blobId,
-		CreatedAt: time.Now().UTC(),
+	if value := strings.TrimSpace(c.Query("expires_at")); value != "" {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil || !expiresAt.After(access.CreatedAt) {
+			return utils.BadRequestError("expires_at must be a future RFC 3339 time")
+		}
+		expiresAt = expiresAt.UTC()
+		access.ExpiresAt = &expiresAt
+	}
+	if value := strings.TrimSpace(c.Query("expires_in")); value != "" {
+		if access.ExpiresAt != nil {
+			return utils.BadRequestError("only one of expires_at and expires_in can be set")
+		}
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			return utils.BadRequestError("expires_in must be a positive number of seconds")
+		}
+		expiresAt := access.CreatedAt.Add(time.Duration(seconds) * time.Second)
+		access.ExpiresAt = &expiresAt
+	}
+	if value := strings.TrimSpace(c.Query("max_downloads")); value != "" {
+		if access.Scope != ScopeDownload {
+			return utils.BadRequestError("max_downloads is only valid for download keys")
+		}
+		maxDownloads, err := strconv.Atoi(value)
+		if err != nil || maxDownloads <= 0 {
+			return utils.BadRequestError("max_downloads must be a positive number")
+		}
+		remainingDownloads := maxDownloads
+		access.MaxDownloads, access.RemainingDownloads = &maxDownloads, &remainingDownloads
+	}
+	if value := strings.TrimSpace(c.Query("max_size")); value != "" {
+		if access.Scope != ScopeUpload {
+			return utils.BadRequestError("max_size is only valid for upload keys")
+		}
+		maxSize, err := strconv.Atoi(value)
+		if err != nil || maxSize < 0 {
+			return utils.BadRequestError("max_size must be a non-negative number of bytes")
+		}
+		access.MaxSize = &maxSize
+	}
+	if value := strings.TrimSpace(c.Query("content_type")); value != "" {
+		if access.Scope != ScopeUpload {
+			return utils.BadRequestError("content_type is only valid for upload keys")
+		}
+		mediaType, _, err := mime.ParseMediaType(value)
+		if err != nil {
+			return utils.BadRequestError("invalid value for query param content_type")
+		}
+		access.ContentType = &mediaType
 	}
 
 	if err := me.metadata.createAccess(access); err != nil {
@@ -279,18 +574,20 @@ func (me *Server) handleCreateAccess(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusCreated).JSON(access)
 }
 
+// handleDownloadWithAccess serves the blob of a download key. Every response
+// with content counts as a download, whole or ranged, so a key can't be read
+// more than its downloads allow in any way. Not modified, failed and rejected
+// requests don't count.
 func (me *Server) handleDownloadWithAccess(c *fiber.Ctx) error {
-	key := strings.TrimSpace(c.Params("key"))
+	key := pathParam(c, "key")
 	if key == "" {
 		return utils.BadRequestError("invalid value for path param key")
 	}
 
-	// OPTIM: these two queries might be summarized into
-	// one query with `blob, ok, err := me.metadata.getBlobInAccess(key)`
-	if exists, err := me.metadata.checkIfAccessExists(key); err != nil {
+	if ok, err := me.metadata.checkIfAccessDownloadable(key, time.Now().UTC()); err != nil {
 		return utils.InternalServerError(err)
-	} else if !exists {
-		return utils.NotFoundError("access not found")
+	} else if !ok {
+		return me.unusableAccessError(key, ScopeDownload)
 	}
 
 	blob, err := me.metadata.getBlobOfAccess(key)
@@ -298,58 +595,98 @@ func (me *Server) handleDownloadWithAccess(c *fiber.Ctx) error {
 		return utils.InternalServerError(err)
 	}
 
-	requestRange := strings.TrimSpace(c.Get("Range"))
-	if requestRange == "" { // no range specified -> send the whole file
-		path := filepath.Join(me.rootDir, blob.BucketId, blob.Id)
-
-		fileBytes, err := os.ReadFile(path)
-		if err != nil {
-			return utils.InternalServerError(err)
-		}
+	return me.serveContent(c, blob, blobKey(blob.BucketId, blob.Id), serveOptions{
+		beforeSend: func() error {
+			if ok, err := me.metadata.consumeAccess(key, time.Now().UTC()); err != nil {
+				return utils.InternalServerError(err)
+			} else if !ok {
+				return me.unusableAccessError(key, ScopeDownload)
+			}
+			return nil
+		},
+	})
+}
 
-		c.Set(fiber.HeaderContentLength, fmt.Sprintf("%d", blob.Size))
-		c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+func (me *Server) handleHeadWithAccess(c *fiber.Ctx) error {
+	key := pathParam(c, "key")
+	if key == "" {
+		return utils.BadRequestError("invalid value for path param key")
+	}
 
-		return c.Status(fiber.StatusOK).Send(fileBytes)
+	// Both download and upload keys may be used to check a blob, e.g. by tus
+	// clients resuming an upload.
+	if ok, err := me.metadata.checkIfAccessUsable(key, time.Now().UTC()); err != nil {
+		return utils.InternalServerError(err)
+	} else if !ok {
+		return me.unusableAccessError(key, "")
 	}
 
-	// handle ranged request
-	ranges, err := http_range.ParseRange(requestRange, int64(blob.Size))
+	blob, err := me.metadata.getBlobOfAccess(key)
 	if err != nil {
-		return utils.BadRequestError("invalid range header")
-	}
-	if len(ranges) > 1 {
-		return utils.BadRequestError("can only accept a single ragne")
+		return utils.InternalServerError(err)
 	}
-	r := ranges[0]
 
-	if r.Length > int64(me.maxChunkSize) {
-		return utils.BadRequestError("range length exceeds server's max chunk size")
+	return me.serveBlobHead(c, blob)
+}
+
+// handleUploadWithAccess appends to the blob of an upload key, with PUT like
+// handleWriteToBlob or with PATCH like handlePatchBlob.
+func (me *Server) handleUploadWithAccess(c *fiber.Ctx) error {
+	key := pathParam(c, "key")
+	if key == "" {
+		return utils.BadRequestError("invalid value for path param key")
 	}
 
-	path := filepath.Join(me.rootDir, blob.BucketId, blob.Id)
-	file, err := os.Open(path)
-	if err != nil {
+	if ok, err := me.metadata.checkIfAccessUsable(key, time.Now().UTC()); err != nil {
 		return utils.InternalServerError(err)
+	} else if !ok {
+		return me.unusableAccessError(key, ScopeUpload)
 	}
-	defer file.Close()
 
-	data := make([]byte, r.Length)
-	_, err = file.ReadAt(data, r.Start)
+	access, err := me.metadata.getAccess(key)
 	if err != nil {
 		return utils.InternalServerError(err)
 	}
+	if access.Scope != ScopeUpload {
+		return me.unusableAccessError(key, ScopeUpload)
+	}
+
+	var checks []blobCheck
+	if access.MaxSize != nil {
+		checks = append(checks, checkMaxSize(*access.MaxSize, len(c.Body())))
+	}
+	if access.ContentType != nil {
+		checks = append(checks, checkContentType(c, *access.ContentType))
+	}
 
-	c.Set(fiber.HeaderContentRange, r.ContentRange(int64(blob.Size)))
-	c.Set(fiber.HeaderAcceptRanges, "bytes")
-	c.Set(fiber.HeaderContentLength, fmt.Sprintf("%d", r.Length))
-	c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	if c.Method() == fiber.MethodPatch {
+		return me.patchBlob(c, access.BucketId, access.BlobId, checks...)
+	}
+	return me.writeToBlob(c, access.BucketId, access.BlobId, checks...)
+}
 
-	return c.Status(fiber.StatusPartialContent).Send(data)
+// unusableAccessError tells apart access keys that don't exist or lack scope
+// from those that expired or ran out of downloads, but weren't swept yet. An
+// empty scope accepts any.
+func (me *Server) unusableAccessError(key, scope string) error {
+	access, err := me.metadata.getAccess(key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.NotFoundError("access not found")
+		}
+		return utils.InternalServerError(err)
+	}
+	if scope != "" && access.Scope != scope {
+		return utils.ForbiddenError("access doesn't permit " + scope + "s")
+	}
+	if access.Scope == ScopeUpload {
+		return utils.GoneError("access expired")
+	}
+	return utils.GoneError("access expired or has no downloads left")
 }
 
 func (me *Server) handleDeleteAccess(c *fiber.Ctx) error {
-	key := strings.TrimSpace(c.Params("key"))
+	key := pathParam(c, "key")
 	if key == "" {
 		return utils.BadRequestError("invalid value for path param key")
 	}
@@ -360,6 +697,14 @@ func (me *Server) handleDeleteAccess(c *fiber.Ctx) error {
 		return utils.NotFoundError("access not found")
 	}
 
+	access, err := me.metadata.getAccess(key)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	if err := authorize(c, accessScope(access.Scope), access.BucketId); err != nil {
+		return err
+	}
+
 	if err := me.metadata.deleteAccess(key); err != nil {
 		return utils.InternalServerError(err)
 	}
@@ -0,0 +1,283 @@
+// Package config loads the configuration of a blob server binary from a YAML
+// or TOML file, BLOB_* environment variables and flags, in increasing
+// precedence, and runs the server.
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/assaidy/blob"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of a server binary. Sizes are human-readable,
+// like "8MB", and durations are like "30s".
+type Config struct {
+	Addr              string         `yaml:"addr"` // TCP address like ":3000", or Unix socket like "unix:/run/blob.sock".
+	MaxChunkSize      blob.DataUnite `yaml:"maxChunkSize"`
+	SecretKey         string         `yaml:"secretKey"`
+	SigningKey        string         `yaml:"signingKey"`
+	RootDir           string         `yaml:"rootDir"`
+	MetadataDir       string         `yaml:"metadataDir"`
+	SweepInterval     time.Duration  `yaml:"sweepInterval"`
+	TrashRetention    time.Duration  `yaml:"trashRetention"` // Negative makes deletes permanent.
+	LifecycleInterval time.Duration  `yaml:"lifecycleInterval"`
+	RequireSealed     bool           `yaml:"requireSealed"`   // Only serve blobs once they're sealed.
+	ShutdownTimeout   time.Duration  `yaml:"shutdownTimeout"` // How long open connections may take to finish on shutdown.
+	S3                *S3            `yaml:"s3"`              // Only set from the file.
+}
+
+type S3 struct {
+	Prefix      string            `yaml:"prefix"`
+	Region      string            `yaml:"region"`
+	Credentials map[string]string `yaml:"credentials"`
+}
+
+// Default returns the configuration before any file, variable or flag is applied.
+func Default() Config {
+	return Config{
+		Addr:            ":3000",
+		MaxChunkSize:    8 * blob.MB,
+		RootDir:         "./data/blobs",
+		MetadataDir:     "./data/metadata",
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// setting is a field of Config that can be set from an environment variable
+// and a flag. The variable of "max-chunk-size" is BLOB_MAX_CHUNK_SIZE.
+type setting struct {
+	name  string
+	usage string
+	set   func(config *Config, value string) error
+}
+
+var settings = []setting{
+	{"addr", "address to listen on, like :3000 or unix:/run/blob.sock", func(config *Config, value string) error {
+		config.Addr = value
+		return nil
+	}},
+	{"max-chunk-size", "max size of upload chunks and download ranges, like 8MB", func(config *Config, value string) error {
+		return config.MaxChunkSize.UnmarshalText([]byte(value))
+	}},
+	{"secret-key", "root key with every scope on every bucket", func(config *Config, value string) error {
+		config.SecretKey = value
+		return nil
+	}},
+	{"signing-key", "key of signed URLs (default secret-key)", func(config *Config, value string) error {
+		config.SigningKey = value
+		return nil
+	}},
+	{"root-dir", "directory of blob data", func(config *Config, value string) error {
+		config.RootDir = value
+		return nil
+	}},
+	{"metadata-dir", "directory of metadata", func(config *Config, value string) error {
+		config.MetadataDir = value
+		return nil
+	}},
+	{"sweep-interval", "interval of purging dead access keys and expired trash, like 1m", func(config *Config, value string) (err error) {
+		config.SweepInterval, err = time.ParseDuration(value)
+		return err
+	}},
+	{"trash-retention", "time deleted buckets and blobs can be restored, like 168h, or negative to delete permanently", func(config *Config, value string) (err error) {
+		config.TrashRetention, err = time.ParseDuration(value)
+		return err
+	}},
+	{"lifecycle-interval", "interval of applying the lifecycle rules of buckets, like 1h", func(config *Config, value string) (err error) {
+		config.LifecycleInterval, err = time.ParseDuration(value)
+		return err
+	}},
+	{"require-sealed", "only serve blobs once they're sealed, true or false", func(config *Config, value string) (err error) {
+		config.RequireSealed, err = strconv.ParseBool(value)
+		return err
+	}},
+	{"shutdown-timeout", "time open connections may take to finish on shutdown, like 30s", func(config *Config, value string) (err error) {
+		config.ShutdownTimeout, err = time.ParseDuration(value)
+		return err
+	}},
+}
+
+func envName(name string) string {
+	return "BLOB_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// LoadFile applies a TOML file if its name ends in ".toml", and a YAML file,
+// which may also be JSON, otherwise. Unknown fields are errors.
+func (me *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		// Keys match the YAML ones, like maxChunkSize.
+		meta, err := toml.Decode(string(data), me)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown field %s", path, undecoded[0])
+		}
+		return nil
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(me); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadEnv applies the environment variables of every setting that are set.
+func (me *Config) LoadEnv() error {
+	var errs []error
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(envName(s.name)); ok {
+			if err := s.set(me, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envName(s.name), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Flags are the values of the setting flags given on a command line.
+type Flags struct {
+	values []flagValue
+}
+
+type flagValue struct {
+	setting
+	value string
+}
+
+// RegisterFlags defines a flag for every setting. Their values are applied by
+// Flags.Apply, so they override the file and environment only when given.
+func RegisterFlags(flags *flag.FlagSet) *Flags {
+	me := &Flags{}
+	for _, s := range settings {
+		flags.Func(s.name, s.usage+" ($"+envName(s.name)+")", func(value string) error {
+			// Invalid values are reported while parsing, along with the flag.
+			if err := s.set(&Config{}, value); err != nil {
+				return err
+			}
+			me.values = append(me.values, flagValue{s, value})
+			return nil
+		})
+	}
+	return me
+}
+
+func (me *Flags) Apply(config *Config) {
+	for _, v := range me.values {
+		v.set(config, v.value) // Checked by RegisterFlags.
+	}
+}
+
+// Load returns the configuration of a server from the defaults, the file at
+// path unless it's empty, the environment and flags.
+func Load(path string, flags *Flags) (Config, error) {
+	config := Default()
+	if path != "" {
+		if err := config.LoadFile(path); err != nil {
+			return config, err
+		}
+	}
+	if err := config.LoadEnv(); err != nil {
+		return config, err
+	}
+	flags.Apply(&config)
+	return config, config.Validate()
+}
+
+// Validate reports every invalid field of the configuration.
+func (me Config) Validate() error {
+	var errs []error
+	if me.Addr == "" {
+		errs = append(errs, errors.New("Addr must be set"))
+	}
+	if me.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("ShutdownTimeout must not be negative"))
+	}
+	if err := me.ServerConfig().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Listen listens on Addr.
+func (me Config) Listen() (net.Listener, error) {
+	if path, ok := strings.CutPrefix(me.Addr, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", me.Addr)
+}
+
+// Run runs a server until it fails or ctx is done, then shuts it down within
+// ShutdownTimeout.
+func (me Config) Run(ctx context.Context) error {
+	ln, err := me.Listen()
+	if err != nil {
+		return err
+	}
+	server, err := blob.New(me.ServerConfig())
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(ln) }()
+	select {
+	case err := <-errs:
+		server.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), me.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return err
+	}
+	return nil
+}
+
+// ServerConfig returns the configuration of the server itself.
+func (me Config) ServerConfig() blob.ServerConfig {
+	config := blob.ServerConfig{
+		MaxChunkSize:      me.MaxChunkSize,
+		SecretKey:         me.SecretKey,
+		SigningKey:        me.SigningKey,
+		RootDir:           me.RootDir,
+		MetadataDir:       me.MetadataDir,
+		SweepInterval:     me.SweepInterval,
+		TrashRetention:    me.TrashRetention,
+		LifecycleInterval: me.LifecycleInterval,
+		RequireSealed:     me.RequireSealed,
+	}
+	if me.S3 != nil {
+		config.S3 = &blob.S3Config{
+			Prefix:      me.S3.Prefix,
+			Region:      me.S3.Region,
+			Credentials: me.S3.Credentials,
+		}
+	}
+	return config
+}
@@ -0,0 +1,38 @@
+package blob
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// DefaultSweepInterval is how often dead access keys and expired trash are
+// purged when ServerConfig.SweepInterval is unset.
+const DefaultSweepInterval = time.Minute
+
+// runPeriodically runs job every interval in the background, logging its
+// failures, until the server is closed.
+func (me *Server) runPeriodically(name string, interval time.Duration, job func() error) {
+	me.jobs.Add(1)
+	go func() {
+		defer me.jobs.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-me.done:
+				return
+			case <-ticker.C:
+			}
+			if err := job(); err != nil {
+				log.Errorf("%s: %v", name, err)
+			}
+		}
+	}()
+}
+
+// sweepAccesses deletes access keys that expired or have no downloads left.
+func (me *Server) sweepAccesses() error {
+	_, err := me.metadata.deleteDeadAccesses(time.Now().UTC())
+	return err
+}
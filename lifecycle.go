@@ -0,0 +1,248 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultLifecycleInterval is how often lifecycle rules are applied when
+// ServerConfig.LifecycleInterval is unset.
+const DefaultLifecycleInterval = time.Hour
+
+// maxLifecycleRules is the maximum number of lifecycle rules of a bucket.
+const maxLifecycleRules = 100
+
+// Lifecycle rules are stored with their bucket and applied in the background
+// every LifecycleInterval. A blob, version or upload is affected once any rule
+// matching its blob id says it's old enough. Expired blobs are deleted like
+// DELETE requests do, so they're kept as older versions or moved into the
+// trash, and locked ones are skipped.
+
+func expireAfter(rule *LifecycleRule) time.Duration {
+	return time.Duration(rule.ExpireAfterDays) * 24 * time.Hour
+}
+
+func purgeVersionsAfter(rule *LifecycleRule) time.Duration {
+	return time.Duration(rule.PurgeVersionsAfterDays) * 24 * time.Hour
+}
+
+func abortUploadsAfter(rule *LifecycleRule) time.Duration {
+	return time.Duration(rule.AbortUploadsAfterHours) * time.Hour
+}
+
+// lifecycleMatch reports whether any of rules applies at at to something of a
+// blob that started at since, given the age after which rules act on it.
+func lifecycleMatch(rules []*LifecycleRule, age func(*LifecycleRule) time.Duration, blobId string, since, at time.Time) bool {
+	return slices.ContainsFunc(rules, func(rule *LifecycleRule) bool {
+		return age(rule) > 0 && strings.HasPrefix(blobId, rule.Prefix) && !at.Before(since.Add(age(rule)))
+	})
+}
+
+// validateLifecycleRules checks that rules have unique ids and at least one
+// action each.
+func validateLifecycleRules(rules []*LifecycleRule) error {
+	if len(rules) > maxLifecycleRules {
+		return utils.BadRequestError(fmt.Sprintf("a bucket can't have more than %d lifecycle rules", maxLifecycleRules))
+	}
+	ids := map[string]bool{}
+	for _, rule := range rules {
+		if rule == nil {
+			return utils.BadRequestError("lifecycle rules must not be null")
+		}
+		rule.Id = strings.TrimSpace(rule.Id)
+		if rule.Id == "" {
+			return utils.BadRequestError("lifecycle rule ids must not be empty")
+		}
+		if ids[rule.Id] {
+			return utils.BadRequestError("duplicate lifecycle rule id " + rule.Id)
+		}
+		ids[rule.Id] = true
+		if rule.ExpireAfterDays < 0 || rule.PurgeVersionsAfterDays < 0 || rule.AbortUploadsAfterHours < 0 {
+			return utils.BadRequestError("lifecycle rule " + rule.Id + " has a negative age")
+		}
+		if rule.ExpireAfterDays == 0 && rule.PurgeVersionsAfterDays == 0 && rule.AbortUploadsAfterHours == 0 {
+			return utils.BadRequestError("lifecycle rule " + rule.Id + " has no action")
+		}
+	}
+	return nil
+}
+
+// planLifecycle returns what rules do to a bucket at at.
+func (me *Server) planLifecycle(bucketId string, rules []*LifecycleRule, at time.Time) (*LifecycleActions, error) {
+	blobs, err := me.metadata.getBlobsPerBucket(bucketId)
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+	versions, err := me.metadata.getVersionsPerBucket(bucketId)
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+	uploads, err := me.metadata.getUploadsPerBucket(bucketId)
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+
+	actions := &LifecycleActions{
+		ExpiredBlobs:   []*Blob{},
+		PurgedVersions: []*Version{},
+		AbortedUploads: []*Upload{},
+	}
+	for _, blob := range blobs {
+		if !lockedAt(blob, at) && lifecycleMatch(rules, expireAfter, blob.Id, blob.UpdatedAt, at) {
+			actions.ExpiredBlobs = append(actions.ExpiredBlobs, blob)
+		}
+	}
+	for _, version := range versions {
+		if lifecycleMatch(rules, purgeVersionsAfter, version.Id, *version.ArchivedAt, at) {
+			actions.PurgedVersions = append(actions.PurgedVersions, version)
+		}
+	}
+	for _, upload := range uploads {
+		if lifecycleMatch(rules, abortUploadsAfter, upload.BlobId, upload.CreatedAt, at) {
+			actions.AbortedUploads = append(actions.AbortedUploads, upload)
+		}
+	}
+	return actions, nil
+}
+
+// applyLifecycle carries out the lifecycle rules of a bucket at at. Blobs that
+// changed or got locked and uploads that finished since they were planned are
+// left alone.
+func (me *Server) applyLifecycle(bucketId string, at time.Time) error {
+	rules, err := me.metadata.getBucketLifecycle(bucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	actions, err := me.planLifecycle(bucketId, rules, at)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	skip := func(err error) error {
+		var apiErr *utils.APIError
+		if errors.As(err, &apiErr) && (apiErr.Code == fiber.StatusNotFound || apiErr.Code == fiber.StatusConflict || apiErr.Code == fiber.StatusForbidden) {
+			return nil
+		}
+		return err
+	}
+	for _, planned := range actions.ExpiredBlobs {
+		err := me.removeBlob(bucketId, planned.Id, func(blob *Blob) error {
+			if !blob.UpdatedAt.Equal(planned.UpdatedAt) {
+				return utils.ConflictError("blob changed")
+			}
+			return nil
+		})
+		errs = append(errs, skip(err))
+	}
+	for _, version := range actions.PurgedVersions {
+		errs = append(errs, me.purgeVersion(bucketId, version.VersionId))
+	}
+	for _, upload := range actions.AbortedUploads {
+		errs = append(errs, skip(me.abortUpload(bucketId, upload.BlobId, upload.Id)))
+	}
+	return errors.Join(errs...)
+}
+
+// applyLifecycleRules applies the lifecycle rules of every bucket that has some.
+func (me *Server) applyLifecycleRules() error {
+	return me.ApplyLifecycle(time.Now().UTC())
+}
+
+// ApplyLifecycle applies the lifecycle rules of every bucket that has some as
+// if it were at, which the server does with the current time every
+// LifecycleInterval. Locks are still checked at the current time, so blobs
+// whose retention is only over at at are skipped.
+func (me *Server) ApplyLifecycle(at time.Time) error {
+	bucketIds, err := me.metadata.getBucketIdsWithLifecycle()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, bucketId := range bucketIds {
+		if err := me.applyLifecycle(bucketId, at.UTC()); err != nil {
+			errs = append(errs, fmt.Errorf("bucket %s: %w", bucketId, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (me *Server) handleGetBucketLifecycle(c *fiber.Ctx) error {
+	bucketId := pathParam(c, "bucket_id")
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("bucket not found")
+	}
+
+	rules, err := me.metadata.getBucketLifecycle(bucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return sendConditionalJSON(c, rules, time.Time{})
+}
+
+type setLifecycleRequest struct {
+	Rules []*LifecycleRule `json:"rules"`
+}
+
+// handleSetBucketLifecycle replaces the lifecycle rules of a bucket. With
+// dry_run, the rules aren't saved; what they would do at the time in the at
+// query param, or now, is returned instead.
+func (me *Server) handleSetBucketLifecycle(c *fiber.Ctx) error {
+	bucketId := pathParam(c, "bucket_id")
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	dryRun := c.QueryBool("dry_run")
+	at := time.Now().UTC()
+	if value := strings.TrimSpace(c.Query("at")); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return utils.BadRequestError("invalid value for query param at")
+		}
+		at = parsed.UTC()
+	}
+
+	var req setLifecycleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.InvalidJsonRequestError()
+	}
+	if req.Rules == nil {
+		req.Rules = []*LifecycleRule{}
+	}
+	if err := validateLifecycleRules(req.Rules); err != nil {
+		return err
+	}
+
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("bucket not found")
+	}
+
+	if dryRun {
+		actions, err := me.planLifecycle(bucketId, req.Rules, at)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusOK).JSON(actions)
+	}
+
+	if err := me.metadata.setBucketLifecycle(bucketId, req.Rules); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,61 @@
+package blob
+
+import (
+	"slices"
+	"sync"
+)
+
+// keyedMutex serializes work per key, e.g. writes to the same blob.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: map[string]*keyedMutexEntry{}}
+}
+
+// lock locks key and returns the function that unlocks it.
+func (me *keyedMutex) lock(key string) func() {
+	me.mu.Lock()
+	entry, ok := me.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{}
+		me.locks[key] = entry
+	}
+	entry.refs++
+	me.mu.Unlock()
+
+	entry.mu.Lock()
+
+	return func() {
+		entry.mu.Unlock()
+
+		me.mu.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(me.locks, key)
+		}
+		me.mu.Unlock()
+	}
+}
+
+// lockAll locks every key, in sorted order so callers locking overlapping keys
+// can't deadlock, and returns the function that unlocks them.
+func (me *keyedMutex) lockAll(keys []string) func() {
+	keys = slices.Compact(slices.Sorted(slices.Values(keys)))
+	unlocks := make([]func(), 0, len(keys))
+	for _, key := range keys {
+		unlocks = append(unlocks, me.lock(key))
+	}
+	return func() {
+		for _, unlock := range slices.Backward(unlocks) {
+			unlock()
+		}
+	}
+}
@@ -2,9 +2,12 @@ package blob
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -13,23 +16,32 @@ type metadataStorage struct {
 	db *sql.DB
 }
 
-func NewMetadataStorage(dir string) *metadataStorage {
-	db, err := sql.Open("sqlite3", filepath.Join(dir, "metadata.db"))
+func NewMetadataStorage(dir string) (*metadataStorage, error) {
+	// Foreign keys are off by default in SQLite; they are needed for the cascades.
+	dsn := filepath.Join(dir, "metadata.db") + "?_foreign_keys=on&_busy_timeout=5000"
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		panic(fmt.Sprintf("error connecting to db: %+v", err))
+		return nil, fmt.Errorf("error connecting to db: %w", err)
 	}
 
 	if err := db.Ping(); err != nil {
-		panic(fmt.Sprintf("error pinging db: %+v", err))
+		db.Close()
+		return nil, fmt.Errorf("error pinging db: %w", err)
 	}
 
 	metadata := &metadataStorage{db: db}
 
 	if err := metadata.migrate(); err != nil {
-		panic(fmt.Sprintf("error migrating db: %+v", err))
+		db.Close()
+		return nil, fmt.Errorf("error migrating db: %w", err)
 	}
 
-	return metadata
+	return metadata, nil
+}
+
+// close closes the database. Queries fail afterwards.
+func (me *metadataStorage) close() error {
+	return me.db.Close()
 }
 
 func (me *metadataStorage) checkIfBucketExists(id string) (bool, error) {
@@ -45,10 +57,10 @@ func (me *metadataStorage) checkIfBucketExists(id string) (bool, error) {
 
 func (me *metadataStorage) createBucket(bucket *Bucket) error {
 	query := `
-    INSERT INTO buckets (id, created_at)
-    VALUES (?, ?);
+    INSERT INTO buckets (id, policy, versioning, default_retention_days, allow_locked_appends, created_at)
+    VALUES (?, ?, ?, ?, ?, ?);
     `
-	if _, err := me.db.Exec(query, bucket.Id, bucket.CreatedAt); err != nil {
+	if _, err := me.db.Exec(query, bucket.Id, bucket.Policy, bucket.Versioning, bucket.ObjectLock.DefaultRetentionDays, bucket.ObjectLock.AllowAppends, bucket.CreatedAt); err != nil {
 		return err
 	}
 	return nil
@@ -56,10 +68,11 @@ func (me *metadataStorage) createBucket(bucket *Bucket) error {
 
 func (me *metadataStorage) createBlob(blob *Blob) error {
 	query := `
-    INSERT INTO blobs (id, bucket_id, size, created_at)
-    VALUES (?, ?, ?, ?);
+    INSERT INTO blobs (id, bucket_id, size, content_type, sha256, crc32c, md5, version, version_id, state, retain_until, legal_hold, created_at, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
     `
-	if _, err := me.db.Exec(query, blob.Id, blob.BucketId, blob.Size, blob.CreatedAt); err != nil {
+	if _, err := me.db.Exec(query, blob.Id, blob.BucketId, blob.Size, blob.ContentType, blob.Sha256, blob.Crc32c, blob.Md5, blob.Version, blob.VersionId, blob.State,
+		blob.RetainUntil, blob.LegalHold, blob.CreatedAt, blob.UpdatedAt); err != nil {
 		return err
 	}
 	return nil
@@ -69,6 +82,10 @@ func (me *metadataStorage) getAllBuckets() ([]*Bucket, error) {
 	query := `
     SELECT 
         id,
+        policy,
+        versioning,
+        default_retention_days,
+        allow_locked_appends,
         created_at
     FROM buckets;
     `
@@ -82,7 +99,7 @@ func (me *metadataStorage) getAllBuckets() ([]*Bucket, error) {
 
 	for rows.Next() {
 		bucket := &Bucket{}
-		if err := rows.Scan(&bucket.Id, &bucket.CreatedAt); err != nil {
+		if err := rows.Scan(&bucket.Id, &bucket.Policy, &bucket.Versioning, &bucket.ObjectLock.DefaultRetentionDays, &bucket.ObjectLock.AllowAppends, &bucket.CreatedAt); err != nil {
 			return nil, err
 		}
 		buckets = append(buckets, bucket)
@@ -105,12 +122,16 @@ func (me *metadataStorage) getAllBuckets() ([]*Bucket, error) {
 func (me *metadataStorage) getBucket(id string) (*Bucket, error) {
 	query := `
     SELECT
+        policy,
+        versioning,
+        default_retention_days,
+        allow_locked_appends,
         created_at
     FROM buckets
     WHERE id = ?;
     `
 	bucket := &Bucket{Id: id}
-	if err := me.db.QueryRow(query, id).Scan(&bucket.CreatedAt); err != nil {
+	if err := me.db.QueryRow(query, id).Scan(&bucket.Policy, &bucket.Versioning, &bucket.ObjectLock.DefaultRetentionDays, &bucket.ObjectLock.AllowAppends, &bucket.CreatedAt); err != nil {
 		return nil, err
 	}
 
@@ -123,6 +144,123 @@ func (me *metadataStorage) getBucket(id string) (*Bucket, error) {
 	return bucket, nil
 }
 
+func (me *metadataStorage) getBucketPolicy(id string) (string, error) {
+	query := `SELECT policy FROM buckets WHERE id = ?;`
+	var policy string
+	if err := me.db.QueryRow(query, id).Scan(&policy); err != nil {
+		return "", err
+	}
+	return policy, nil
+}
+
+func (me *metadataStorage) setBucketPolicy(id, policy string) error {
+	query := `UPDATE buckets SET policy = ? WHERE id = ?;`
+	if _, err := me.db.Exec(query, policy, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) getBucketVersioning(id string) (bool, error) {
+	query := `SELECT versioning FROM buckets WHERE id = ?;`
+	var versioning bool
+	if err := me.db.QueryRow(query, id).Scan(&versioning); err != nil {
+		return false, err
+	}
+	return versioning, nil
+}
+
+func (me *metadataStorage) setBucketVersioning(id string, versioning bool) error {
+	query := `UPDATE buckets SET versioning = ? WHERE id = ?;`
+	if _, err := me.db.Exec(query, versioning, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) getBucketObjectLock(id string) (*ObjectLock, error) {
+	query := `SELECT default_retention_days, allow_locked_appends FROM buckets WHERE id = ?;`
+	objectLock := &ObjectLock{}
+	if err := me.db.QueryRow(query, id).Scan(&objectLock.DefaultRetentionDays, &objectLock.AllowAppends); err != nil {
+		return nil, err
+	}
+	return objectLock, nil
+}
+
+func (me *metadataStorage) setBucketObjectLock(id string, objectLock *ObjectLock) error {
+	query := `UPDATE buckets SET default_retention_days = ?, allow_locked_appends = ? WHERE id = ?;`
+	if _, err := me.db.Exec(query, objectLock.DefaultRetentionDays, objectLock.AllowAppends, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkIfBucketHasLockedBlobs reports whether any blob of a bucket is under a
+// legal hold or retained past now.
+func (me *metadataStorage) checkIfBucketHasLockedBlobs(id string, now time.Time) (bool, error) {
+	query := `SELECT 1 FROM blobs WHERE bucket_id = ? AND (legal_hold OR retain_until > ?) LIMIT 1;`
+	if err := me.db.QueryRow(query, id, now).Scan(new(int)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (me *metadataStorage) getBucketLifecycle(id string) ([]*LifecycleRule, error) {
+	query := `SELECT lifecycle FROM buckets WHERE id = ?;`
+	var lifecycle []byte
+	if err := me.db.QueryRow(query, id).Scan(&lifecycle); err != nil {
+		return nil, err
+	}
+	rules := []*LifecycleRule{}
+	if lifecycle == nil {
+		return rules, nil
+	}
+	if err := json.Unmarshal(lifecycle, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (me *metadataStorage) setBucketLifecycle(id string, rules []*LifecycleRule) error {
+	lifecycle, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE buckets SET lifecycle = ? WHERE id = ?;`
+	if _, err := me.db.Exec(query, lifecycle, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getBucketIdsWithLifecycle returns the ids of the buckets that have lifecycle rules.
+func (me *metadataStorage) getBucketIdsWithLifecycle() ([]string, error) {
+	query := `SELECT id FROM buckets WHERE lifecycle IS NOT NULL AND lifecycle != '[]';`
+	rows, err := me.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
 func (me *metadataStorage) deleteBucket(id string) error {
 	query := `DELETE FROM buckets WHERE id = ?;`
 	if _, err := me.db.Exec(query, id); err != nil {
@@ -147,7 +285,17 @@ func (me *metadataStorage) getBlobsPerBucket(id string) ([]*Blob, error) {
     select
         id,
         size,
-        created_at
+        content_type,
+        sha256,
+        crc32c,
+        md5,
+        version,
+        version_id,
+        state,
+        retain_until,
+        legal_hold,
+        created_at,
+        updated_at
     FROM blobs
     WHERE bucket_id = ?;
     `
@@ -161,7 +309,8 @@ func (me *metadataStorage) getBlobsPerBucket(id string) ([]*Blob, error) {
 
 	for rows.Next() {
 		blob := &Blob{BucketId: id}
-		if err := rows.Scan(&blob.Id, &blob.Size, &blob.CreatedAt); err != nil {
+		if err := rows.Scan(&blob.Id, &blob.Size, &blob.ContentType, &blob.Sha256, &blob.Crc32c, &blob.Md5, &blob.Version, &blob.VersionId, &blob.State,
+			&blob.RetainUntil, &blob.LegalHold, &blob.CreatedAt, &blob.UpdatedAt); err != nil {
 			return nil, err
 		}
 		blobs = append(blobs, blob)
@@ -177,26 +326,100 @@ func (me *metadataStorage) getBlob(bucketId, blobId string) (*Blob, error) {
 	query := `
     SELECT 
         size,
-        created_at
+        content_type,
+        sha256,
+        crc32c,
+        md5,
+        version,
+        version_id,
+        state,
+        retain_until,
+        legal_hold,
+        created_at,
+        updated_at
     FROM blobs 
     WHERE id = ? AND bucket_id = ?;
     `
 	blob := &Blob{Id: blobId, BucketId: bucketId}
 
-	if err := me.db.QueryRow(query, blobId, bucketId).Scan(&blob.Size, &blob.CreatedAt); err != nil {
+	if err := me.db.QueryRow(query, blobId, bucketId).Scan(&blob.Size, &blob.ContentType, &blob.Sha256, &blob.Crc32c, &blob.Md5, &blob.Version, &blob.VersionId, &blob.State,
+		&blob.RetainUntil, &blob.LegalHold, &blob.CreatedAt, &blob.UpdatedAt); err != nil {
 		return nil, err
 	}
 
 	return blob, nil
 }
 
-func (me *metadataStorage) incrementBlobSize(bucketId, blobId string, amount int) error {
+func (me *metadataStorage) getBlobHashState(bucketId, blobId string) ([]byte, error) {
+	query := `SELECT hash_state FROM blobs WHERE id = ? AND bucket_id = ?;`
+	var state []byte
+	if err := me.db.QueryRow(query, blobId, bucketId).Scan(&state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// updateBlobContent records the size, checksums and version of a blob after its content changed.
+func (me *metadataStorage) updateBlobContent(blob *Blob, hashState []byte) error {
 	query := `
     UPDATE blobs 
-    SET size = size + ? 
+    SET size = ?, content_type = ?, sha256 = ?, crc32c = ?, md5 = ?, hash_state = ?, version = ?, version_id = ?, state = ?, retain_until = ?, updated_at = ?
     WHERE bucket_id = ? AND id = ?;
     `
-	if _, err := me.db.Exec(query, amount, bucketId, blobId); err != nil {
+	if _, err := me.db.Exec(query, blob.Size, blob.ContentType, blob.Sha256, blob.Crc32c, blob.Md5, hashState, blob.Version, blob.VersionId, blob.State, blob.RetainUntil, blob.UpdatedAt,
+		blob.BucketId, blob.Id); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getBlobsWithoutChecksums returns the ids and sizes of the blobs that were
+// stored before checksums were recorded.
+func (me *metadataStorage) getBlobsWithoutChecksums() ([]*Blob, error) {
+	query := `SELECT id, bucket_id, size FROM blobs WHERE sha256 = '';`
+	rows, err := me.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []*Blob{}
+	for rows.Next() {
+		blob := &Blob{}
+		if err := rows.Scan(&blob.Id, &blob.BucketId, &blob.Size); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
+
+// setBlobChecksums records the checksums of a blob without changing its version.
+func (me *metadataStorage) setBlobChecksums(blob *Blob, hashState []byte) error {
+	query := `UPDATE blobs SET sha256 = ?, crc32c = ?, md5 = ?, hash_state = ? WHERE bucket_id = ? AND id = ?;`
+	if _, err := me.db.Exec(query, blob.Sha256, blob.Crc32c, blob.Md5, hashState, blob.BucketId, blob.Id); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sealBlob records the state and retention of a sealed blob.
+func (me *metadataStorage) sealBlob(blob *Blob) error {
+	query := `UPDATE blobs SET state = ?, retain_until = ? WHERE bucket_id = ? AND id = ?;`
+	if _, err := me.db.Exec(query, blob.State, blob.RetainUntil, blob.BucketId, blob.Id); err != nil {
+		return err
+	}
+	return nil
+}
+
+// setBlobLock records the retention and legal hold of a blob.
+func (me *metadataStorage) setBlobLock(blob *Blob) error {
+	query := `UPDATE blobs SET retain_until = ?, legal_hold = ? WHERE bucket_id = ? AND id = ?;`
+	if _, err := me.db.Exec(query, blob.RetainUntil, blob.LegalHold, blob.BucketId, blob.Id); err != nil {
 		return err
 	}
 	return nil
@@ -210,20 +433,22 @@ func (me *metadataStorage) deleteBlob(bucketId, blobId string) error {
 	return nil
 }
 
-func (me *metadataStorage) createAccess(accessKey *Access) error {
+// createVersion records an older version or a delete marker of a blob.
+func (me *metadataStorage) createVersion(version *Version) error {
 	query := `
-    INSERT INTO accesses (key, bucket_id, blob_id, created_at)
-    VALUES (?, ?, ?, ?);
+    INSERT INTO blob_versions (version_id, bucket_id, blob_id, size, content_type, sha256, crc32c, md5, version, state, delete_marker, created_at, updated_at, archived_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
     `
-	if _, err := me.db.Exec(query, accessKey.Key, accessKey.BucketId, accessKey.BlobId, accessKey.CreatedAt); err != nil {
+	if _, err := me.db.Exec(query, version.VersionId, version.BucketId, version.Id, version.Size, version.ContentType, version.Sha256, version.Crc32c, version.Md5,
+		version.Version, version.State, version.DeleteMarker, version.CreatedAt, version.UpdatedAt, version.ArchivedAt); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (me *metadataStorage) checkIfAccessExists(key string) (bool, error) {
-	query := `SELECT 1 FROM accesses WHERE key = ?;`
-	if err := me.db.QueryRow(query, key).Scan(new(int)); err != nil {
+func (me *metadataStorage) checkIfVersionExists(bucketId, blobId, versionId string) (bool, error) {
+	query := `SELECT 1 FROM blob_versions WHERE version_id = ? AND bucket_id = ? AND blob_id = ?;`
+	if err := me.db.QueryRow(query, versionId, bucketId, blobId).Scan(new(int)); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false, nil
 		}
@@ -232,64 +457,1159 @@ func (me *metadataStorage) checkIfAccessExists(key string) (bool, error) {
 	return true, nil
 }
 
-func (me *metadataStorage) deleteAccess(key string) error {
-	query := `DELETE FROM accesses WHERE key = ?;`
-	if _, err := me.db.Exec(query, key); err != nil {
+func (me *metadataStorage) getVersion(versionId string) (*Version, error) {
+	query := `
+    SELECT
+        version_id,
+        bucket_id,
+        blob_id,
+        size,
+        content_type,
+        sha256,
+        crc32c,
+        md5,
+        version,
+        state,
+        delete_marker,
+        created_at,
+        updated_at,
+        archived_at
+    FROM blob_versions
+    WHERE version_id = ?;
+    `
+	return scanVersion(me.db.QueryRow(query, versionId))
+}
+
+// getVersionsPerBlob returns the older versions and delete markers of a blob,
+// newest first.
+func (me *metadataStorage) getVersionsPerBlob(bucketId, blobId string) ([]*Version, error) {
+	query := `
+    SELECT
+        version_id,
+        bucket_id,
+        blob_id,
+        size,
+        content_type,
+        sha256,
+        crc32c,
+        md5,
+        version,
+        state,
+        delete_marker,
+        created_at,
+        updated_at,
+        archived_at
+    FROM blob_versions
+    WHERE bucket_id = ? AND blob_id = ?
+    ORDER BY archived_at DESC, version_id DESC;
+    `
+	return me.queryVersions(query, bucketId, blobId)
+}
+
+// getVersionsPerBucket returns the older versions and delete markers of every
+// blob in a bucket, by blob and newest first.
+func (me *metadataStorage) getVersionsPerBucket(bucketId string) ([]*Version, error) {
+	query := `
+    SELECT
+        version_id,
+        bucket_id,
+        blob_id,
+        size,
+        content_type,
+        sha256,
+        crc32c,
+        md5,
+        version,
+        state,
+        delete_marker,
+        created_at,
+        updated_at,
+        archived_at
+    FROM blob_versions
+    WHERE bucket_id = ?
+    ORDER BY blob_id, archived_at DESC, version_id DESC;
+    `
+	return me.queryVersions(query, bucketId)
+}
+
+func (me *metadataStorage) queryVersions(query string, args ...any) ([]*Version, error) {
+	rows, err := me.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []*Version{}
+
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+func scanVersion(row interface{ Scan(...any) error }) (*Version, error) {
+	version := &Version{}
+	if err := row.Scan(&version.VersionId, &version.BucketId, &version.Id, &version.Size, &version.ContentType, &version.Sha256, &version.Crc32c, &version.Md5,
+		&version.Version, &version.State, &version.DeleteMarker, &version.CreatedAt, &version.UpdatedAt, &version.ArchivedAt); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func (me *metadataStorage) deleteVersion(versionId string) error {
+	query := `DELETE FROM blob_versions WHERE version_id = ?;`
+	if _, err := me.db.Exec(query, versionId); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (me *metadataStorage) getBlobOfAccess(key string) (*Blob, error) {
+// Columns copied between the tables of buckets, blobs and older versions and
+// their trashed_ shadow tables.
+const (
+	bucketColumns  = `id, policy, versioning, lifecycle, default_retention_days, allow_locked_appends, created_at`
+	blobColumns    = `id, bucket_id, size, content_type, sha256, crc32c, md5, hash_state, version, version_id, state, retain_until, legal_hold, created_at, updated_at`
+	versionColumns = `version_id, bucket_id, blob_id, size, content_type, sha256, crc32c, md5, version, state, delete_marker, created_at, updated_at, archived_at`
+)
+
+// trashBlob moves a blob into the trash, recording the storage keys its
+// content was moved from.
+func (me *metadataStorage) trashBlob(item *TrashItem, keys []string) error {
+	tx, err := me.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertTrashItem(tx, item, keys); err != nil {
+		return err
+	}
+	query := `INSERT INTO trashed_blobs (trash_id, ` + blobColumns + `) SELECT ?, ` + blobColumns + ` FROM blobs WHERE bucket_id = ? AND id = ?;`
+	if _, err := tx.Exec(query, item.Id, item.BucketId, item.BlobId); err != nil {
+		return err
+	}
+	query = `DELETE FROM blobs WHERE bucket_id = ? AND id = ?;`
+	if _, err := tx.Exec(query, item.BucketId, item.BlobId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// errBucketChanged is returned by trashBucket when blobs or versions were added
+// to or removed from the bucket since they were listed.
+var errBucketChanged = errors.New("bucket changed while it was trashed")
+
+// trashBucket moves a bucket into the trash along with its blobs and older
+// versions, recording the storage keys their content was moved from.
+func (me *metadataStorage) trashBucket(item *TrashItem, keys []string, blobs, versions int) error {
+	tx, err := me.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The bucket must still hold only the blobs and versions whose content was
+	// moved.
+	var blobCount, versionCount int
 	query := `
-    SELECT 
-        blobs.id,
-        blobs.bucket_id,
-        blobs.size,
-        blobs.created_at
-    FROM accesses
-    INNER JOIN blobs ON blobs.bucket_id = accesses.bucket_id AND blobs.id = accesses.blob_id
-    WHERE key = ?;
+    SELECT
+        (SELECT COUNT(*) FROM blobs WHERE bucket_id = ?),
+        (SELECT COUNT(*) FROM blob_versions WHERE bucket_id = ?);
     `
-	blob := &Blob{}
+	if err := tx.QueryRow(query, item.BucketId, item.BucketId).Scan(&blobCount, &versionCount); err != nil {
+		return err
+	}
+	if blobCount != blobs || versionCount != versions {
+		return errBucketChanged
+	}
 
-	if err := me.db.QueryRow(query, key).Scan(&blob.Id, &blob.BucketId, &blob.Size, &blob.CreatedAt); err != nil {
-		return nil, err
+	if err := insertTrashItem(tx, item, keys); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		`INSERT INTO trashed_buckets (trash_id, ` + bucketColumns + `) SELECT ?, ` + bucketColumns + ` FROM buckets WHERE id = ?;`,
+		`INSERT INTO trashed_blobs (trash_id, ` + blobColumns + `) SELECT ?, ` + blobColumns + ` FROM blobs WHERE bucket_id = ?;`,
+		`INSERT INTO trashed_blob_versions (trash_id, ` + versionColumns + `) SELECT ?, ` + versionColumns + ` FROM blob_versions WHERE bucket_id = ?;`,
+	} {
+		if _, err := tx.Exec(query, item.Id, item.BucketId); err != nil {
+			return err
+		}
+	}
+	// Blobs, versions and everything else of the bucket cascade.
+	query = `DELETE FROM buckets WHERE id = ?;`
+	if _, err := tx.Exec(query, item.BucketId); err != nil {
+		return err
 	}
 
-	return blob, nil
+	return tx.Commit()
 }
 
-func (me *metadataStorage) migrate() error {
-	// NOTE: I want expirations for accesses to be managed by the client.
+func insertTrashItem(tx *sql.Tx, item *TrashItem, keys []string) error {
 	query := `
-    CREATE TABLE IF NOT EXISTS buckets (
-        id TEXT,
-        created_at TIMESTAMP,
+    INSERT INTO trash (id, kind, bucket_id, blob_id, size, deleted_at, expires_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?);
+    `
+	if _, err := tx.Exec(query, item.Id, item.Kind, item.BucketId, item.BlobId, item.Size, item.DeletedAt, item.ExpiresAt); err != nil {
+		return err
+	}
+	query = `INSERT INTO trashed_objects (trash_id, key) VALUES (?, ?);`
+	for _, key := range keys {
+		if _, err := tx.Exec(query, item.Id, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-        PRIMARY KEY (id)
-    );
-    CREATE TABLE IF NOT EXISTS blobs (
-        id TEXT,
-        bucket_id TEXT,
-        size INTEGER,
-        created_at TIMESTAMP,
+// restoreTrashItem moves the bucket, blobs and older versions of a trashed item
+// back out of the trash.
+func (me *metadataStorage) restoreTrashItem(id string) error {
+	tx, err := me.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-        PRIMARY KEY (id, bucket_id),
-        FOREIGN KEY (bucket_id) REFERENCES buckets(id) ON DELETE CASCADE 
-    );
-    CREATE TABLE IF NOT EXISTS accesses (
-        key TEXT,
-        bucket_id TEXT,
-        blob_id TEXT,
-        created_at TIMESTAMP,
+	for _, query := range []string{
+		`INSERT INTO buckets (` + bucketColumns + `) SELECT ` + bucketColumns + ` FROM trashed_buckets WHERE trash_id = ?;`,
+		`INSERT INTO blobs (` + blobColumns + `) SELECT ` + blobColumns + ` FROM trashed_blobs WHERE trash_id = ?;`,
+		`INSERT INTO blob_versions (` + versionColumns + `) SELECT ` + versionColumns + ` FROM trashed_blob_versions WHERE trash_id = ?;`,
+		`DELETE FROM trash WHERE id = ?;`,
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
 
-        PRIMARY KEY (key),
-        FOREIGN KEY (bucket_id, blob_id) REFERENCES blobs(bucket_id, id) ON DELETE CASCADE
-    );
+	return tx.Commit()
+}
+
+func (me *metadataStorage) checkIfTrashItemExists(id string) (bool, error) {
+	query := `SELECT 1 FROM trash WHERE id = ?;`
+	if err := me.db.QueryRow(query, id).Scan(new(int)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (me *metadataStorage) getTrashItem(id string) (*TrashItem, error) {
+	query := `
+    SELECT
+        kind,
+        bucket_id,
+        blob_id,
+        size,
+        deleted_at,
+        expires_at
+    FROM trash
+    WHERE id = ?;
     `
-	if _, err := me.db.Exec(query); err != nil {
-		return err
+	item := &TrashItem{Id: id}
+	if err := me.db.QueryRow(query, id).Scan(&item.Kind, &item.BucketId, &item.BlobId, &item.Size, &item.DeletedAt, &item.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// getAllTrashItems returns every trashed item, most recently deleted first.
+func (me *metadataStorage) getAllTrashItems() ([]*TrashItem, error) {
+	query := `
+    SELECT
+        id,
+        kind,
+        bucket_id,
+        blob_id,
+        size,
+        deleted_at,
+        expires_at
+    FROM trash
+    ORDER BY deleted_at DESC, id DESC;
+    `
+	rows, err := me.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*TrashItem{}
+
+	for rows.Next() {
+		item := &TrashItem{}
+		if err := rows.Scan(&item.Id, &item.Kind, &item.BucketId, &item.BlobId, &item.Size, &item.DeletedAt, &item.ExpiresAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// getExpiredTrashItemIds returns the ids of trashed items that expired by now.
+func (me *metadataStorage) getExpiredTrashItemIds(now time.Time) ([]string, error) {
+	query := `SELECT id FROM trash WHERE expires_at <= ?;`
+	rows, err := me.db.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// getTrashKeys returns the storage keys the content of a trashed item was
+// moved from.
+func (me *metadataStorage) getTrashKeys(id string) ([]string, error) {
+	query := `SELECT key FROM trashed_objects WHERE trash_id = ?;`
+	rows, err := me.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (me *metadataStorage) deleteTrashItem(id string) error {
+	query := `DELETE FROM trash WHERE id = ?;`
+	if _, err := me.db.Exec(query, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) createAccess(accessKey *Access) error {
+	query := `
+    INSERT INTO accesses (key, bucket_id, blob_id, scope, expires_at, max_downloads, remaining_downloads, max_size, content_type, created_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+    `
+	if _, err := me.db.Exec(query, accessKey.Key, accessKey.BucketId, accessKey.BlobId, accessKey.Scope, accessKey.ExpiresAt,
+		accessKey.MaxDownloads, accessKey.RemainingDownloads, accessKey.MaxSize, accessKey.ContentType, accessKey.CreatedAt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) getAccess(key string) (*Access, error) {
+	query := `
+    SELECT 
+        bucket_id,
+        blob_id,
+        scope,
+        expires_at,
+        max_downloads,
+        remaining_downloads,
+        max_size,
+        content_type,
+        created_at
+    FROM accesses 
+    WHERE key = ?;
+    `
+	access := &Access{Key: key}
+
+	if err := me.db.QueryRow(query, key).Scan(&access.BucketId, &access.BlobId, &access.Scope, &access.ExpiresAt,
+		&access.MaxDownloads, &access.RemainingDownloads, &access.MaxSize, &access.ContentType, &access.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return access, nil
+}
+
+// checkIfAccessUsable reports whether an access key exists, hasn't expired and
+// has downloads left.
+func (me *metadataStorage) checkIfAccessUsable(key string, now time.Time) (bool, error) {
+	query := `
+    SELECT 1 FROM accesses 
+    WHERE key = ?
+        AND (expires_at IS NULL OR expires_at > ?)
+        AND (remaining_downloads IS NULL OR remaining_downloads > 0);
+    `
+	if err := me.db.QueryRow(query, key, now).Scan(new(int)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// checkIfAccessDownloadable reports whether a download access key exists,
+// hasn't expired and has downloads left.
+func (me *metadataStorage) checkIfAccessDownloadable(key string, now time.Time) (bool, error) {
+	query := `
+    SELECT 1 FROM accesses 
+    WHERE key = ?
+        AND scope = ?
+        AND (expires_at IS NULL OR expires_at > ?)
+        AND (remaining_downloads IS NULL OR remaining_downloads > 0);
+    `
+	if err := me.db.QueryRow(query, key, ScopeDownload, now).Scan(new(int)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// consumeAccess atomically uses up one download of an access key. It reports
+// false if the key doesn't exist, isn't a download key, has expired or has no
+// downloads left.
+func (me *metadataStorage) consumeAccess(key string, now time.Time) (bool, error) {
+	query := `
+    UPDATE accesses 
+    SET remaining_downloads = remaining_downloads - 1
+    WHERE key = ?
+        AND scope = ?
+        AND (expires_at IS NULL OR expires_at > ?)
+        AND (remaining_downloads IS NULL OR remaining_downloads > 0);
+    `
+	result, err := me.db.Exec(query, key, ScopeDownload, now)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// deleteDeadAccesses deletes access keys that expired or have no downloads left.
+func (me *metadataStorage) deleteDeadAccesses(now time.Time) (int, error) {
+	query := `DELETE FROM accesses WHERE expires_at <= ? OR remaining_downloads <= 0;`
+	result, err := me.db.Exec(query, now)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+func (me *metadataStorage) checkIfAccessExists(key string) (bool, error) {
+	query := `SELECT 1 FROM accesses WHERE key = ?;`
+	if err := me.db.QueryRow(query, key).Scan(new(int)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (me *metadataStorage) deleteAccess(key string) error {
+	query := `DELETE FROM accesses WHERE key = ?;`
+	if _, err := me.db.Exec(query, key); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) getBlobOfAccess(key string) (*Blob, error) {
+	query := `
+    SELECT 
+        blobs.id,
+        blobs.bucket_id,
+        blobs.size,
+        blobs.content_type,
+        blobs.sha256,
+        blobs.crc32c,
+        blobs.md5,
+        blobs.version,
+        blobs.version_id,
+        blobs.state,
+        blobs.retain_until,
+        blobs.legal_hold,
+        blobs.created_at,
+        blobs.updated_at
+    FROM accesses
+    INNER JOIN blobs ON blobs.bucket_id = accesses.bucket_id AND blobs.id = accesses.blob_id
+    WHERE key = ?;
+    `
+	blob := &Blob{}
+
+	if err := me.db.QueryRow(query, key).Scan(&blob.Id, &blob.BucketId, &blob.Size, &blob.ContentType, &blob.Sha256, &blob.Crc32c, &blob.Md5, &blob.Version, &blob.VersionId, &blob.State,
+		&blob.RetainUntil, &blob.LegalHold, &blob.CreatedAt, &blob.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// createUpload records an upload, along with the version id of the empty blob
+// it was created with, if any.
+func (me *metadataStorage) createUpload(upload *Upload, placeholder string) error {
+	query := `
+    INSERT INTO uploads (id, bucket_id, blob_id, placeholder_version_id, created_at)
+    VALUES (?, ?, ?, ?, ?);
+    `
+	if _, err := me.db.Exec(query, upload.Id, upload.BucketId, upload.BlobId, placeholder, upload.CreatedAt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) checkIfUploadExists(bucketId, blobId, uploadId string) (bool, error) {
+	query := `SELECT 1 FROM uploads WHERE id = ? AND bucket_id = ? AND blob_id = ?;`
+	if err := me.db.QueryRow(query, uploadId, bucketId, blobId).Scan(new(int)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (me *metadataStorage) getUpload(id string) (*Upload, error) {
+	query := `
+    SELECT
+        bucket_id,
+        blob_id,
+        created_at
+    FROM uploads
+    WHERE id = ?;
+    `
+	upload := &Upload{Id: id}
+	if err := me.db.QueryRow(query, id).Scan(&upload.BucketId, &upload.BlobId, &upload.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	parts, err := me.getPartsPerUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	upload.Parts = parts
+
+	return upload, nil
+}
+
+func (me *metadataStorage) getUploadsPerBlob(bucketId, blobId string) ([]*Upload, error) {
+	query := `
+    SELECT
+        id,
+        bucket_id,
+        blob_id,
+        created_at
+    FROM uploads
+    WHERE bucket_id = ? AND blob_id = ?;
+    `
+	return me.queryUploads(query, bucketId, blobId)
+}
+
+func (me *metadataStorage) getUploadsPerBucket(bucketId string) ([]*Upload, error) {
+	query := `
+    SELECT
+        id,
+        bucket_id,
+        blob_id,
+        created_at
+    FROM uploads
+    WHERE bucket_id = ?;
+    `
+	return me.queryUploads(query, bucketId)
+}
+
+func (me *metadataStorage) queryUploads(query string, args ...any) ([]*Upload, error) {
+	rows, err := me.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []*Upload{}
+
+	for rows.Next() {
+		upload := &Upload{}
+		if err := rows.Scan(&upload.Id, &upload.BucketId, &upload.BlobId, &upload.CreatedAt); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, upload := range uploads {
+		parts, err := me.getPartsPerUpload(upload.Id)
+		if err != nil {
+			return nil, err
+		}
+		upload.Parts = parts
+	}
+
+	return uploads, nil
+}
+
+// getUploadPlaceholder returns the version id of the empty blob an upload was
+// created with, or "" if it had none.
+func (me *metadataStorage) getUploadPlaceholder(id string) (string, error) {
+	query := `SELECT placeholder_version_id FROM uploads WHERE id = ?;`
+	var placeholder string
+	if err := me.db.QueryRow(query, id).Scan(&placeholder); err != nil {
+		return "", err
+	}
+	return placeholder, nil
+}
+
+func (me *metadataStorage) deleteUpload(id string) error {
+	query := `DELETE FROM uploads WHERE id = ?;`
+	if _, err := me.db.Exec(query, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) getPartsPerUpload(uploadId string) ([]*Part, error) {
+	query := `
+    SELECT
+        number,
+        size,
+        created_at
+    FROM upload_parts
+    WHERE upload_id = ?
+    ORDER BY number;
+    `
+	rows, err := me.db.Query(query, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := []*Part{}
+
+	for rows.Next() {
+		part := &Part{}
+		if err := rows.Scan(&part.Number, &part.Size, &part.CreatedAt); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+// getPartKeys returns the storage keys of the parts of an upload, by number.
+func (me *metadataStorage) getPartKeys(uploadId string) (map[int]string, error) {
+	query := `SELECT number, key FROM upload_parts WHERE upload_id = ?;`
+	rows, err := me.db.Query(query, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := map[int]string{}
+
+	for rows.Next() {
+		var (
+			number int
+			key    string
+		)
+		if err := rows.Scan(&number, &key); err != nil {
+			return nil, err
+		}
+		keys[number] = key
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// putPart records a part of an upload stored at key, replacing any earlier
+// upload of the same part number. It returns the storage key of the replaced
+// part, if any.
+func (me *metadataStorage) putPart(uploadId string, part *Part, key string) (string, error) {
+	tx, err := me.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var oldKey string
+	query := `SELECT key FROM upload_parts WHERE upload_id = ? AND number = ?;`
+	if err := tx.QueryRow(query, uploadId, part.Number).Scan(&oldKey); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	query = `
+    INSERT INTO upload_parts (upload_id, number, size, key, created_at)
+    VALUES (?, ?, ?, ?, ?)
+    ON CONFLICT (upload_id, number) DO UPDATE SET
+        size = excluded.size,
+        key = excluded.key,
+        created_at = excluded.created_at;
+    `
+	if _, err := tx.Exec(query, uploadId, part.Number, part.Size, key, part.CreatedAt); err != nil {
+		return "", err
+	}
+
+	return oldKey, tx.Commit()
+}
+
+func (me *metadataStorage) createAPIKey(apiKey *APIKey, secretHash string) error {
+	scopes, err := json.Marshal(apiKey.Scopes)
+	if err != nil {
+		return err
+	}
+	buckets, err := json.Marshal(apiKey.Buckets)
+	if err != nil {
+		return err
+	}
+	query := `
+    INSERT INTO api_keys (id, name, secret_hash, scopes, buckets, disabled, created_at, rotated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+    `
+	if _, err := me.db.Exec(query, apiKey.Id, apiKey.Name, secretHash, scopes, buckets, apiKey.Disabled, apiKey.CreatedAt, apiKey.RotatedAt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) checkIfAPIKeyExists(id string) (bool, error) {
+	query := `SELECT 1 FROM api_keys WHERE id = ?;`
+	if err := me.db.QueryRow(query, id).Scan(new(int)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// getAPIKey returns an API key along with the hash of its secret.
+func (me *metadataStorage) getAPIKey(id string) (*APIKey, string, error) {
+	query := `
+    SELECT 
+        id,
+        name,
+        secret_hash,
+        scopes,
+        buckets,
+        disabled,
+        created_at,
+        rotated_at
+    FROM api_keys 
+    WHERE id = ?;
+    `
+	var secretHash string
+	apiKey, err := scanAPIKey(me.db.QueryRow(query, id), &secretHash)
+	if err != nil {
+		return nil, "", err
+	}
+	return apiKey, secretHash, nil
+}
+
+func (me *metadataStorage) getAllAPIKeys() ([]*APIKey, error) {
+	query := `
+    SELECT 
+        id,
+        name,
+        secret_hash,
+        scopes,
+        buckets,
+        disabled,
+        created_at,
+        rotated_at
+    FROM api_keys;
+    `
+	rows, err := me.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []*APIKey{}
+
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows, new(string))
+		if err != nil {
+			return nil, err
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }, secretHash *string) (*APIKey, error) {
+	apiKey := &APIKey{}
+	var scopes, buckets []byte
+	if err := row.Scan(&apiKey.Id, &apiKey.Name, secretHash, &scopes, &buckets, &apiKey.Disabled, &apiKey.CreatedAt, &apiKey.RotatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(scopes, &apiKey.Scopes); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buckets, &apiKey.Buckets); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+func (me *metadataStorage) setAPIKeyDisabled(id string, disabled bool) error {
+	query := `UPDATE api_keys SET disabled = ? WHERE id = ?;`
+	if _, err := me.db.Exec(query, disabled, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) rotateAPIKey(id, secretHash string, rotatedAt time.Time) error {
+	query := `UPDATE api_keys SET secret_hash = ?, rotated_at = ? WHERE id = ?;`
+	if _, err := me.db.Exec(query, secretHash, rotatedAt, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (me *metadataStorage) migrate() error {
+	// NOTE: accesses might expire or run out of downloads; a sweeper deletes them, see sweepAccesses().
+	// NOTE: blob_versions outlive the blobs they were archived from, so they only reference buckets.
+	// NOTE: the trashed_ tables shadow the tables of the same names, without their keys, until the
+	// trashed item is restored or purged, see purgeExpiredTrash().
+	query := `
+    CREATE TABLE IF NOT EXISTS buckets (
+        id TEXT,
+        policy TEXT,
+        versioning BOOLEAN,
+        lifecycle TEXT,
+        default_retention_days INTEGER,
+        allow_locked_appends BOOLEAN,
+        created_at TIMESTAMP,
+
+        PRIMARY KEY (id)
+    );
+    CREATE TABLE IF NOT EXISTS blobs (
+        id TEXT,
+        bucket_id TEXT,
+        size INTEGER,
+        content_type TEXT,
+        sha256 TEXT,
+        crc32c TEXT,
+        md5 TEXT,
+        hash_state BLOB,
+        version INTEGER,
+        version_id TEXT,
+        state TEXT,
+        retain_until TIMESTAMP,
+        legal_hold BOOLEAN,
+        created_at TIMESTAMP,
+        updated_at TIMESTAMP,
+
+        PRIMARY KEY (id, bucket_id),
+        FOREIGN KEY (bucket_id) REFERENCES buckets(id) ON DELETE CASCADE 
+    );
+    CREATE TABLE IF NOT EXISTS blob_versions (
+        version_id TEXT,
+        bucket_id TEXT,
+        blob_id TEXT,
+        size INTEGER,
+        content_type TEXT,
+        sha256 TEXT,
+        crc32c TEXT,
+        md5 TEXT,
+        version INTEGER,
+        state TEXT,
+        delete_marker BOOLEAN,
+        created_at TIMESTAMP,
+        updated_at TIMESTAMP,
+        archived_at TIMESTAMP,
+
+        PRIMARY KEY (version_id),
+        FOREIGN KEY (bucket_id) REFERENCES buckets(id) ON DELETE CASCADE
+    );
+    CREATE TABLE IF NOT EXISTS accesses (
+        key TEXT,
+        bucket_id TEXT,
+        blob_id TEXT,
+        scope TEXT,
+        expires_at TIMESTAMP,
+        max_downloads INTEGER,
+        remaining_downloads INTEGER,
+        max_size INTEGER,
+        content_type TEXT,
+        created_at TIMESTAMP,
+
+        PRIMARY KEY (key),
+        FOREIGN KEY (bucket_id, blob_id) REFERENCES blobs(bucket_id, id) ON DELETE CASCADE
+    );
+    CREATE TABLE IF NOT EXISTS api_keys (
+        id TEXT,
+        name TEXT,
+        secret_hash TEXT,
+        scopes TEXT,
+        buckets TEXT,
+        disabled BOOLEAN,
+        created_at TIMESTAMP,
+        rotated_at TIMESTAMP,
+
+        PRIMARY KEY (id)
+    );
+    CREATE TABLE IF NOT EXISTS uploads (
+        id TEXT,
+        bucket_id TEXT,
+        blob_id TEXT,
+        placeholder_version_id TEXT,
+        created_at TIMESTAMP,
+
+        PRIMARY KEY (id),
+        FOREIGN KEY (bucket_id, blob_id) REFERENCES blobs(bucket_id, id) ON DELETE CASCADE
+    );
+    CREATE TABLE IF NOT EXISTS upload_parts (
+        upload_id TEXT,
+        number INTEGER,
+        size INTEGER,
+        key TEXT,
+        created_at TIMESTAMP,
+
+        PRIMARY KEY (upload_id, number),
+        FOREIGN KEY (upload_id) REFERENCES uploads(id) ON DELETE CASCADE
+    );
+    CREATE TABLE IF NOT EXISTS trash (
+        id TEXT,
+        kind TEXT,
+        bucket_id TEXT,
+        blob_id TEXT,
+        size INTEGER,
+        deleted_at TIMESTAMP,
+        expires_at TIMESTAMP,
+
+        PRIMARY KEY (id)
+    );
+    CREATE TABLE IF NOT EXISTS trashed_objects (
+        trash_id TEXT,
+        key TEXT,
+
+        PRIMARY KEY (trash_id, key),
+        FOREIGN KEY (trash_id) REFERENCES trash(id) ON DELETE CASCADE
+    );
+    CREATE TABLE IF NOT EXISTS trashed_buckets (
+        trash_id TEXT,
+        id TEXT,
+        policy TEXT,
+        versioning BOOLEAN,
+        lifecycle TEXT,
+        default_retention_days INTEGER,
+        allow_locked_appends BOOLEAN,
+        created_at TIMESTAMP,
+
+        FOREIGN KEY (trash_id) REFERENCES trash(id) ON DELETE CASCADE
+    );
+    CREATE TABLE IF NOT EXISTS trashed_blobs (
+        trash_id TEXT,
+        id TEXT,
+        bucket_id TEXT,
+        size INTEGER,
+        content_type TEXT,
+        sha256 TEXT,
+        crc32c TEXT,
+        md5 TEXT,
+        hash_state BLOB,
+        version INTEGER,
+        version_id TEXT,
+        state TEXT,
+        retain_until TIMESTAMP,
+        legal_hold BOOLEAN,
+        created_at TIMESTAMP,
+        updated_at TIMESTAMP,
+
+        FOREIGN KEY (trash_id) REFERENCES trash(id) ON DELETE CASCADE
+    );
+    CREATE TABLE IF NOT EXISTS trashed_blob_versions (
+        trash_id TEXT,
+        version_id TEXT,
+        bucket_id TEXT,
+        blob_id TEXT,
+        size INTEGER,
+        content_type TEXT,
+        sha256 TEXT,
+        crc32c TEXT,
+        md5 TEXT,
+        version INTEGER,
+        state TEXT,
+        delete_marker BOOLEAN,
+        created_at TIMESTAMP,
+        updated_at TIMESTAMP,
+        archived_at TIMESTAMP,
+
+        FOREIGN KEY (trash_id) REFERENCES trash(id) ON DELETE CASCADE
+    );
+    `
+	tx, err := me.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
+		return err
+	}
+	for _, migration := range migrations[min(version, len(migrations)):] {
+		for _, column := range migration {
+			if err := addColumn(tx, column); err != nil {
+				return err
+			}
+		}
+	}
+	// Pragmas can't take parameters.
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d;`, len(migrations))); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addedColumn is a column that a migration adds to a table.
+type addedColumn struct {
+	table      string
+	definition string // Like "size INTEGER DEFAULT 0", whose default is the value of existing rows.
+	backfill   string // Optional statement that sets the column of existing rows instead.
+}
+
+// migrations bring the tables of databases created by older versions up to
+// date, in order, and the user_version pragma of a database counts how many
+// it had. Tables that migrate creates already have every column, and so do
+// tables of databases from before user_version was kept, so columns that exist
+// are skipped.
+var migrations = [][]addedColumn{
+	// Checksums of blobs. Existing blobs are hashed on startup, see backfillChecksums.
+	{
+		{table: "blobs", definition: "sha256 TEXT DEFAULT ''"},
+		{table: "blobs", definition: "crc32c TEXT DEFAULT ''"},
+		{table: "blobs", definition: "md5 TEXT DEFAULT ''"},
+		{table: "blobs", definition: "hash_state BLOB"},
+	},
+	// Versions and modification times of blobs.
+	{
+		{table: "blobs", definition: "version INTEGER DEFAULT 1"},
+		{table: "blobs", definition: "updated_at TIMESTAMP", backfill: `UPDATE blobs SET updated_at = created_at;`},
+	},
+	// Content types of blobs.
+	{
+		{table: "blobs", definition: "content_type TEXT DEFAULT 'application/octet-stream'"},
+	},
+	// Expiring and usage-limited access keys. Existing keys never expire.
+	{
+		{table: "accesses", definition: "expires_at TIMESTAMP"},
+		{table: "accesses", definition: "max_downloads INTEGER"},
+		{table: "accesses", definition: "remaining_downloads INTEGER"},
+	},
+	// Upload-scoped access keys. Existing keys are download keys.
+	{
+		{table: "accesses", definition: "scope TEXT DEFAULT 'download'"},
+		{table: "accesses", definition: "max_size INTEGER"},
+		{table: "accesses", definition: "content_type TEXT"},
+	},
+	// Bucket policies. Existing buckets are private.
+	{
+		{table: "buckets", definition: "policy TEXT DEFAULT 'private'"},
+	},
+	// Blob versioning, off in existing buckets. Existing blobs get random version ids.
+	{
+		{table: "buckets", definition: "versioning BOOLEAN DEFAULT 0"},
+		{table: "blobs", definition: "version_id TEXT", backfill: `UPDATE blobs SET version_id = upper(hex(randomblob(16)));`},
+	},
+	// Lifecycle rules of buckets.
+	{
+		{table: "buckets", definition: "lifecycle TEXT"},
+		{table: "trashed_buckets", definition: "lifecycle TEXT"},
+	},
+	// Object lock. Existing blobs aren't locked.
+	{
+		{table: "buckets", definition: "default_retention_days INTEGER DEFAULT 0"},
+		{table: "buckets", definition: "allow_locked_appends BOOLEAN DEFAULT 0"},
+		{table: "blobs", definition: "retain_until TIMESTAMP"},
+		{table: "blobs", definition: "legal_hold BOOLEAN DEFAULT 0"},
+		{table: "trashed_buckets", definition: "default_retention_days INTEGER DEFAULT 0"},
+		{table: "trashed_buckets", definition: "allow_locked_appends BOOLEAN DEFAULT 0"},
+		{table: "trashed_blobs", definition: "retain_until TIMESTAMP"},
+		{table: "trashed_blobs", definition: "legal_hold BOOLEAN DEFAULT 0"},
+	},
+	// Blob states. Existing blobs stay open, so they can still be appended to.
+	{
+		{table: "blobs", definition: "state TEXT DEFAULT 'open'"},
+		{table: "blob_versions", definition: "state TEXT DEFAULT 'open'"},
+		{table: "trashed_blobs", definition: "state TEXT DEFAULT 'open'"},
+		{table: "trashed_blob_versions", definition: "state TEXT DEFAULT 'open'"},
+	},
+	// Empty blobs created for S3 multipart uploads. Existing uploads have none.
+	{
+		{table: "uploads", definition: "placeholder_version_id TEXT DEFAULT ''"},
+	},
+}
+
+// addColumn adds a column to a table unless it has it already.
+func addColumn(tx *sql.Tx, column addedColumn) error {
+	name := strings.Fields(column.definition)[0]
+	query := `SELECT 1 FROM pragma_table_info(?) WHERE name = ?;`
+	if err := tx.QueryRow(query, column.table, name).Scan(new(int)); err == nil {
+		return nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if _, err := tx.Exec(`ALTER TABLE ` + column.table + ` ADD COLUMN ` + column.definition + `;`); err != nil {
+		return err
+	}
+	if column.backfill != "" {
+		if _, err := tx.Exec(column.backfill); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,417 @@
+package blob
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/oklog/ulid/v2"
+)
+
+// Multipart uploads let clients upload numbered parts of a blob in any order
+// and in parallel. Completing an upload assembles the listed parts, in order,
+// into the new content of the blob, which replaces the old one at once.
+
+const maxPartNumber = 10000
+
+type completeUploadRequest struct {
+	Parts []int `json:"parts"`
+}
+
+func (me *Server) handleCreateUpload(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, blobId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("blob not found")
+	}
+
+	upload := &Upload{
+		Id:        ulid.Make().String(),
+		BucketId:  bucketId,
+		BlobId:    blobId,
+		CreatedAt: time.Now().UTC(),
+		Parts:     []*Part{},
+	}
+
+	if err := me.metadata.createUpload(upload, ""); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(upload)
+}
+
+func (me *Server) handleGetUploadsPerBucket(c *fiber.Ctx) error {
+	bucketId := pathParam(c, "bucket_id")
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("bucket not found")
+	}
+
+	uploads, err := me.metadata.getUploadsPerBucket(bucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(uploads)
+}
+
+func (me *Server) handleGetUploadsPerBlob(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, blobId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("blob not found")
+	}
+
+	uploads, err := me.metadata.getUploadsPerBlob(bucketId, blobId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(uploads)
+}
+
+func (me *Server) handleGetUpload(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+		uploadId = pathParam(c, "upload_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+	if uploadId == "" {
+		return utils.BadRequestError("invalid value for path param upload_id")
+	}
+
+	if exists, err := me.metadata.checkIfUploadExists(bucketId, blobId, uploadId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("upload not found")
+	}
+
+	upload, err := me.metadata.getUpload(uploadId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(upload)
+}
+
+func (me *Server) handleUploadPart(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+		uploadId = pathParam(c, "upload_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+	if uploadId == "" {
+		return utils.BadRequestError("invalid value for path param upload_id")
+	}
+	number, err := strconv.Atoi(c.Params("part_number"))
+	if err != nil || number < 1 || number > maxPartNumber {
+		return utils.BadRequestError(fmt.Sprintf("part_number must be between 1 and %d", maxPartNumber))
+	}
+
+	if exists, err := me.metadata.checkIfUploadExists(bucketId, blobId, uploadId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("upload not found")
+	}
+
+	if err := verifyChunk(c, c.Body()); err != nil {
+		return err
+	}
+
+	part, err := me.storeUploadPart(bucketId, blobId, uploadId, number, c.Body())
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(part)
+}
+
+// storeUploadPart stores data as part number of an upload, replacing any
+// previous upload of that part.
+func (me *Server) storeUploadPart(bucketId, blobId, uploadId string, number int, data []byte) (*Part, error) {
+	// Parts are written without holding the upload lock, so they can be uploaded in parallel.
+	part := &Part{
+		Number:    number,
+		Size:      len(data),
+		CreatedAt: time.Now().UTC(),
+	}
+	key := uploadPartKey(uploadId, number)
+	file, err := me.storage.OpenAppend(key)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return nil, utils.InternalServerError(err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+
+	unlock := me.blobLocks.lock(uploadPrefix(uploadId))
+	defer unlock()
+
+	// The upload might have been completed or aborted meanwhile.
+	if exists, err := me.metadata.checkIfUploadExists(bucketId, blobId, uploadId); err != nil {
+		return nil, utils.InternalServerError(err)
+	} else if !exists {
+		if err := me.storage.Delete(key); err != nil {
+			return nil, storageError(err)
+		}
+		return nil, utils.NotFoundError("upload not found")
+	}
+
+	oldKey, err := me.metadata.putPart(uploadId, part, key)
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+	if oldKey != "" {
+		if err := me.storage.Delete(oldKey); err != nil {
+			return nil, storageError(err)
+		}
+	}
+
+	return part, nil
+}
+
+func (me *Server) handleCompleteUpload(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+		uploadId = pathParam(c, "upload_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+	if uploadId == "" {
+		return utils.BadRequestError("invalid value for path param upload_id")
+	}
+
+	var req completeUploadRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.InvalidJsonRequestError()
+	}
+	if len(req.Parts) == 0 {
+		return utils.BadRequestError("parts must not be empty")
+	}
+	for i := 1; i < len(req.Parts); i++ {
+		if req.Parts[i] <= req.Parts[i-1] {
+			return utils.BadRequestError("parts must be in ascending order")
+		}
+	}
+
+	unlock := me.blobLocks.lock(uploadPrefix(uploadId))
+	defer unlock()
+
+	if exists, err := me.metadata.checkIfUploadExists(bucketId, blobId, uploadId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("upload not found")
+	}
+
+	upload, err := me.metadata.getUpload(uploadId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	parts, closeParts, err := me.openUploadParts(upload, req.Parts)
+	if err != nil {
+		return err
+	}
+	defer closeParts()
+
+	blob, err := me.replaceBlob(bucketId, blobId, "", BlobOpen, parts, checkOpen(), checkWritePreconditions(c))
+	if err != nil {
+		return err
+	}
+
+	if err := me.discardUploads(upload); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(blob)
+}
+
+func (me *Server) handleAbortUpload(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+		uploadId = pathParam(c, "upload_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+	if uploadId == "" {
+		return utils.BadRequestError("invalid value for path param upload_id")
+	}
+
+	if err := me.abortUpload(bucketId, blobId, uploadId); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// abortUpload discards an upload of a blob and its parts, along with the blob
+// if the upload created it and it's still empty.
+func (me *Server) abortUpload(bucketId, blobId, uploadId string) error {
+	unlock := me.blobLocks.lock(uploadPrefix(uploadId))
+	defer unlock()
+
+	if exists, err := me.metadata.checkIfUploadExists(bucketId, blobId, uploadId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("upload not found")
+	}
+
+	upload, err := me.metadata.getUpload(uploadId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	placeholder, err := me.metadata.getUploadPlaceholder(uploadId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	if err := me.discardUploads(upload); err != nil {
+		return err
+	}
+	return me.removePlaceholder(upload, placeholder)
+}
+
+// removePlaceholder deletes placeholder, the empty blob an upload was created
+// with, unless it was written to, replaced or has other uploads meanwhile.
+func (me *Server) removePlaceholder(upload *Upload, placeholder string) error {
+	if placeholder == "" {
+		return nil
+	}
+
+	unlock := me.blobLocks.lock(blobKey(upload.BucketId, upload.BlobId))
+	defer unlock()
+
+	blob, err := me.metadata.getBlob(upload.BucketId, upload.BlobId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return utils.InternalServerError(err)
+	}
+	if blob.VersionId != placeholder || blob.Size != 0 || blob.Version != 1 {
+		return nil
+	}
+	if uploads, err := me.metadata.getUploadsPerBlob(upload.BucketId, upload.BlobId); err != nil {
+		return utils.InternalServerError(err)
+	} else if len(uploads) > 0 {
+		return nil
+	}
+
+	if err := me.storage.Delete(blobKey(upload.BucketId, upload.BlobId)); err != nil {
+		return storageError(err)
+	}
+	if err := me.metadata.deleteBlob(upload.BucketId, upload.BlobId); err != nil {
+		return utils.InternalServerError(err)
+	}
+	return nil
+}
+
+// openUploadParts returns a reader of the given parts of an upload, in order,
+// and the function that closes them.
+func (me *Server) openUploadParts(upload *Upload, numbers []int) (io.Reader, func(), error) {
+	uploaded := map[int]*Part{}
+	for _, part := range upload.Parts {
+		uploaded[part.Number] = part
+	}
+	keys, err := me.metadata.getPartKeys(upload.Id)
+	if err != nil {
+		return nil, nil, utils.InternalServerError(err)
+	}
+
+	var (
+		files   []ReadAtCloser
+		readers = make([]io.Reader, 0, len(numbers))
+	)
+	closeFiles := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	for _, number := range numbers {
+		part, ok := uploaded[number]
+		if !ok {
+			closeFiles()
+			return nil, nil, utils.BadRequestError(fmt.Sprintf("part %d was not uploaded", number))
+		}
+		file, err := me.storage.OpenReader(keys[number])
+		if err != nil {
+			closeFiles()
+			return nil, nil, storageError(err)
+		}
+		files = append(files, file)
+		readers = append(readers, io.NewSectionReader(file, 0, int64(part.Size)))
+	}
+
+	return io.MultiReader(readers...), closeFiles, nil
+}
+
+// discardUploads removes uploads and their parts.
+func (me *Server) discardUploads(uploads ...*Upload) error {
+	for _, upload := range uploads {
+		if err := me.metadata.deleteUpload(upload.Id); err != nil {
+			return utils.InternalServerError(err)
+		}
+		if err := me.storage.DeletePrefix(uploadPrefix(upload.Id)); err != nil {
+			return storageError(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package blob
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Locked blobs can't be replaced or deleted, nor can the buckets holding them,
+// until their retention is over and their legal hold is released. Retention
+// can only be extended. Blobs get the default retention of their bucket once
+// they hold content: when it's first written, written as a whole or sealed.
+// Empty blobs, like the placeholders of multipart uploads, aren't retained.
+// Open blobs retained by default can still be appended to, so their uploads
+// can finish, but not truncated, replaced or deleted. Archived versions are
+// never locked, since locked content is never archived.
+
+// lockedAt reports whether a blob can't be replaced or deleted at at.
+func lockedAt(blob *Blob, at time.Time) bool {
+	return blob.LegalHold || (blob.RetainUntil != nil && at.Before(*blob.RetainUntil))
+}
+
+// checkUnlocked returns a blobCheck that fails if a blob is locked at at.
+func checkUnlocked(at time.Time) blobCheck {
+	return func(blob *Blob) error {
+		if blob.LegalHold {
+			return utils.ForbiddenError("blob is under a legal hold")
+		}
+		if lockedAt(blob, at) {
+			return utils.ForbiddenError("blob is retained until " + blob.RetainUntil.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
+
+// checkAppendable returns a blobCheck that fails if a blob is locked, unless
+// its bucket allows appending to locked blobs, or the blob is open and only
+// retained in a bucket retaining blobs by default.
+func (me *Server) checkAppendable() blobCheck {
+	return func(blob *Blob) error {
+		lockErr := checkUnlocked(time.Now().UTC())(blob)
+		if lockErr == nil {
+			return nil
+		}
+		objectLock, err := me.metadata.getBucketObjectLock(blob.BucketId)
+		if err != nil {
+			return utils.InternalServerError(err)
+		}
+		if objectLock.AllowAppends {
+			return nil
+		}
+		if blob.State == BlobOpen && !blob.LegalHold && objectLock.DefaultRetentionDays > 0 {
+			return nil
+		}
+		return lockErr
+	}
+}
+
+// retainNewContent extends the retention of a blob whose content is first
+// written, written as a whole or sealed at now to the default retention of its
+// bucket.
+func (me *Server) retainNewContent(blob *Blob, now time.Time) error {
+	objectLock, err := me.metadata.getBucketObjectLock(blob.BucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	if objectLock.DefaultRetentionDays > 0 {
+		retainUntil := now.AddDate(0, 0, objectLock.DefaultRetentionDays)
+		if blob.RetainUntil == nil || retainUntil.After(*blob.RetainUntil) {
+			blob.RetainUntil = &retainUntil
+		}
+	}
+	return nil
+}
+
+func (me *Server) handleSetBucketObjectLock(c *fiber.Ctx) error {
+	bucketId := pathParam(c, "bucket_id")
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+
+	var req ObjectLock
+	if err := c.BodyParser(&req); err != nil {
+		return utils.InvalidJsonRequestError()
+	}
+	if req.DefaultRetentionDays < 0 {
+		return utils.BadRequestError("defaultRetentionDays must not be negative")
+	}
+
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("bucket not found")
+	}
+
+	// The default retention applies to content created from now on; blobs
+	// that are already retained keep their retention.
+	if err := me.metadata.setBucketObjectLock(bucketId, &req); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+type setRetentionRequest struct {
+	RetainUntil *time.Time `json:"retainUntil"`
+}
+
+// handleSetBlobRetention sets until when a blob is retained. An active
+// retention can only be extended.
+func (me *Server) handleSetBlobRetention(c *fiber.Ctx) error {
+	var req setRetentionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.InvalidJsonRequestError()
+	}
+
+	return me.updateBlobLock(c, func(blob *Blob, now time.Time) error {
+		if blob.RetainUntil != nil && now.Before(*blob.RetainUntil) && (req.RetainUntil == nil || req.RetainUntil.Before(*blob.RetainUntil)) {
+			return utils.ForbiddenError("retention can't be shortened before it's over")
+		}
+		if req.RetainUntil != nil {
+			retainUntil := req.RetainUntil.UTC()
+			req.RetainUntil = &retainUntil
+		}
+		blob.RetainUntil = req.RetainUntil
+		return nil
+	})
+}
+
+// handleSetBlobLegalHold places or releases the legal hold of a blob.
+func (me *Server) handleSetBlobLegalHold(c *fiber.Ctx) error {
+	legalHold, err := strconv.ParseBool(strings.TrimSpace(c.Query("enabled")))
+	if err != nil {
+		return utils.BadRequestError("invalid value for query param enabled")
+	}
+
+	return me.updateBlobLock(c, func(blob *Blob, now time.Time) error {
+		blob.LegalHold = legalHold
+		return nil
+	})
+}
+
+// updateBlobLock changes the retention or legal hold of the blob of a route
+// with update, and responds with the blob.
+func (me *Server) updateBlobLock(c *fiber.Ctx, update func(blob *Blob, now time.Time) error) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+
+	unlock := me.blobLocks.lock(blobKey(bucketId, blobId))
+	defer unlock()
+
+	blob, err := me.metadata.getBlob(bucketId, blobId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.NotFoundError("blob not found")
+		}
+		return utils.InternalServerError(err)
+	}
+
+	if err := update(blob, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := me.metadata.setBlobLock(blob); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(blob)
+}
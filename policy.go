@@ -0,0 +1,107 @@
+package blob
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Public buckets are served anonymously under /public, according to their
+// policy. Private buckets are indistinguishable from missing ones there.
+
+func validPolicy(policy string) bool {
+	return policy == PolicyPrivate || policy == PolicyPublicRead || policy == PolicyPublicList
+}
+
+// policyAllows reports whether a bucket with policy grants what required does.
+func policyAllows(policy, required string) bool {
+	switch required {
+	case PolicyPublicRead:
+		return policy == PolicyPublicRead || policy == PolicyPublicList
+	case PolicyPublicList:
+		return policy == PolicyPublicList
+	}
+	return true
+}
+
+// mwWithPolicy is middleware that lets anonymous requests through if the policy
+// of the bucket of the route grants required.
+func (me *Server) mwWithPolicy(required string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		bucketId := pathParam(c, "bucket_id")
+		if bucketId == "" {
+			return utils.BadRequestError("invalid value for path param bucket_id")
+		}
+
+		policy, err := me.metadata.getBucketPolicy(bucketId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.NotFoundError("bucket not found")
+			}
+			return utils.InternalServerError(err)
+		}
+		if !policyAllows(policy, required) {
+			return utils.NotFoundError("bucket not found")
+		}
+
+		return c.Next()
+	}
+}
+
+func (me *Server) handleSetBucketPolicy(c *fiber.Ctx) error {
+	bucketId := pathParam(c, "bucket_id")
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	policy := strings.TrimSpace(c.Query("policy"))
+	if !validPolicy(policy) {
+		return utils.BadRequestError("invalid value for query param policy")
+	}
+
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("bucket not found")
+	}
+
+	if err := me.metadata.setBucketPolicy(bucketId, policy); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleDownloadPublicBlob serves the content of a blob in a public bucket,
+// for GET and HEAD requests.
+func (me *Server) handleDownloadPublicBlob(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+	)
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, blobId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("blob not found")
+	}
+
+	blob, err := me.metadata.getBlob(bucketId, blobId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	// Shared caches may store public blobs, but must revalidate them since
+	// blobs can be appended to.
+	c.Set(fiber.HeaderCacheControl, "public, no-cache")
+
+	if c.Method() == fiber.MethodHead {
+		return me.serveBlobHead(c, blob)
+	}
+	return me.serveBlob(c, blob)
+}
@@ -0,0 +1,730 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/oklog/ulid/v2"
+)
+
+// The S3 compatible API maps the path-style requests of S3 clients onto
+// buckets and blobs, with object keys as blob ids. Objects are replaced as a
+// whole, unlike blobs which are appended to. Object ETags are the blob ETags.
+
+const (
+	DefaultS3Prefix = "/s3"
+	DefaultS3Region = "us-east-1"
+	s3Namespace     = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat    = "2006-01-02T15:04:05.000Z"
+	s3MaxKeys       = 1000
+)
+
+// S3Config enables the S3 compatible API.
+type S3Config struct {
+	Prefix      string            // Path the API is mounted on. Defaults to DefaultS3Prefix.
+	Region      string            // Region requests are signed for. Defaults to DefaultS3Region.
+	Credentials map[string]string // Secret access keys by access key id.
+}
+
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Status   int      `xml:"-"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource,omitempty"`
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newS3Error(status int, code, message string) *s3Error {
+	return &s3Error{Status: status, Code: code, Message: message}
+}
+
+var (
+	errS3NoSuchBucket = newS3Error(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	errS3NoSuchKey    = newS3Error(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	errS3NoSuchUpload = newS3Error(http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+)
+
+type s3Owner struct {
+	ID          string
+	DisplayName string
+}
+
+type s3ListAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type s3ListBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Xmlns                 string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	EncodingType          string `xml:",omitempty"`
+	MaxKeys               int
+	KeyCount              int `xml:",omitempty"`
+	IsTruncated           bool
+	Marker                string           `xml:",omitempty"`
+	NextMarker            string           `xml:",omitempty"`
+	ContinuationToken     string           `xml:",omitempty"`
+	NextContinuationToken string           `xml:",omitempty"`
+	StartAfter            string           `xml:",omitempty"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+type s3LocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+type s3InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type s3CompleteMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type s3CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// registerS3Routes mounts the S3 compatible API. It must be registered before
+// the closed group, whose middleware would shadow it.
+func (me *Server) registerS3Routes() {
+	s3 := me.router.Group(me.s3.Prefix, me.mwWithS3Errors, me.mwWithSigV4)
+
+	s3.Get("/", me.handleS3ListBuckets)
+
+	s3.Head("/:bucket", me.handleS3HeadBucket)
+	s3.Put("/:bucket", me.handleS3CreateBucket)
+	s3.Delete("/:bucket", me.handleS3DeleteBucket)
+	s3.Get("/:bucket", me.handleS3GetBucket)
+
+	s3.Head("/:bucket/*", me.handleS3GetObject)
+	s3.Get("/:bucket/*", me.handleS3GetObject)
+	s3.Put("/:bucket/*", me.handleS3PutObject)
+	s3.Delete("/:bucket/*", me.handleS3DeleteObject)
+	s3.Post("/:bucket/*", me.handleS3PostObject)
+}
+
+// mwWithS3Errors is middleware that responds to the errors of S3 routes in the
+// XML format S3 clients expect.
+func (me *Server) mwWithS3Errors(c *fiber.Ctx) error {
+	err := c.Next()
+	if err == nil {
+		return nil
+	}
+
+	var s3E *s3Error
+	if !errors.As(err, &s3E) {
+		s3E = toS3Error(err)
+	}
+	// Errors may be shared, like errS3NoSuchKey, so they're copied.
+	response := *s3E
+	response.Resource = string(c.Request().URI().PathOriginal())
+	return sendS3XML(c, response.Status, &response)
+}
+
+// toS3Error converts the errors shared with the rest of the API.
+func toS3Error(err error) *s3Error {
+	status := fiber.StatusInternalServerError
+	message := "We encountered an internal error. Please try again."
+	var apiE *utils.APIError
+	var fiberE *fiber.Error
+	if errors.As(err, &apiE) {
+		status = apiE.Code
+		if status != fiber.StatusInternalServerError {
+			message = apiE.Message
+		}
+	} else if errors.As(err, &fiberE) {
+		status, message = fiberE.Code, fiberE.Message
+	}
+
+	switch status {
+	case fiber.StatusBadRequest, fiber.StatusUnsupportedMediaType:
+		return newS3Error(status, "InvalidArgument", message)
+	case fiber.StatusUnauthorized, fiber.StatusForbidden:
+		return newS3Error(status, "AccessDenied", message)
+	case fiber.StatusNotFound:
+		return newS3Error(status, "NoSuchKey", message)
+	case fiber.StatusConflict:
+		return newS3Error(status, "OperationAborted", message)
+	case fiber.StatusPreconditionFailed:
+		return newS3Error(status, "PreconditionFailed", message)
+	case fiber.StatusRequestEntityTooLarge:
+		return newS3Error(status, "EntityTooLarge", message)
+	case fiber.StatusRequestedRangeNotSatisfiable:
+		return newS3Error(status, "InvalidRange", message)
+	case fiber.StatusMethodNotAllowed:
+		return newS3Error(status, "MethodNotAllowed", message)
+	}
+	return newS3Error(fiber.StatusInternalServerError, "InternalError", message)
+}
+
+func sendS3XML(c *fiber.Ctx, status int, v any) error {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationXML)
+	return c.Status(status).Send(append([]byte(xml.Header), body...))
+}
+
+// s3ObjectParams returns the bucket and the key of an object route.
+func s3ObjectParams(c *fiber.Ctx) (string, string, error) {
+	bucketId := c.Params("bucket")
+	key, err := url.PathUnescape(c.Params("*"))
+	if err != nil || key == "" || !validKey(blobKey(bucketId, key)) {
+		return "", "", newS3Error(http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+	}
+	return bucketId, key, nil
+}
+
+// checkS3Bucket fails with NoSuchBucket unless a bucket exists.
+func (me *Server) checkS3Bucket(bucketId string) error {
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return errS3NoSuchBucket
+	}
+	return nil
+}
+
+func (me *Server) handleS3ListBuckets(c *fiber.Ctx) error {
+	buckets, err := me.metadata.getAllBuckets()
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	result := s3ListAllMyBucketsResult{Xmlns: s3Namespace, Buckets: []s3Bucket{}}
+	for _, bucket := range buckets {
+		result.Buckets = append(result.Buckets, s3Bucket{
+			Name:         bucket.Id,
+			CreationDate: bucket.CreatedAt.UTC().Format(s3TimeFormat),
+		})
+	}
+
+	return sendS3XML(c, fiber.StatusOK, result)
+}
+
+func (me *Server) handleS3HeadBucket(c *fiber.Ctx) error {
+	if err := me.checkS3Bucket(c.Params("bucket")); err != nil {
+		return err
+	}
+	c.Status(fiber.StatusOK)
+	return nil
+}
+
+func (me *Server) handleS3CreateBucket(c *fiber.Ctx) error {
+	bucketId := c.Params("bucket")
+	if !validBucketId(bucketId) {
+		return newS3Error(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	}
+
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if exists {
+		return newS3Error(http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it.")
+	}
+
+	bucket := &Bucket{
+		Id:        bucketId,
+		Policy:    PolicyPrivate,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := me.metadata.createBucket(bucket); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	c.Set(fiber.HeaderLocation, "/"+bucketId)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func (me *Server) handleS3DeleteBucket(c *fiber.Ctx) error {
+	bucketId := c.Params("bucket")
+	if err := me.checkS3Bucket(bucketId); err != nil {
+		return err
+	}
+
+	blobs, err := me.metadata.getBlobsPerBucket(bucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	if len(blobs) > 0 {
+		return newS3Error(http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty")
+	}
+
+	if err := me.removeBucket(bucketId); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleS3GetBucket answers GetBucketLocation, ListObjects and ListObjectsV2.
+func (me *Server) handleS3GetBucket(c *fiber.Ctx) error {
+	bucketId := c.Params("bucket")
+	if err := me.checkS3Bucket(bucketId); err != nil {
+		return err
+	}
+
+	query := c.Request().URI().QueryArgs()
+	if query.Has("location") {
+		// The default region is reported as an empty location, like S3 does.
+		location := me.s3.Region
+		if location == DefaultS3Region {
+			location = ""
+		}
+		return sendS3XML(c, fiber.StatusOK, s3LocationConstraint{Xmlns: s3Namespace, Region: location})
+	}
+	for _, subresource := range []string{"acl", "cors", "lifecycle", "policy", "tagging", "uploads", "versioning", "versions"} {
+		if query.Has(subresource) {
+			return newS3Error(http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented")
+		}
+	}
+
+	return me.listS3Objects(c, bucketId)
+}
+
+// listS3Objects answers ListObjects and, with list-type=2, ListObjectsV2.
+func (me *Server) listS3Objects(c *fiber.Ctx, bucketId string) error {
+	var (
+		v2        = c.Query("list-type") == "2"
+		prefix    = c.Query("prefix")
+		delimiter = c.Query("delimiter")
+		encode    = c.Query("encoding-type") == "url"
+		maxKeys   = s3MaxKeys
+		after     string
+	)
+	if value := c.Query("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return newS3Error(http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer")
+		}
+		maxKeys = min(n, s3MaxKeys)
+	}
+
+	result := s3ListBucketResult{
+		Xmlns:     s3Namespace,
+		Name:      bucketId,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   maxKeys,
+	}
+	if v2 {
+		result.StartAfter = c.Query("start-after")
+		result.ContinuationToken = c.Query("continuation-token")
+		after = result.StartAfter
+		if result.ContinuationToken != "" {
+			token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+			if err != nil {
+				return newS3Error(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+			}
+			after = string(token)
+		}
+	} else {
+		result.Marker = c.Query("marker")
+		after = result.Marker
+	}
+
+	blobs, err := me.metadata.getBlobsPerBucket(bucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	slices.SortFunc(blobs, func(a, b *Blob) int { return strings.Compare(a.Id, b.Id) })
+
+	var (
+		count int
+		last  string
+	)
+	for _, blob := range blobs {
+		if !strings.HasPrefix(blob.Id, prefix) || blob.Id <= after {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(blob.Id[len(prefix):], delimiter); i >= 0 {
+				commonPrefix := blob.Id[:len(prefix)+i+len(delimiter)]
+				// Keys under a prefix that was already listed are skipped.
+				if commonPrefix <= after || commonPrefix == last {
+					continue
+				}
+				if count == maxKeys {
+					result.IsTruncated = true
+					break
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: commonPrefix})
+				count++
+				last = commonPrefix
+				continue
+			}
+		}
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.Contents = append(result.Contents, s3Object{
+			Key:          blob.Id,
+			LastModified: blob.UpdatedAt.UTC().Format(s3TimeFormat),
+			ETag:         blobETag(blob),
+			Size:         blob.Size,
+			StorageClass: "STANDARD",
+		})
+		count++
+		last = blob.Id
+	}
+
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else {
+			result.NextMarker = last
+		}
+	}
+	if v2 {
+		result.KeyCount = count
+	}
+	if encode {
+		result.EncodingType = "url"
+		result.Prefix, result.Delimiter = s3URLEncode(result.Prefix), s3URLEncode(result.Delimiter)
+		result.StartAfter, result.Marker, result.NextMarker = s3URLEncode(result.StartAfter), s3URLEncode(result.Marker), s3URLEncode(result.NextMarker)
+		for i := range result.Contents {
+			result.Contents[i].Key = s3URLEncode(result.Contents[i].Key)
+		}
+		for i := range result.CommonPrefixes {
+			result.CommonPrefixes[i].Prefix = s3URLEncode(result.CommonPrefixes[i].Prefix)
+		}
+	}
+
+	return sendS3XML(c, fiber.StatusOK, result)
+}
+
+// s3URLEncode encodes keys for listings requested with encoding-type=url.
+func s3URLEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// handleS3GetObject answers GetObject and HeadObject.
+func (me *Server) handleS3GetObject(c *fiber.Ctx) error {
+	bucketId, key, err := s3ObjectParams(c)
+	if err != nil {
+		return err
+	}
+	if err := me.checkS3Bucket(bucketId); err != nil {
+		return err
+	}
+
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, key); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return errS3NoSuchKey
+	}
+
+	blob, err := me.metadata.getBlob(bucketId, key)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	if c.Method() == fiber.MethodHead {
+		return me.serveBlobHead(c, blob)
+	}
+	return me.serveBlob(c, blob)
+}
+
+// handleS3PutObject answers PutObject and UploadPart.
+func (me *Server) handleS3PutObject(c *fiber.Ctx) error {
+	if c.Query("uploadId") != "" {
+		return me.handleS3UploadPart(c)
+	}
+
+	bucketId, key, err := s3ObjectParams(c)
+	if err != nil {
+		return err
+	}
+	if c.Get("X-Amz-Copy-Source") != "" {
+		return newS3Error(http.StatusNotImplemented, "NotImplemented", "CopyObject is not implemented")
+	}
+	contentType := c.Get(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return newS3Error(http.StatusBadRequest, "InvalidArgument", "Invalid Content-Type")
+	}
+	if err := me.checkS3Bucket(bucketId); err != nil {
+		return err
+	}
+	if err := verifyChunk(c, c.Body()); err != nil {
+		return newS3Error(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+	}
+
+	// Conditional writes only apply to existing objects, except for
+	// If-None-Match: *, which only creates objects.
+	if c.Get(fiber.HeaderIfMatch) != "" {
+		if exists, err := me.metadata.checkIfBlobExists(bucketId, key); err != nil {
+			return utils.InternalServerError(err)
+		} else if !exists {
+			return errS3NoSuchKey
+		}
+	}
+
+	blob, err := me.replaceBlob(bucketId, key, contentType, BlobSealed, bytes.NewReader(c.Body()), checkOpen(), checkWritePreconditions(c))
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderETag, blobETag(blob))
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// handleS3DeleteObject answers DeleteObject and AbortMultipartUpload.
+func (me *Server) handleS3DeleteObject(c *fiber.Ctx) error {
+	bucketId, key, err := s3ObjectParams(c)
+	if err != nil {
+		return err
+	}
+	if err := me.checkS3Bucket(bucketId); err != nil {
+		return err
+	}
+
+	if uploadId := c.Query("uploadId"); uploadId != "" {
+		if err := me.abortUpload(bucketId, key, uploadId); err != nil {
+			var apiE *utils.APIError
+			if errors.As(err, &apiE) && apiE.Code == fiber.StatusNotFound {
+				return errS3NoSuchUpload
+			}
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	// Deleting a missing object succeeds, like in S3.
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, key); err != nil {
+		return utils.InternalServerError(err)
+	} else if exists {
+		if err := me.removeBlob(bucketId, key); err != nil {
+			return err
+		}
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleS3PostObject answers CreateMultipartUpload and CompleteMultipartUpload.
+func (me *Server) handleS3PostObject(c *fiber.Ctx) error {
+	query := c.Request().URI().QueryArgs()
+	switch {
+	case query.Has("uploads"):
+		return me.handleS3CreateMultipartUpload(c)
+	case query.Has("uploadId"):
+		return me.handleS3CompleteMultipartUpload(c)
+	}
+	return newS3Error(http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented")
+}
+
+func (me *Server) handleS3CreateMultipartUpload(c *fiber.Ctx) error {
+	bucketId, key, err := s3ObjectParams(c)
+	if err != nil {
+		return err
+	}
+	contentType := c.Get(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return newS3Error(http.StatusBadRequest, "InvalidArgument", "Invalid Content-Type")
+	}
+	if err := me.checkS3Bucket(bucketId); err != nil {
+		return err
+	}
+
+	upload := &Upload{
+		Id:        ulid.Make().String(),
+		BucketId:  bucketId,
+		BlobId:    key,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	// Uploads belong to blobs, so new keys get an empty blob until the upload
+	// is completed. It's removed again if the upload is aborted while it's
+	// still empty, see removePlaceholder.
+	var placeholder string
+	unlock := me.blobLocks.lock(blobKey(bucketId, key))
+	defer unlock()
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, key); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		blob, err := me.createEmptyBlob(bucketId, key, contentType)
+		if err != nil {
+			return err
+		}
+		placeholder = blob.VersionId
+	} else if blob, err := me.metadata.getBlob(bucketId, key); err != nil {
+		return utils.InternalServerError(err)
+	} else if err := checkOpen()(blob); err != nil {
+		// The upload could never be completed.
+		return err
+	}
+	if err := me.metadata.createUpload(upload, placeholder); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return sendS3XML(c, fiber.StatusOK, s3InitiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   bucketId,
+		Key:      key,
+		UploadId: upload.Id,
+	})
+}
+
+func (me *Server) handleS3UploadPart(c *fiber.Ctx) error {
+	bucketId, key, err := s3ObjectParams(c)
+	if err != nil {
+		return err
+	}
+	uploadId := c.Query("uploadId")
+	number, err := strconv.Atoi(c.Query("partNumber"))
+	if err != nil || number < 1 || number > maxPartNumber {
+		return newS3Error(http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("Part number must be an integer between 1 and %d, inclusive", maxPartNumber))
+	}
+	if err := me.checkS3Bucket(bucketId); err != nil {
+		return err
+	}
+
+	if exists, err := me.metadata.checkIfUploadExists(bucketId, key, uploadId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return errS3NoSuchUpload
+	}
+
+	if err := verifyChunk(c, c.Body()); err != nil {
+		return newS3Error(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+	}
+
+	if _, err := me.storeUploadPart(bucketId, key, uploadId, number, c.Body()); err != nil {
+		// The upload might have been completed or aborted meanwhile.
+		var apiE *utils.APIError
+		if errors.As(err, &apiE) && apiE.Code == fiber.StatusNotFound {
+			return errS3NoSuchUpload
+		}
+		return err
+	}
+
+	sum := md5.Sum(c.Body())
+	c.Set(fiber.HeaderETag, `"`+hex.EncodeToString(sum[:])+`"`)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func (me *Server) handleS3CompleteMultipartUpload(c *fiber.Ctx) error {
+	bucketId, key, err := s3ObjectParams(c)
+	if err != nil {
+		return err
+	}
+	uploadId := c.Query("uploadId")
+
+	var req s3CompleteMultipartUpload
+	if err := xml.Unmarshal(c.Body(), &req); err != nil || len(req.Parts) == 0 {
+		return newS3Error(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+	}
+	// Parts are identified by their numbers; their ETags aren't verified.
+	numbers := make([]int, 0, len(req.Parts))
+	for i, part := range req.Parts {
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			return newS3Error(http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+		}
+		numbers = append(numbers, part.PartNumber)
+	}
+	if err := me.checkS3Bucket(bucketId); err != nil {
+		return err
+	}
+
+	unlock := me.blobLocks.lock(uploadPrefix(uploadId))
+	defer unlock()
+
+	if exists, err := me.metadata.checkIfUploadExists(bucketId, key, uploadId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return errS3NoSuchUpload
+	}
+
+	upload, err := me.metadata.getUpload(uploadId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	for _, number := range numbers {
+		if !slices.ContainsFunc(upload.Parts, func(part *Part) bool { return part.Number == number }) {
+			return newS3Error(http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d was not uploaded", number))
+		}
+	}
+	blob, err := me.metadata.getBlob(bucketId, key)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	parts, closeParts, err := me.openUploadParts(upload, numbers)
+	if err != nil {
+		return err
+	}
+	defer closeParts()
+
+	if blob, err = me.replaceBlob(bucketId, key, blob.ContentType, BlobSealed, parts, checkOpen()); err != nil {
+		return err
+	}
+
+	if err := me.discardUploads(upload); err != nil {
+		return err
+	}
+
+	return sendS3XML(c, fiber.StatusOK, s3CompleteMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: me.s3.Prefix + "/" + bucketId + "/" + key,
+		Bucket:   bucketId,
+		Key:      key,
+		ETag:     blobETag(blob),
+	})
+}
@@ -0,0 +1,95 @@
+package blob
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Blobs are created open and appended to until they're sealed, which marks
+// their content complete. Sealed blobs can't be appended to, changed in place
+// or overwritten anymore, not even by S3 puts. Open content that is replaced
+// as a whole starts a new, open generation, or a sealed one for S3 objects,
+// which are complete once written. Older versions keep the state they were
+// archived in.
+
+// checkOpen returns a blobCheck that fails if a blob is sealed.
+func checkOpen() blobCheck {
+	return func(blob *Blob) error {
+		if blob.State == BlobSealed {
+			return utils.ConflictError("blob is sealed")
+		}
+		return nil
+	}
+}
+
+// checkDownloadable fails for open blobs if the server only serves sealed ones.
+func (me *Server) checkDownloadable(blob *Blob) error {
+	if me.requireSealed && blob.State != BlobSealed {
+		return utils.ConflictError("blob is still open")
+	}
+	return nil
+}
+
+// handleSealBlob marks a blob complete. With the size or sha256 query params,
+// it's only sealed if its content has that size or checksum.
+func (me *Server) handleSealBlob(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+		sha256   = strings.TrimSpace(c.Query("sha256"))
+		size     = -1
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+	if value := strings.TrimSpace(c.Query("size")); value != "" {
+		var err error
+		if size, err = strconv.Atoi(value); err != nil || size < 0 {
+			return utils.BadRequestError("invalid value for query param size")
+		}
+	}
+
+	unlock := me.blobLocks.lock(blobKey(bucketId, blobId))
+	defer unlock()
+
+	blob, err := me.metadata.getBlob(bucketId, blobId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.NotFoundError("blob not found")
+		}
+		return utils.InternalServerError(err)
+	}
+
+	if err := checkWritePreconditions(c)(blob); err != nil {
+		return err
+	}
+	if blob.State == BlobSealed {
+		return utils.ConflictError("blob is already sealed")
+	}
+	if size >= 0 && blob.Size != size {
+		return utils.ConflictError(fmt.Sprintf("blob size is %d, not %d", blob.Size, size))
+	}
+	if sha256 != "" && !strings.EqualFold(blob.Sha256, sha256) {
+		return utils.ConflictError("blob sha256 doesn't match")
+	}
+
+	blob.State = BlobSealed
+	if err := me.retainNewContent(blob, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := me.metadata.sealBlob(blob); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(blob)
+}
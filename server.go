@@ -3,49 +3,167 @@ package blob
 // TODO: add api doc
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/assaidy/blob/utils"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 )
 
 // ServerConfig holds the configuration for initializing a Server instance.
 type ServerConfig struct {
-	MaxChunkSize DataUnite // Maximum size of data chunks in bytes (in upload and download).
-	SecretKey    string    // Secret key used for authentication.
-	RootDir      string    // Root directory for storing data.
-	MetadataDir  string    // Directory for storing metadata.
+	MaxChunkSize  DataUnite     // Maximum size of data chunks in bytes (in upload and download).
+	SecretKey     string        // Root key with every scope on every bucket, sent in the Secret-Key header. Empty disables it.
+	RootDir       string        // Root directory for storing data.
+	MetadataDir   string        // Directory for storing metadata.
+	Storage       Storage       // Backend for blob data. Defaults to a local storage under RootDir.
+	SweepInterval time.Duration // Interval of purging dead access keys and expired trash. Defaults to DefaultSweepInterval.
+	SigningKey    string        // Key used to sign stateless URLs. Defaults to SecretKey; one of them must be set.
+	S3            *S3Config     // Enables the S3 compatible API if not nil.
+	// How long deleted buckets and blobs can be restored from the trash. Defaults
+	// to DefaultTrashRetention; negative makes deletes permanent.
+	TrashRetention time.Duration
+	// Interval of applying the lifecycle rules of buckets. Defaults to
+	// DefaultLifecycleInterval.
+	LifecycleInterval time.Duration
+	// Refuses to serve open blobs, and to create download keys and URLs for
+	// them, so only complete content is ever downloaded.
+	RequireSealed bool
 }
 
-// NewServer initializes a new Server instance based on the provided configuration.
+// Validate reports every invalid field of the configuration, which New may
+// fail or misbehave with.
+func (me ServerConfig) Validate() error {
+	var errs []error
+	if me.MaxChunkSize <= 0 {
+		errs = append(errs, errors.New("MaxChunkSize must be positive"))
+	}
+	// Anyone could sign URLs with an empty key.
+	if me.SigningKey == "" && me.SecretKey == "" {
+		errs = append(errs, errors.New("SigningKey or SecretKey must be set"))
+	}
+	if me.Storage == nil && me.RootDir == "" {
+		errs = append(errs, errors.New("RootDir must be set unless Storage is"))
+	}
+	if me.MetadataDir == "" {
+		errs = append(errs, errors.New("MetadataDir must be set"))
+	}
+	if me.SweepInterval < 0 {
+		errs = append(errs, errors.New("SweepInterval must not be negative"))
+	}
+	if me.LifecycleInterval < 0 {
+		errs = append(errs, errors.New("LifecycleInterval must not be negative"))
+	}
+	if me.S3 != nil {
+		if me.S3.Prefix != "" && (!strings.HasPrefix(me.S3.Prefix, "/") || strings.HasSuffix(me.S3.Prefix, "/")) {
+			errs = append(errs, fmt.Errorf("S3.Prefix must start and not end with '/', got %q", me.S3.Prefix))
+		}
+		if len(me.S3.Credentials) == 0 {
+			errs = append(errs, errors.New("S3.Credentials must not be empty"))
+		}
+		for accessKeyId, secret := range me.S3.Credentials {
+			if accessKeyId == "" || secret == "" {
+				errs = append(errs, errors.New("S3.Credentials must not have empty access key ids or secrets"))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewServer is like New, but panics if the server can't be initialized.
 func NewServer(config ServerConfig) *Server {
-	// Ensure the root directory exists; create it if necessary.
-	if err := os.MkdirAll(config.RootDir, os.ModePerm); err != nil {
-		panic(fmt.Sprintf("error creating root dir: %+v", err))
+	server, err := New(config)
+	if err != nil {
+		panic(err)
+	}
+	return server
+}
+
+// New initializes a new Server instance based on the provided configuration,
+// which should be checked with ServerConfig.Validate first. It fails if the
+// directories or the metadata database can't be set up.
+func New(config ServerConfig) (*Server, error) {
+	// Default to storing blobs under the root directory.
+	if config.Storage == nil {
+		// Ensure the root directory exists; create it if necessary.
+		if err := os.MkdirAll(config.RootDir, os.ModePerm); err != nil {
+			return nil, fmt.Errorf("error creating root dir: %w", err)
+		}
+		config.Storage = NewLocalStorage(config.RootDir)
 	}
 	// Ensure the metadata directory exists; create it if necessary.
 	if err := os.MkdirAll(config.MetadataDir, os.ModePerm); err != nil {
-		panic(fmt.Sprintf("error creating metadata path: %+v", err))
+		return nil, fmt.Errorf("error creating metadata path: %w", err)
+	}
+	metadata, err := NewMetadataStorage(config.MetadataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SigningKey == "" {
+		config.SigningKey = config.SecretKey
+	}
+	if config.S3 != nil {
+		s3 := *config.S3
+		if s3.Prefix == "" {
+			s3.Prefix = DefaultS3Prefix
+		}
+		if s3.Region == "" {
+			s3.Region = DefaultS3Region
+		}
+		config.S3 = &s3
+	}
+
+	if config.TrashRetention == 0 {
+		config.TrashRetention = DefaultTrashRetention
 	}
 
 	server := &Server{
-		rootDir:      config.RootDir,
-		secretKey:    config.SecretKey,
-		maxChunkSize: config.MaxChunkSize,
-		metadata:     NewMetadataStorage(config.MetadataDir),
+		storage:        config.Storage,
+		secretKey:      config.SecretKey,
+		signingKey:     config.SigningKey,
+		s3:             config.S3,
+		maxChunkSize:   config.MaxChunkSize,
+		trashRetention: config.TrashRetention,
+		requireSealed:  config.RequireSealed,
+		blobLocks:      newKeyedMutex(),
+		done:           make(chan struct{}),
+		metadata:       metadata,
 		router: fiber.New(fiber.Config{
 			BodyLimit:    int(config.MaxChunkSize),
 			ErrorHandler: errorHandler,
 		}),
 	}
+	if err := server.backfillChecksums(); err != nil {
+		metadata.close()
+		return nil, fmt.Errorf("error computing checksums: %w", err)
+	}
+	// Connections of responses sent while shutting down are closed, rather than
+	// kept alive for requests that would never be served.
+	server.router.Server().CloseOnShutdown = true
 	server.regesterRoutes()
 	server.router.Use(logger.New())
 
-	return server
+	if config.SweepInterval <= 0 {
+		config.SweepInterval = DefaultSweepInterval
+	}
+	server.runPeriodically("sweeping accesses", config.SweepInterval, server.sweepAccesses)
+	server.runPeriodically("purging trash", config.SweepInterval, server.purgeExpiredTrash)
+	if config.LifecycleInterval <= 0 {
+		config.LifecycleInterval = DefaultLifecycleInterval
+	}
+	server.runPeriodically("applying lifecycle rules", config.LifecycleInterval, server.applyLifecycleRules)
+
+	return server, nil
 }
 
 // Listen starts the server and listens on the specified address.
@@ -53,39 +171,175 @@ func (me *Server) Listen(addr string) error {
 	return me.router.Listen(addr)
 }
 
+// Serve starts the server on a listener, like a Unix domain socket or a TCP
+// port picked by the system, and takes ownership of it.
+func (me *Server) Serve(ln net.Listener) error {
+	return me.router.Listener(ln)
+}
+
+// Handler returns the server as a net/http handler, to mount it in another
+// server or serve it with httptest. Request and response bodies are buffered,
+// so it's meant for tests and small blobs.
+func (me *Server) Handler() http.Handler {
+	return adaptor.FiberApp(me.router)
+}
+
+// Test handles a request in process, without a listener, like fiber's
+// App.Test. It isn't safe to call concurrently with itself.
+func (me *Server) Test(req *http.Request) (*http.Response, error) {
+	return me.router.Test(req, -1)
+}
+
+// Shutdown stops accepting connections, waits for in-flight requests like
+// uploads to finish, then closes the server. Idle keep-alive connections are
+// closed, and the others once their response is sent. Connections that haven't
+// sent a request yet are waited for up to 5 seconds. If ctx is done first,
+// its error is returned and the server is left open, to be closed by Close.
+// Listen and Serve return once it's called.
+func (me *Server) Shutdown(ctx context.Context) error {
+	if err := me.router.ShutdownWithContext(ctx); err != nil {
+		return err
+	}
+	return me.Close()
+}
+
+// Close stops accepting connections and requests, waits for the handlers of
+// in-flight requests to return, so no write is cut off halfway, stops the
+// background jobs and closes the metadata database. Responses still being
+// sent, like streamed downloads, aren't waited for. It's safe to call more
+// than once.
+func (me *Server) Close() error {
+	me.closeOnce.Do(func() {
+		me.handlersMu.Lock()
+		me.closing = true
+		me.handlersMu.Unlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		// Open connections aren't waited for, so the error is expected.
+		me.router.ShutdownWithContext(ctx)
+		me.handlers.Wait()
+
+		close(me.done)
+		me.jobs.Wait()
+		me.closeErr = me.metadata.close()
+	})
+	return me.closeErr
+}
+
+// mwTrackHandlers counts the in-flight handlers Close waits for, and refuses
+// requests once it's called.
+func (me *Server) mwTrackHandlers(c *fiber.Ctx) error {
+	me.handlersMu.Lock()
+	if me.closing {
+		me.handlersMu.Unlock()
+		return utils.ServiceUnavailableError("server is closing")
+	}
+	me.handlers.Add(1)
+	me.handlersMu.Unlock()
+	defer me.handlers.Done()
+
+	return c.Next()
+}
+
 // regesterRoutes defines all API routes for the server.
 func (me *Server) regesterRoutes() {
+	me.router.Use(me.mwTrackHandlers)
+
+	// Open routes are registered first, so the closed group's middleware,
+	// which is mounted on "/", doesn't shadow them.
+	open := me.router.Group("/")
+	// HEAD must be registered before GET, which also answers HEAD requests.
+	open.Get("/info", me.handleGetInfo)
+	open.Head("/access/:key", me.handleHeadWithAccess)
+	open.Get("/access/:key", me.handleDownloadWithAccess)
+	open.Put("/access/:key", me.handleUploadWithAccess)
+	open.Patch("/access/:key", me.handleUploadWithAccess)
+	open.Get("/signed/:bucket_id/:blob_id", me.handleDownloadWithSignedURL)
+	open.Put("/signed/:bucket_id/:blob_id", me.handleUploadWithSignedURL)
+	open.Patch("/signed/:bucket_id/:blob_id", me.handleUploadWithSignedURL)
+	open.Get("/public/:bucket_id", me.mwWithPolicy(PolicyPublicList), me.handleGetAllBlobs)
+	open.Get("/public/:bucket_id/:blob_id", me.mwWithPolicy(PolicyPublicRead), me.handleDownloadPublicBlob)
+
+	if me.s3 != nil {
+		me.registerS3Routes()
+	}
+
+	closed := me.router.Group("/", me.mwWithCredentials)
 	var (
-		closed = me.router.Group("/", me.mwWithSecreteKey)
-		open   = me.router.Group("/")
+		read  = me.mwWithScope(ScopeRead)
+		write = me.mwWithScope(ScopeWrite)
+		del   = me.mwWithScope(ScopeDelete)
 	)
 
 	// Bucket-related routes.
-	closed.Post("/buckets", me.handleCreateBucket)
-	closed.Get("/buckets", me.handleGetAllBuckets)
-	closed.Get("/buckets/:bucket_id", me.handleGetBucket)
-	closed.Delete("/buckets/:bucket_id", me.handleDeleteBucket)
+	closed.Post("/buckets", write, me.handleCreateBucket)
+	closed.Get("/buckets", read, me.handleGetAllBuckets)
+	closed.Get("/buckets/:bucket_id", read, me.handleGetBucket)
+	closed.Delete("/buckets/:bucket_id", del, me.handleDeleteBucket)
+	closed.Put("/buckets/:bucket_id/policy", me.mwWithScope(ScopeAdmin), me.handleSetBucketPolicy)
 
 	// Blob-related routes.
-	closed.Post("/buckets/:bucket_id/blobs", me.handleCreateBlob)
-	closed.Put("/buckets/:bucket_id/blobs/:blob_id", me.handleWriteToBlob)
-	closed.Get("/buckets/:bucket_id/blobs", me.handleGetAllBlobs)
-	closed.Delete("/buckets/:bucket_id/blobs/:blob_id", me.handleDeleteBlob)
-	closed.Get("/buckets/:bucket_id/blobs/:blob_id", me.handleGetBlob)
+	closed.Post("/buckets/:bucket_id/blobs", write, me.handleCreateBlob)
+	closed.Put("/buckets/:bucket_id/blobs/:blob_id", write, me.handleWriteToBlob)
+	closed.Get("/buckets/:bucket_id/blobs", read, me.handleGetAllBlobs)
+	closed.Delete("/buckets/:bucket_id/blobs/:blob_id", del, me.handleDeleteBlob)
+	closed.Head("/buckets/:bucket_id/blobs/:blob_id", read, me.handleHeadBlob)
+	closed.Get("/buckets/:bucket_id/blobs/:blob_id", read, me.handleGetBlob)
+	closed.Post("/buckets/:bucket_id/blobs/:blob_id/seal", write, me.handleSealBlob)
+	closed.Post("/buckets/:bucket_id/blobs/:blob_id/truncate", write, me.handleTruncateBlob)
+
+	// Versioning routes.
+	closed.Put("/buckets/:bucket_id/versioning", me.mwWithScope(ScopeAdmin), me.handleSetBucketVersioning)
+	closed.Get("/buckets/:bucket_id/versions", read, me.handleGetVersionsPerBucket)
+	closed.Get("/buckets/:bucket_id/blobs/:blob_id/versions", read, me.handleGetVersionsPerBlob)
+	closed.Get("/buckets/:bucket_id/blobs/:blob_id/versions/:version_id", read, me.handleGetVersion)
+	closed.Get("/buckets/:bucket_id/blobs/:blob_id/versions/:version_id/content", read, me.handleDownloadVersion)
+	closed.Post("/buckets/:bucket_id/blobs/:blob_id/versions/:version_id/restore", write, me.handleRestoreVersion)
+	closed.Delete("/buckets/:bucket_id/blobs/:blob_id/versions/:version_id", del, me.handlePurgeVersion)
+
+	// Object lock routes.
+	closed.Put("/buckets/:bucket_id/object-lock", me.mwWithScope(ScopeAdmin), me.handleSetBucketObjectLock)
+	closed.Put("/buckets/:bucket_id/blobs/:blob_id/retention", me.mwWithScope(ScopeAdmin), me.handleSetBlobRetention)
+	closed.Put("/buckets/:bucket_id/blobs/:blob_id/legal-hold", me.mwWithScope(ScopeAdmin), me.handleSetBlobLegalHold)
+
+	// Lifecycle routes.
+	closed.Get("/buckets/:bucket_id/lifecycle", read, me.handleGetBucketLifecycle)
+	closed.Put("/buckets/:bucket_id/lifecycle", me.mwWithScope(ScopeAdmin), me.handleSetBucketLifecycle)
+
+	// Resumable upload (tus) routes.
+	closed.Options("/buckets/:bucket_id/blobs/:blob_id", read, me.handleTusOptions)
+	closed.Patch("/buckets/:bucket_id/blobs/:blob_id", write, me.handlePatchBlob)
 
-	// Access key management routes.
+	// Multipart upload routes.
+	closed.Get("/buckets/:bucket_id/uploads", read, me.handleGetUploadsPerBucket)
+	closed.Post("/buckets/:bucket_id/blobs/:blob_id/uploads", write, me.handleCreateUpload)
+	closed.Get("/buckets/:bucket_id/blobs/:blob_id/uploads", read, me.handleGetUploadsPerBlob)
+	closed.Get("/buckets/:bucket_id/blobs/:blob_id/uploads/:upload_id", read, me.handleGetUpload)
+	closed.Put("/buckets/:bucket_id/blobs/:blob_id/uploads/:upload_id/parts/:part_number", write, me.handleUploadPart)
+	closed.Post("/buckets/:bucket_id/blobs/:blob_id/uploads/:upload_id/complete", write, me.handleCompleteUpload)
+	closed.Delete("/buckets/:bucket_id/blobs/:blob_id/uploads/:upload_id", write, me.handleAbortUpload)
+
+	// Trash routes. They're authorized by the bucket of the trashed item.
+	closed.Get("/trash", read, me.handleGetTrash)
+	closed.Post("/trash/:trash_id/restore", me.handleRestoreTrashItem)
+	closed.Delete("/trash/:trash_id", me.handlePurgeTrashItem)
+
+	// Access key management routes. They're authorized by the scope of the
+	// access key, see accessScope.
 	closed.Post("/access", me.handleCreateAccess)
-	open.Get("/access/:key", me.handleDownloadWithAccess)
 	closed.Delete("/access/:key", me.handleDeleteAccess)
-}
 
-// mwWithSecreteKey is middleware that validates the secret key for authenticated routes.
-func (me *Server) mwWithSecreteKey(c *fiber.Ctx) error {
-	key := strings.TrimSpace(c.Get("Secret-Key"))
-	if key == "" || key != me.secretKey {
-		return utils.UnauthorizedError()
-	}
-	return c.Next()
+	// Signed URL routes, authorized like access keys.
+	closed.Post("/signed-urls", me.handleCreateSignedURL)
+
+	// API key management routes.
+	keys := closed.Group("/keys", me.mwWithKeyManagement)
+	keys.Post("/", me.handleCreateAPIKey)
+	keys.Get("/", me.handleGetAllAPIKeys)
+	keys.Post("/:key_id/disable", me.handleDisableAPIKey)
+	keys.Post("/:key_id/enable", me.handleEnableAPIKey)
+	keys.Post("/:key_id/rotate", me.handleRotateAPIKey)
 }
 
 // errorHandler is a custom error handler that formats errors for the API response.
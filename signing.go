@@ -0,0 +1,302 @@
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gotd/contrib/http_range"
+)
+
+// Signed URLs grant access to a blob without an access key stored in the
+// metadata: the grant is carried by the URL itself and authenticated by an
+// HMAC-SHA256 over its fields, keyed by ServerConfig.SigningKey. Like access
+// keys, they're scoped to either downloading or uploading.
+
+const signedURLPathPrefix = "/signed/"
+
+// errSignedURLsDisabled is returned by the signed URL routes of servers without
+// a signing key, whose signatures anyone could forge.
+var errSignedURLsDisabled = utils.ForbiddenError("signed urls are disabled, the server has no signing key")
+
+// SignedURL describes a stateless URL granting access to a blob until it expires.
+type SignedURL struct {
+	BucketId    string
+	BlobId      string
+	Scope       string // ScopeDownload or ScopeUpload. Defaults to ScopeDownload.
+	ExpiresAt   time.Time
+	Range       string // Optional byte range a download URL is restricted to, like "0-1023".
+	IP          string // Optional client IP the URL is restricted to.
+	MaxSize     int    // Optional size an upload URL may grow the blob to. 0 means unlimited.
+	ContentType string // Optional media type an upload URL accepts.
+}
+
+// Path returns the path of the URL, relative to the server's address.
+func (me SignedURL) Path() string {
+	return signedURLPathPrefix + url.PathEscape(me.BucketId) + "/" + url.PathEscape(me.BlobId)
+}
+
+// Query returns the query of the URL, signed with key.
+func (me SignedURL) Query(key string) url.Values {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(me.ExpiresAt.Unix(), 10))
+	if me.Scope != "" && me.Scope != ScopeDownload {
+		query.Set("scope", me.Scope)
+	}
+	if me.Range != "" {
+		query.Set("range", me.Range)
+	}
+	if me.IP != "" {
+		query.Set("ip", me.IP)
+	}
+	if me.MaxSize > 0 {
+		query.Set("max_size", strconv.Itoa(me.MaxSize))
+	}
+	if me.ContentType != "" {
+		query.Set("content_type", me.ContentType)
+	}
+	query.Set("signature", me.signature(key))
+	return query
+}
+
+// String returns the path and signed query of the URL.
+func (me SignedURL) String(key string) string {
+	return me.Path() + "?" + me.Query(key).Encode()
+}
+
+func (me SignedURL) signature(key string) string {
+	fields := []string{
+		ScopeDownload,
+		me.BucketId,
+		me.BlobId,
+		strconv.FormatInt(me.ExpiresAt.Unix(), 10),
+		me.Range,
+		me.IP,
+	}
+	if me.Scope == ScopeUpload {
+		fields[0] = ScopeUpload
+		fields = append(fields, strconv.Itoa(me.MaxSize), me.ContentType)
+	}
+	// Every field is prefixed with its length, so no two URLs sign the same
+	// bytes, whatever their ids hold.
+	mac := hmac.New(sha256.New, []byte(key))
+	for _, field := range fields {
+		fmt.Fprintf(mac, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseSignedURL reads a signed URL from a request and verifies its signature,
+// expiry and IP constraint.
+func (me *Server) parseSignedURL(c *fiber.Ctx) (*SignedURL, error) {
+	if me.signingKey == "" {
+		return nil, errSignedURLsDisabled
+	}
+
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+	)
+	if bucketId == "" {
+		return nil, utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return nil, utils.BadRequestError("invalid value for path param blob_id")
+	}
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		return nil, utils.BadRequestError("invalid value for query param expires")
+	}
+
+	signed := &SignedURL{
+		BucketId:    bucketId,
+		BlobId:      blobId,
+		Scope:       c.Query("scope", ScopeDownload),
+		ExpiresAt:   time.Unix(expires, 0).UTC(),
+		Range:       c.Query("range"),
+		IP:          c.Query("ip"),
+		ContentType: c.Query("content_type"),
+	}
+	if signed.Scope != ScopeDownload && signed.Scope != ScopeUpload {
+		return nil, utils.BadRequestError("invalid value for query param scope")
+	}
+	if signed.IP != "" && net.ParseIP(signed.IP) == nil {
+		return nil, utils.BadRequestError("invalid value for query param ip")
+	}
+	if value := c.Query("max_size"); value != "" {
+		if signed.MaxSize, err = strconv.Atoi(value); err != nil {
+			return nil, utils.BadRequestError("invalid value for query param max_size")
+		}
+	}
+	signature, err := hex.DecodeString(c.Query("signature"))
+	if err != nil {
+		return nil, utils.ForbiddenError("invalid signature")
+	}
+	expected, _ := hex.DecodeString(signed.signature(me.signingKey))
+	if !hmac.Equal(signature, expected) {
+		return nil, utils.ForbiddenError("invalid signature")
+	}
+	if !time.Now().Before(signed.ExpiresAt) {
+		return nil, utils.ForbiddenError("signed url expired")
+	}
+	if signed.IP != "" && signed.IP != c.IP() {
+		return nil, utils.ForbiddenError("signed url isn't valid for this ip")
+	}
+
+	return signed, nil
+}
+
+func (me *Server) handleCreateSignedURL(c *fiber.Ctx) error {
+	if me.signingKey == "" {
+		return errSignedURLsDisabled
+	}
+
+	var (
+		bucketId = strings.TrimSpace(c.Query("bucket_id"))
+		blobId   = strings.TrimSpace(c.Query("blob_id"))
+	)
+	if bucketId == "" || hasControl(bucketId) {
+		return utils.BadRequestError("invalid value for query param bucket_id")
+	}
+	if blobId == "" || hasControl(blobId) {
+		return utils.BadRequestError("invalid value for query param blob_id")
+	}
+	seconds, err := strconv.Atoi(c.Query("expires_in"))
+	if err != nil || seconds <= 0 {
+		return utils.BadRequestError("expires_in must be a positive number of seconds")
+	}
+
+	signed := SignedURL{
+		BucketId:  bucketId,
+		BlobId:    blobId,
+		Scope:     strings.TrimSpace(c.Query("scope", ScopeDownload)),
+		ExpiresAt: time.Now().UTC().Add(time.Duration(seconds) * time.Second),
+		Range:     strings.TrimSpace(c.Query("range")),
+		IP:        strings.TrimSpace(c.Query("ip")),
+	}
+	if signed.Scope != ScopeDownload && signed.Scope != ScopeUpload {
+		return utils.BadRequestError("scope must be " + ScopeDownload + " or " + ScopeUpload)
+	}
+	if signed.IP != "" && net.ParseIP(signed.IP) == nil {
+		return utils.BadRequestError("ip must be an IP address")
+	}
+	if err := authorize(c, accessScope(signed.Scope), bucketId); err != nil {
+		return err
+	}
+	if signed.Range != "" {
+		if signed.Scope != ScopeDownload {
+			return utils.BadRequestError("range is only valid for download urls")
+		}
+		if _, err := http_range.ParseRange("bytes="+signed.Range, 1<<62); err != nil || strings.Contains(signed.Range, ",") {
+			return utils.BadRequestError("range must be a single byte range like 0-1023")
+		}
+	}
+	if value := strings.TrimSpace(c.Query("max_size")); value != "" {
+		if signed.Scope != ScopeUpload {
+			return utils.BadRequestError("max_size is only valid for upload urls")
+		}
+		maxSize, err := strconv.Atoi(value)
+		if err != nil || maxSize <= 0 {
+			return utils.BadRequestError("max_size must be a positive number of bytes")
+		}
+		signed.MaxSize = maxSize
+	}
+	if value := strings.TrimSpace(c.Query("content_type")); value != "" {
+		if signed.Scope != ScopeUpload {
+			return utils.BadRequestError("content_type is only valid for upload urls")
+		}
+		mediaType, _, err := mime.ParseMediaType(value)
+		if err != nil {
+			return utils.BadRequestError("invalid value for query param content_type")
+		}
+		signed.ContentType = mediaType
+	}
+
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, blobId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("blob not found")
+	}
+	if signed.Scope == ScopeDownload {
+		blob, err := me.metadata.getBlob(bucketId, blobId)
+		if err != nil {
+			return utils.InternalServerError(err)
+		}
+		if err := me.checkDownloadable(blob); err != nil {
+			return err
+		}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"url":       signed.String(me.signingKey),
+		"expiresAt": signed.ExpiresAt.Truncate(time.Second),
+	})
+}
+
+func (me *Server) handleDownloadWithSignedURL(c *fiber.Ctx) error {
+	signed, err := me.parseSignedURL(c)
+	if err != nil {
+		return err
+	}
+
+	if exists, err := me.metadata.checkIfBlobExists(signed.BucketId, signed.BlobId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("blob not found")
+	}
+
+	blob, err := me.metadata.getBlob(signed.BucketId, signed.BlobId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	// Both download and upload URLs may be used to check a blob, e.g. by tus
+	// clients resuming an upload.
+	if c.Method() == fiber.MethodHead {
+		return me.serveBlobHead(c, blob)
+	}
+	if signed.Scope != ScopeDownload {
+		return utils.ForbiddenError("signed url doesn't permit downloads")
+	}
+	return me.serveContent(c, blob, blobKey(blob.BucketId, blob.Id), serveOptions{allowedRange: signed.Range})
+}
+
+// handleUploadWithSignedURL appends to the blob of an upload URL, with PUT like
+// handleWriteToBlob or with PATCH like handlePatchBlob.
+func (me *Server) handleUploadWithSignedURL(c *fiber.Ctx) error {
+	signed, err := me.parseSignedURL(c)
+	if err != nil {
+		return err
+	}
+	if signed.Scope != ScopeUpload {
+		return utils.ForbiddenError("signed url doesn't permit uploads")
+	}
+
+	if exists, err := me.metadata.checkIfBlobExists(signed.BucketId, signed.BlobId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("blob not found")
+	}
+
+	var checks []blobCheck
+	if signed.MaxSize > 0 {
+		checks = append(checks, checkMaxSize(signed.MaxSize, len(c.Body())))
+	}
+	if signed.ContentType != "" {
+		checks = append(checks, checkContentType(c, signed.ContentType))
+	}
+
+	if c.Method() == fiber.MethodPatch {
+		return me.patchBlob(c, signed.BucketId, signed.BlobId, checks...)
+	}
+	return me.writeToBlob(c, signed.BucketId, signed.BlobId, checks...)
+}
@@ -0,0 +1,327 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// S3 requests are authenticated with AWS Signature Version 4, either in the
+// Authorization header or in the query of presigned URLs, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html.
+
+const (
+	sigV4Algorithm            = "AWS4-HMAC-SHA256"
+	sigV4ChunkAlgorithm       = "AWS4-HMAC-SHA256-PAYLOAD"
+	sigV4TimeFormat           = "20060102T150405Z"
+	sigV4DateFormat           = "20060102"
+	sigV4Service              = "s3"
+	sigV4Terminator           = "aws4_request"
+	sigV4MaxSkew              = 15 * time.Minute
+	sigV4MaxExpires           = 7 * 24 * time.Hour
+	headerAmzDate             = "X-Amz-Date"
+	headerAmzContentSha256    = "X-Amz-Content-Sha256"
+	headerAmzDecodedLength    = "X-Amz-Decoded-Content-Length"
+	unsignedPayload           = "UNSIGNED-PAYLOAD"
+	streamingSignedPayload    = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedTrailer  = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySha256               = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	awsChunkedContentEncoding = "aws-chunked"
+)
+
+// sigV4Request holds the signature of a request and what it was computed over.
+type sigV4Request struct {
+	accessKeyId   string
+	date          time.Time
+	scope         string // Like "20060102/us-east-1/s3/aws4_request".
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	presigned     bool
+	expires       time.Duration // Only set for presigned URLs.
+}
+
+// mwWithSigV4 is middleware that authenticates S3 requests against the
+// configured credentials. Bodies sent with aws-chunked encoding are decoded,
+// and the signatures of their chunks verified, before the handlers see them.
+func (me *Server) mwWithSigV4(c *fiber.Ctx) error {
+	req, err := parseSigV4(c, me.s3.Region)
+	if err != nil {
+		return err
+	}
+
+	secret, ok := me.s3.Credentials[req.accessKeyId]
+	if !ok {
+		return newS3Error(http.StatusForbidden, "InvalidAccessKeyId", "The AWS access key Id you provided does not exist in our records.")
+	}
+
+	now := time.Now().UTC()
+	if req.presigned {
+		if now.After(req.date.Add(req.expires)) {
+			return newS3Error(http.StatusForbidden, "AccessDenied", "Request has expired")
+		}
+		if req.date.After(now.Add(sigV4MaxSkew)) {
+			return newS3Error(http.StatusForbidden, "AccessDenied", "Request is not valid yet")
+		}
+	} else if req.date.Before(now.Add(-sigV4MaxSkew)) || req.date.After(now.Add(sigV4MaxSkew)) {
+		return newS3Error(http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the current time is too large.")
+	}
+
+	key := sigV4SigningKey(secret, req.scope)
+	if !sigV4SignatureMatches(req.signature, sigV4Sign(key, sigV4StringToSign(req, canonicalRequest(c, req)))) {
+		return newS3Error(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+	}
+
+	switch req.payloadHash {
+	case unsignedPayload:
+	case streamingSignedPayload, streamingUnsignedTrailer:
+		var signer func(prev string, chunk []byte) string
+		if req.payloadHash == streamingSignedPayload {
+			signer = func(prev string, chunk []byte) string {
+				return sigV4Sign(key, sigV4ChunkStringToSign(req, prev, chunk))
+			}
+		}
+		body, err := decodeAWSChunked(c.Body(), req.signature, signer)
+		if err != nil {
+			return err
+		}
+		if value := c.Get(headerAmzDecodedLength); value != "" && value != strconv.Itoa(len(body)) {
+			return newS3Error(http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header.")
+		}
+		c.Request().SetBody(body)
+		c.Request().Header.Del(fiber.HeaderContentEncoding)
+	default:
+		sum := sha256.Sum256(c.Body())
+		if req.payloadHash != hex.EncodeToString(sum[:]) {
+			return newS3Error(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+		}
+	}
+
+	return c.Next()
+}
+
+// parseSigV4 reads the signature of a request from its Authorization header or,
+// for presigned URLs, from its query.
+func parseSigV4(c *fiber.Ctx, region string) (*sigV4Request, error) {
+	var (
+		req        = &sigV4Request{}
+		credential string
+		date       string
+	)
+	if algorithm := c.Query("X-Amz-Algorithm"); algorithm != "" {
+		if algorithm != sigV4Algorithm {
+			return nil, newS3Error(http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Algorithm only supports \""+sigV4Algorithm+"\"")
+		}
+		seconds, err := strconv.Atoi(c.Query("X-Amz-Expires"))
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > sigV4MaxExpires {
+			return nil, newS3Error(http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Expires must be a positive number of seconds, at most a week")
+		}
+		req.presigned = true
+		req.expires = time.Duration(seconds) * time.Second
+		req.payloadHash = unsignedPayload
+		req.signature = c.Query("X-Amz-Signature")
+		req.signedHeaders = strings.Split(c.Query("X-Amz-SignedHeaders"), ";")
+		credential = c.Query("X-Amz-Credential")
+		date = c.Query("X-Amz-Date")
+	} else {
+		authorization := c.Get(fiber.HeaderAuthorization)
+		if authorization == "" {
+			return nil, newS3Error(http.StatusForbidden, "AccessDenied", "Anonymous access is not allowed")
+		}
+		fields, ok := strings.CutPrefix(authorization, sigV4Algorithm+" ")
+		if !ok {
+			return nil, newS3Error(http.StatusBadRequest, "InvalidArgument", "Only "+sigV4Algorithm+" signatures are supported")
+		}
+		for _, field := range strings.Split(fields, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				req.signedHeaders = strings.Split(value, ";")
+			case "Signature":
+				req.signature = value
+			}
+		}
+		req.payloadHash = c.Get(headerAmzContentSha256)
+		if req.payloadHash == "" {
+			return nil, newS3Error(http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256")
+		}
+		date = c.Get(headerAmzDate)
+		if date == "" {
+			// The Date header is only used if X-Amz-Date is missing.
+			if t, err := http.ParseTime(c.Get(fiber.HeaderDate)); err == nil {
+				date = t.UTC().Format(sigV4TimeFormat)
+			}
+		}
+	}
+
+	t, err := time.Parse(sigV4TimeFormat, date)
+	if err != nil {
+		return nil, newS3Error(http.StatusForbidden, "AccessDenied", "AWS authentication requires a valid Date or x-amz-date header")
+	}
+	req.date = t
+
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || req.signature == "" || len(req.signedHeaders) == 0 {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed")
+	}
+	if parts[1] != t.Format(sigV4DateFormat) || parts[2] != region || parts[3] != sigV4Service || parts[4] != sigV4Terminator {
+		return nil, newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed; the credential scope must be <date>/"+region+"/s3/aws4_request")
+	}
+	req.accessKeyId = parts[0]
+	req.scope = strings.Join(parts[1:], "/")
+
+	return req, nil
+}
+
+// canonicalRequest returns the canonical form of a request that's signed.
+func canonicalRequest(c *fiber.Ctx, req *sigV4Request) string {
+	// Clients sign the path exactly as they encoded it.
+	path := string(c.Request().URI().PathOriginal())
+	if path == "" {
+		path = "/"
+	}
+
+	var query []string
+	c.Request().URI().QueryArgs().VisitAll(func(key, value []byte) {
+		if req.presigned && string(key) == "X-Amz-Signature" {
+			return
+		}
+		query = append(query, awsURIEncode(string(key))+"="+awsURIEncode(string(value)))
+	})
+	sort.Strings(query)
+
+	var headers strings.Builder
+	for _, name := range req.signedHeaders {
+		values := c.Request().Header.PeekAll(name)
+		trimmed := make([]string, 0, len(values))
+		for _, value := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(string(value)), " "))
+		}
+		headers.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+
+	return strings.Join([]string{
+		c.Method(),
+		path,
+		strings.Join(query, "&"),
+		headers.String(),
+		strings.Join(req.signedHeaders, ";"),
+		req.payloadHash,
+	}, "\n")
+}
+
+func sigV4StringToSign(req *sigV4Request, canonicalRequest string) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		sigV4Algorithm,
+		req.date.Format(sigV4TimeFormat),
+		req.scope,
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+func sigV4ChunkStringToSign(req *sigV4Request, prevSignature string, chunk []byte) string {
+	sum := sha256.Sum256(chunk)
+	return strings.Join([]string{
+		sigV4ChunkAlgorithm,
+		req.date.Format(sigV4TimeFormat),
+		req.scope,
+		prevSignature,
+		emptySha256,
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+// sigV4SigningKey derives the key that signs requests of a credential scope.
+func sigV4SigningKey(secret, scope string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSha256(key, part)
+	}
+	return key
+}
+
+func sigV4Sign(key []byte, stringToSign string) string {
+	return hex.EncodeToString(hmacSha256(key, stringToSign))
+}
+
+func sigV4SignatureMatches(got, expected string) bool {
+	return hmac.Equal([]byte(strings.ToLower(got)), []byte(expected))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes everything but the unreserved characters of
+// RFC 3986, as SigV4 expects.
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' || ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			b.WriteByte(ch)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{ch})))
+		}
+	}
+	return b.String()
+}
+
+// decodeAWSChunked decodes a body sent with aws-chunked content encoding, made
+// of chunks like "<hex size>[;chunk-signature=<signature>]\r\n<data>\r\n" and
+// ended by an empty chunk, optionally followed by trailing headers. If signer
+// isn't nil, the signature of every chunk is verified, chaining from seed.
+func decodeAWSChunked(body []byte, seed string, signer func(prev string, chunk []byte) string) ([]byte, error) {
+	var (
+		decoded   = make([]byte, 0, len(body))
+		signature = seed
+		malformed = newS3Error(http.StatusBadRequest, "IncompleteBody", "The aws-chunked body is malformed")
+	)
+	for {
+		line, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			return nil, malformed
+		}
+		sizeHex, extension, _ := strings.Cut(string(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size < 0 || size > int64(len(rest)) {
+			return nil, malformed
+		}
+		chunk := rest[:size]
+
+		if signer != nil {
+			got, ok := strings.CutPrefix(extension, "chunk-signature=")
+			if !ok {
+				return nil, malformed
+			}
+			expected := signer(signature, chunk)
+			if !sigV4SignatureMatches(got, expected) {
+				return nil, newS3Error(http.StatusForbidden, "SignatureDoesNotMatch", "The chunk signature we calculated does not match the signature you provided.")
+			}
+			signature = expected
+		}
+
+		if size == 0 {
+			// Whatever follows are trailing headers, like checksums, which aren't verified.
+			return decoded, nil
+		}
+		decoded = append(decoded, chunk...)
+		body, ok = bytes.CutPrefix(rest[size:], []byte("\r\n"))
+		if !ok {
+			return nil, malformed
+		}
+	}
+}
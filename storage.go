@@ -0,0 +1,497 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/oklog/ulid/v2"
+)
+
+// ErrInvalidKey is returned by a Storage when a key is empty or would escape
+// the storage namespace (e.g. "../x").
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// ErrKeyConflict is returned by a Storage when an object would be created at a
+// key that other objects are under, or under the key of another object (e.g.
+// "a/b" when "a" exists).
+var ErrKeyConflict = errors.New("storage key conflicts with another object")
+
+// Storage is the backend that holds blob contents. Keys are slash separated
+// paths such as "bucket_id/blob_id", like files in directories: a key can't be
+// both an object and the parent of others. Missing keys are reported with
+// errors matching fs.ErrNotExist.
+type Storage interface {
+	// OpenAppend opens the object at key for appending, creating it if needed.
+	OpenAppend(key string) (io.WriteCloser, error)
+	// OpenReader opens the object at key for random access reads.
+	OpenReader(key string) (ReadAtCloser, error)
+	// WriteAt writes data into the existing object at key at offset, growing
+	// it if data goes past its end.
+	WriteAt(key string, data []byte, offset int64) error
+	// Truncate sets the size of the existing object at key, cutting it or
+	// padding it with zeros.
+	Truncate(key string, size int64) error
+	// Stat returns the size of the object at key.
+	Stat(key string) (int64, error)
+	// Rename moves the object at oldKey to newKey, replacing any object there.
+	Rename(oldKey, newKey string) error
+	// Delete removes the object at key. Deleting a missing key is not an error.
+	Delete(key string) error
+	// DeletePrefix removes every object whose key starts with prefix.
+	// The prefix is expected to end with "/".
+	DeletePrefix(prefix string) error
+}
+
+// ReadAtCloser is the reader returned by Storage.OpenReader.
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// sectionReadCloser reads a section of a ReadAtCloser and closes it when done.
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// newSectionReadCloser returns a reader of n bytes of r starting at off.
+// Closing it closes r.
+func newSectionReadCloser(r ReadAtCloser, off, n int64) io.ReadCloser {
+	return &sectionReadCloser{SectionReader: io.NewSectionReader(r, off, n), Closer: r}
+}
+
+// blobKey returns the storage key of a blob.
+func blobKey(bucketId, blobId string) string {
+	return bucketId + "/" + blobId
+}
+
+// bucketPrefix returns the storage key prefix of all blobs in a bucket.
+func bucketPrefix(bucketId string) string {
+	return bucketId + "/"
+}
+
+// uploadsPrefix is the storage key prefix reserved for multipart upload parts.
+// Bucket ids may not start with "." so it never collides with a bucket.
+const uploadsPrefix = ".uploads/"
+
+// uploadPrefix returns the storage key prefix of all parts of an upload.
+func uploadPrefix(uploadId string) string {
+	return uploadsPrefix + uploadId + "/"
+}
+
+// uploadPartKey returns a fresh storage key for a part of an upload. Every
+// upload of a part gets its own key, so re-uploading a part never changes data
+// that is being assembled.
+func uploadPartKey(uploadId string, number int) string {
+	return fmt.Sprintf("%s%d-%s", uploadPrefix(uploadId), number, ulid.Make())
+}
+
+// versionsPrefix is the storage key prefix reserved for the content of older
+// versions of blobs in versioned buckets.
+const versionsPrefix = ".versions/"
+
+// versionsBucketPrefix returns the storage key prefix of all older versions of
+// blobs in a bucket.
+func versionsBucketPrefix(bucketId string) string {
+	return versionsPrefix + bucketId + "/"
+}
+
+// versionKey returns the storage key of an older version of a blob. Version ids
+// are unique, so the blob id isn't part of it.
+func versionKey(bucketId, versionId string) string {
+	return versionsBucketPrefix(bucketId) + versionId
+}
+
+// trashPrefix is the storage key prefix reserved for the content of deleted
+// buckets and blobs, see trashKey.
+const trashPrefix = ".trash/"
+
+// trashItemPrefix returns the storage key prefix of all content of a trashed
+// item.
+func trashItemPrefix(trashId string) string {
+	return trashPrefix + trashId + "/"
+}
+
+// trashKey returns the storage key that the object at key is moved to when it's
+// trashed.
+func trashKey(trashId, key string) string {
+	return trashItemPrefix(trashId) + key
+}
+
+// tmpPrefix is the storage key prefix reserved for content that is written
+// aside before it's renamed into place.
+const tmpPrefix = ".tmp/"
+
+// tmpKey returns a fresh temporary storage key.
+func tmpKey() string {
+	return tmpPrefix + ulid.Make().String()
+}
+
+// storageError converts an error returned by a Storage into an API error.
+func storageError(err error) error {
+	if errors.Is(err, ErrInvalidKey) {
+		return utils.BadRequestError("invalid bucket or blob id")
+	}
+	if errors.Is(err, ErrKeyConflict) {
+		return utils.ConflictError("blob id conflicts with another blob, e.g. a/b with a")
+	}
+	return utils.InternalServerError(err)
+}
+
+// validKey reports whether key is a clean relative slash separated path, with
+// no empty segments nor a trailing slash, and no control characters.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || hasControl(key) {
+		return false
+	}
+	return key != ".." && !strings.HasPrefix(key, "../")
+}
+
+// validPrefix reports whether prefix is a valid key followed by a slash.
+func validPrefix(prefix string) bool {
+	key, ok := strings.CutSuffix(prefix, "/")
+	return ok && validKey(key)
+}
+
+// localStorage stores every object as a file under a root directory.
+type localStorage struct {
+	rootDir string
+}
+
+// NewLocalStorage returns a Storage that keeps objects as files under rootDir.
+func NewLocalStorage(rootDir string) Storage {
+	return &localStorage{rootDir: rootDir}
+}
+
+func (me *localStorage) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(me.rootDir, filepath.FromSlash(key)), nil
+}
+
+// makeRoom prepares path for a new file: it creates the missing parent
+// directories and removes an empty directory left at path by deleted objects
+// under it.
+func makeRoom(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		if errors.Is(err, syscall.ENOTDIR) {
+			return ErrKeyConflict
+		}
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return ErrKeyConflict
+		}
+	}
+	return nil
+}
+
+// isDir reports whether path is a directory, which holds objects but isn't
+// one.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func (me *localStorage) OpenAppend(key string) (io.WriteCloser, error) {
+	path, err := me.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := makeRoom(path); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.ModePerm)
+}
+
+func (me *localStorage) OpenReader(key string) (ReadAtCloser, error) {
+	path, err := me.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if isDir(path) {
+		return nil, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+	return os.Open(path)
+}
+
+func (me *localStorage) WriteAt(key string, data []byte, offset int64) error {
+	path, err := me.path(key)
+	if err != nil {
+		return err
+	}
+	if isDir(path) {
+		return &fs.PathError{Op: "write", Path: key, Err: fs.ErrNotExist}
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, offset); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (me *localStorage) Truncate(key string, size int64) error {
+	path, err := me.path(key)
+	if err != nil {
+		return err
+	}
+	if isDir(path) {
+		return &fs.PathError{Op: "truncate", Path: key, Err: fs.ErrNotExist}
+	}
+	return os.Truncate(path, size)
+}
+
+func (me *localStorage) Stat(key string) (int64, error) {
+	path, err := me.path(key)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir() {
+		return 0, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	return info.Size(), nil
+}
+
+func (me *localStorage) Rename(oldKey, newKey string) error {
+	oldPath, err := me.path(oldKey)
+	if err != nil {
+		return err
+	}
+	newPath, err := me.path(newKey)
+	if err != nil {
+		return err
+	}
+	if isDir(oldPath) {
+		return &fs.PathError{Op: "rename", Path: oldKey, Err: fs.ErrNotExist}
+	}
+	if err := makeRoom(newPath); err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (me *localStorage) Delete(key string) error {
+	path, err := me.path(key)
+	if err != nil {
+		return err
+	}
+	if isDir(path) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (me *localStorage) DeletePrefix(prefix string) error {
+	if !validPrefix(prefix) {
+		return ErrInvalidKey
+	}
+	path, err := me.path(strings.TrimSuffix(prefix, "/"))
+	if err != nil {
+		return err
+	}
+	// Only a directory holds objects under prefix.
+	if !isDir(path) {
+		return nil
+	}
+	return os.RemoveAll(path)
+}
+
+// memoryStorage keeps every object in memory. It is meant for tests.
+type memoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+// NewMemoryStorage returns a Storage that keeps objects in memory.
+func NewMemoryStorage() Storage {
+	return &memoryStorage{objects: map[string][]byte{}}
+}
+
+func (me *memoryStorage) OpenAppend(key string) (io.WriteCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if _, ok := me.objects[key]; !ok {
+		if me.conflicts(key) {
+			return nil, ErrKeyConflict
+		}
+		me.objects[key] = []byte{}
+	}
+	return &memoryAppender{storage: me, key: key}, nil
+}
+
+// conflicts reports whether a new object can't be created at key because a
+// parent of it is an object or other objects are under it, like files and
+// directories of a localStorage. me.mu must be held.
+func (me *memoryStorage) conflicts(key string) bool {
+	for parent := path.Dir(key); parent != "."; parent = path.Dir(parent) {
+		if _, ok := me.objects[parent]; ok {
+			return true
+		}
+	}
+	for other := range me.objects {
+		if strings.HasPrefix(other, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (me *memoryStorage) OpenReader(key string) (ReadAtCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	data, ok := me.objects[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+	// The slice header is captured, so later appends are not visible to the reader.
+	return memoryReader{bytes.NewReader(data)}, nil
+}
+
+func (me *memoryStorage) WriteAt(key string, data []byte, offset int64) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	old, ok := me.objects[key]
+	if !ok {
+		return &fs.PathError{Op: "write", Path: key, Err: fs.ErrNotExist}
+	}
+	// Objects are copied on write, so open readers keep seeing the old data.
+	updated := make([]byte, max(int64(len(old)), offset+int64(len(data))))
+	copy(updated, old)
+	copy(updated[offset:], data)
+	me.objects[key] = updated
+	return nil
+}
+
+func (me *memoryStorage) Truncate(key string, size int64) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	data, ok := me.objects[key]
+	if !ok {
+		return &fs.PathError{Op: "truncate", Path: key, Err: fs.ErrNotExist}
+	}
+	if size > int64(len(data)) {
+		data = append(data, make([]byte, size-int64(len(data)))...)
+	}
+	// Capping the capacity makes later appends copy, instead of overwriting
+	// data that open readers still see.
+	me.objects[key] = data[:size:size]
+	return nil
+}
+
+func (me *memoryStorage) Stat(key string) (int64, error) {
+	if !validKey(key) {
+		return 0, ErrInvalidKey
+	}
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+	data, ok := me.objects[key]
+	if !ok {
+		return 0, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	return int64(len(data)), nil
+}
+
+func (me *memoryStorage) Rename(oldKey, newKey string) error {
+	if !validKey(oldKey) || !validKey(newKey) {
+		return ErrInvalidKey
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	data, ok := me.objects[oldKey]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldKey, Err: fs.ErrNotExist}
+	}
+	if _, ok := me.objects[newKey]; !ok && me.conflicts(newKey) {
+		return ErrKeyConflict
+	}
+	delete(me.objects, oldKey)
+	me.objects[newKey] = data
+	return nil
+}
+
+func (me *memoryStorage) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	delete(me.objects, key)
+	return nil
+}
+
+func (me *memoryStorage) DeletePrefix(prefix string) error {
+	if !validPrefix(prefix) {
+		return ErrInvalidKey
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for key := range me.objects {
+		if strings.HasPrefix(key, prefix) {
+			delete(me.objects, key)
+		}
+	}
+	return nil
+}
+
+type memoryAppender struct {
+	storage *memoryStorage
+	key     string
+}
+
+func (me *memoryAppender) Write(p []byte) (int, error) {
+	me.storage.mu.Lock()
+	defer me.storage.mu.Unlock()
+	data, ok := me.storage.objects[me.key]
+	if !ok {
+		return 0, &fs.PathError{Op: "write", Path: me.key, Err: fs.ErrNotExist}
+	}
+	me.storage.objects[me.key] = append(data, p...)
+	return len(p), nil
+}
+
+func (me *memoryAppender) Close() error {
+	return nil
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (me memoryReader) Close() error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+)
+
+func TestAPI(t *testing.T) {
+	s := blobtest.NewServer(t, blob.ServerConfig{Storage: blob.NewMemoryStorage()})
+	ctx := context.Background()
+	if _, err := s.Client.CreateBucket(ctx, "bucket1", blob.PolicyPublicRead); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Client.CreateBlob(ctx, "bucket1", "blob1", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Client.WriteChunk(ctx, "bucket1", "blob1", 0, []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := httptest.NewServer(s.Handler())
+	defer handler.Close()
+
+	// Every request is sent over the listener, through Handler and through Test.
+	transports := map[string]func(req *http.Request) (*http.Response, error){
+		"listener": func(req *http.Request) (*http.Response, error) {
+			req.URL.Host = strings.TrimPrefix(s.URL, "http://")
+			return http.DefaultClient.Do(req)
+		},
+		"handler": func(req *http.Request) (*http.Response, error) {
+			req.URL.Host = strings.TrimPrefix(handler.URL, "http://")
+			return http.DefaultClient.Do(req)
+		},
+		"test": s.Test,
+	}
+	for _, request := range []struct {
+		method   string
+		path     string
+		secret   bool
+		expected int
+		response string
+	}{
+		{http.MethodGet, "/info", false, http.StatusOK, `{"maxChunkSize":1048576}`},
+		{http.MethodGet, "/buckets", false, http.StatusUnauthorized, ""},
+		{http.MethodGet, "/buckets/bucket1", true, http.StatusOK, ""},
+		{http.MethodGet, "/buckets/missing", true, http.StatusNotFound, ""},
+		{http.MethodPost, "/buckets?bucket_id=bucket1", true, http.StatusConflict, ""},
+		{http.MethodGet, "/public/bucket1/blob1", false, http.StatusOK, "hello world"},
+		{http.MethodGet, "/public/bucket1/missing", false, http.StatusNotFound, ""},
+		{http.MethodDelete, "/buckets/bucket1/blobs/missing", true, http.StatusNotFound, ""},
+	} {
+		for name, send := range transports {
+			req, err := http.NewRequest(request.method, "http://blob"+request.path, http.NoBody)
+			if err != nil {
+				t.Fatal("error creating request: ", err)
+			}
+			if request.secret {
+				req.Header.Set("Secret-Key", blobtest.SecretKey)
+			}
+			resp, err := send(req)
+			if err != nil {
+				t.Fatalf("error sending %s %s through %s: %v", request.method, request.path, name, err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal("error reading response: ", err)
+			}
+			if resp.StatusCode != request.expected {
+				t.Fatalf("expected %d status code for %s %s through %s, got %d: %s", request.expected, request.method, request.path, name, resp.StatusCode, body)
+			}
+			if request.response != "" && string(body) != request.response {
+				t.Fatalf("expected %q for %s %s through %s, got %q", request.response, request.method, request.path, name, body)
+			}
+		}
+	}
+}
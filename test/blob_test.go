@@ -1,16 +1,28 @@
 package blob
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"runtime"
 	"testing"
 	"time"
-    "github.com/assaidy/blob"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
 )
 
 func createSmallFile() {
-	file, err := os.OpenFile("small_file", os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.ModePerm)
+	file, err := os.OpenFile("small_file", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
 	if err != nil {
 		panic(err)
 	}
@@ -25,7 +37,7 @@ func createSmallFile() {
 }
 
 func createBigFile() {
-	file, err := os.OpenFile("big_file", os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	file, err := os.OpenFile("big_file", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
 	if err != nil {
 		panic(err)
 	}
@@ -39,27 +51,25 @@ func createBigFile() {
 	}
 }
 
+// serve starts a server on a port picked by the system and returns its URL.
+// The server is shut down when the test ends.
+func serve(t *testing.T, config blob.ServerConfig) string {
+	t.Helper()
+	return blobtest.NewServer(t, config).URL
+}
+
 func TestBlobServer(t *testing.T) {
 	t.Log("creating small/big files...")
 	createSmallFile()
 	createBigFile()
 
-	go func() {
-		t.Log("starting blob server...")
-		s := blob.NewServer(blob.ServerConfig{
-			MaxChunkSize: 1 * blob.MB,
-			SecretKey:    "1234",
-			RootDir:      "./root_dir",
-			MetadataDir:  "./metadata_dir",
-		})
-		if err := s.Listen(":3000"); err != nil {
-			panic(err)
-		}
-	}()
-
-	time.Sleep(2 * time.Second)
-
-	serverURL := "http://localhost:3000"
+	t.Log("starting blob server...")
+	serverURL := serve(t, blob.ServerConfig{
+		MaxChunkSize: 1 * blob.MB,
+		SecretKey:    "1234",
+		RootDir:      "./root_dir",
+		MetadataDir:  "./metadata_dir",
+	})
 
 	// ========================================================
 
@@ -122,6 +132,471 @@ func TestBlobServer(t *testing.T) {
 
 	// ========================================================
 
+	t.Log("retrying a small_blob chunk at a stale offset...")
+	req, err = http.NewRequest(http.MethodPut, serverURL+"/buckets/bucket1/blobs/small_blob", bytes.NewReader([]byte("stale")))
+	if err != nil {
+		t.Fatal("error creating upload request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	req.Header.Set("Upload-Offset", "0")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending upload request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 status code for stale offset, got %d", resp.StatusCode)
+	}
+	if offset := resp.Header.Get("Upload-Offset"); offset != "32000" {
+		t.Fatalf("expected upload offset 32000, got %q", offset)
+	}
+
+	t.Log("sending a small_blob chunk with a wrong Content-MD5...")
+	req, err = http.NewRequest(http.MethodPut, serverURL+"/buckets/bucket1/blobs/small_blob", bytes.NewReader([]byte("corrupted")))
+	if err != nil {
+		t.Fatal("error creating upload request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	req.Header.Set("Content-MD5", "1B2M2Y8AsgTpgAmY7PhCfg==")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending upload request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code for wrong Content-MD5, got %d", resp.StatusCode)
+	}
+
+	t.Log("resuming small_blob with tus...")
+	req, err = http.NewRequest(http.MethodHead, serverURL+"/buckets/bucket1/blobs/small_blob", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating head request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending head request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 status code for head, got %d", resp.StatusCode)
+	}
+	offset := resp.Header.Get("Upload-Offset")
+	req, err = http.NewRequest(http.MethodPatch, serverURL+"/buckets/bucket1/blobs/small_blob", bytes.NewReader([]byte("This is a line.\n")))
+	if err != nil {
+		t.Fatal("error creating patch request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", offset)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending patch request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 status code for patch, got %d", resp.StatusCode)
+	}
+	if offset := resp.Header.Get("Upload-Offset"); offset != "32016" {
+		t.Fatalf("expected upload offset 32016, got %q", offset)
+	}
+
+	// ========================================================
+
+	t.Log("downloading small_blob with a single use access...")
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/access?bucket_id=bucket1&blob_id=small_blob&max_downloads=1&expires_in=60", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 status code for access creation, got %d", resp.StatusCode)
+	}
+	var singleUse blob.Access
+	if err := json.NewDecoder(resp.Body).Decode(&singleUse); err != nil {
+		t.Fatal("error decoding access: ", err)
+	}
+	resp.Body.Close()
+	if singleUse.ExpiresAt == nil || singleUse.RemainingDownloads == nil || *singleUse.RemainingDownloads != 1 {
+		t.Fatalf("expected an expiring single use access, got %+v", singleUse)
+	}
+	// Any response with content uses up the download, ranges not starting at 0
+	// included.
+	for _, download := range []struct {
+		header   string
+		value    string
+		expected int
+	}{
+		{"If-None-Match", "*", http.StatusNotModified},
+		{"If-Match", `"outdated"`, http.StatusPreconditionFailed},
+		{"Range", "bytes=5-9", http.StatusPartialContent},
+		{"Range", "bytes=1-", http.StatusGone},
+		{"Range", "bytes=5-9", http.StatusGone},
+		{"Range", "bytes=0-4", http.StatusGone},
+		{"", "", http.StatusGone},
+	} {
+		req, err = http.NewRequest(http.MethodGet, serverURL+"/access/"+singleUse.Key, http.NoBody)
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		if download.header != "" {
+			req.Header.Set(download.header, download.value)
+		}
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending download request: ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != download.expected {
+			t.Fatalf("expected %d status code for download with %s %s, got %d", download.expected, download.header, download.value, resp.StatusCode)
+		}
+	}
+
+	// ========================================================
+
+	t.Log("downloading small_blob with a signed url...")
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/signed-urls?bucket_id=bucket1&blob_id=small_blob&expires_in=60&range=0-4", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 status code for signed url creation, got %d", resp.StatusCode)
+	}
+	var signed struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		t.Fatal("error decoding signed url: ", err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(serverURL + signed.URL)
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal("error reading download: ", err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "This " {
+		t.Fatalf("expected 206 status code and the signed range, got %d and %q", resp.StatusCode, body)
+	}
+	req, err = http.NewRequest(http.MethodGet, serverURL+signed.URL, http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Range", "bytes=0-10")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 status code for a range outside the signed range, got %d", resp.StatusCode)
+	}
+	for _, ifRange := range []string{`"bogus"`, "Mon, 02 Jan 2006 15:04:05 GMT"} {
+		req, err = http.NewRequest(http.MethodGet, serverURL+signed.URL, http.NoBody)
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Range", "bytes=0-2")
+		req.Header.Set("If-Range", ifRange)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending download request: ", err)
+		}
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal("error reading download: ", err)
+		}
+		if resp.StatusCode != http.StatusPartialContent || string(body) != "This " {
+			t.Fatalf("expected 206 status code and only the signed range for a mismatched If-Range, got %d and %q", resp.StatusCode, body)
+		}
+	}
+	expired := blob.SignedURL{BucketId: "bucket1", BlobId: "small_blob", ExpiresAt: time.Now().Add(-time.Minute)}
+	for _, url := range []string{expired.String("1234"), signed.URL + "0"} {
+		resp, err = http.Get(serverURL + url)
+		if err != nil {
+			t.Fatal("error sending download request: ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected 403 status code for an expired or forged signed url, got %d", resp.StatusCode)
+		}
+	}
+	// Ids can't be moved across the fields of a signature.
+	expiresAt := time.Now().Add(time.Minute)
+	shifted := blob.SignedURL{BucketId: "bucket1", BlobId: "x\nsmall_blob", ExpiresAt: expiresAt}
+	moved := blob.SignedURL{BucketId: "bucket1\nx", BlobId: "small_blob", ExpiresAt: expiresAt}
+	if shifted.Query("1234").Get("signature") == moved.Query("1234").Get("signature") {
+		t.Fatal("expected ids split differently to be signed differently")
+	}
+	resp, err = http.Get(serverURL + moved.Path() + "?" + shifted.Query("1234").Encode())
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 status code for ids with control characters, got %d", resp.StatusCode)
+	}
+	for _, query := range []string{"bucket_id=bucket1&blob_id=a%0Ab", "bucket_id=bucket1&blob_id=small_blob&ip=nowhere"} {
+		req, err = http.NewRequest(http.MethodPost, serverURL+"/signed-urls?expires_in=60&"+query, http.NoBody)
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Secret-Key", "1234")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending request: ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected 400 status code for signing %s, got %d", query, resp.StatusCode)
+		}
+	}
+
+	t.Log("refusing signed urls without a signing key...")
+	unsigned := blob.NewServer(blob.ServerConfig{MaxChunkSize: 1 * blob.MB, MetadataDir: t.TempDir(), Storage: blob.NewMemoryStorage()})
+	defer unsigned.Close()
+	forged := blob.SignedURL{BucketId: "bucket1", BlobId: "small_blob", ExpiresAt: time.Now().Add(time.Minute)}
+	resp, err = unsigned.Test(httptest.NewRequest(http.MethodGet, forged.String(""), http.NoBody))
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 status code for a url signed with an empty key, got %d", resp.StatusCode)
+	}
+
+	// ========================================================
+
+	t.Log("uploading uploaded_blob with an upload access and a signed upload url...")
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/buckets/bucket1/blobs?blob_id=uploaded_blob&content_type=text/plain", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 status code for creation, got %d", resp.StatusCode)
+	}
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/access?bucket_id=bucket1&blob_id=uploaded_blob&scope=upload&max_size=10&content_type=text/plain", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 status code for access creation, got %d", resp.StatusCode)
+	}
+	var uploadAccess blob.Access
+	if err := json.NewDecoder(resp.Body).Decode(&uploadAccess); err != nil {
+		t.Fatal("error decoding access: ", err)
+	}
+	resp.Body.Close()
+	for _, upload := range []struct {
+		contentType string
+		body        string
+		expected    int
+	}{
+		{"text/plain", "hello", http.StatusOK},
+		{"application/json", "{}", http.StatusUnsupportedMediaType},
+		{"text/plain; charset=utf-8", " world", http.StatusRequestEntityTooLarge},
+	} {
+		req, err = http.NewRequest(http.MethodPut, serverURL+"/access/"+uploadAccess.Key, bytes.NewBufferString(upload.body))
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Content-Type", upload.contentType)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending upload request: ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != upload.expected {
+			t.Fatalf("expected %d status code for upload of %q, got %d", upload.expected, upload.body, resp.StatusCode)
+		}
+	}
+	resp, err = http.Get(serverURL + "/access/" + uploadAccess.Key)
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 status code for download with an upload access, got %d", resp.StatusCode)
+	}
+	uploadURL := blob.SignedURL{BucketId: "bucket1", BlobId: "uploaded_blob", Scope: blob.ScopeUpload, ExpiresAt: time.Now().Add(time.Minute)}
+	req, err = http.NewRequest(http.MethodPut, serverURL+uploadURL.String("1234"), bytes.NewBufferString(" world"))
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Upload-Offset", "5")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending upload request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Upload-Offset") != "11" {
+		t.Fatalf("expected 200 status code and upload offset 11, got %d and %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+
+	// ========================================================
+
+	t.Log("using a read-only api key...")
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/keys", bytes.NewBufferString(`{"name":"reader","scopes":["read"],"buckets":["bucket1"]}`))
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 status code for api key creation, got %d", resp.StatusCode)
+	}
+	var reader blob.APIKey
+	if err := json.NewDecoder(resp.Body).Decode(&reader); err != nil {
+		t.Fatal("error decoding api key: ", err)
+	}
+	resp.Body.Close()
+	for _, request := range []struct {
+		method   string
+		path     string
+		expected int
+	}{
+		{http.MethodGet, "/buckets/bucket1/blobs/small_blob", http.StatusOK},
+		{http.MethodDelete, "/buckets/bucket1/blobs/small_blob", http.StatusForbidden},
+		{http.MethodGet, "/buckets/bucket2", http.StatusForbidden},
+		{http.MethodGet, "/keys", http.StatusForbidden},
+	} {
+		req, err = http.NewRequest(request.method, serverURL+request.path, http.NoBody)
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+reader.Token)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending request: ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != request.expected {
+			t.Fatalf("expected %d status code for %s %s with a read-only api key, got %d", request.expected, request.method, request.path, resp.StatusCode)
+		}
+	}
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/keys/"+reader.Id+"/rotate", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 status code for api key rotation, got %d", resp.StatusCode)
+	}
+	var rotated blob.APIKey
+	if err := json.NewDecoder(resp.Body).Decode(&rotated); err != nil {
+		t.Fatal("error decoding api key: ", err)
+	}
+	resp.Body.Close()
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/keys/"+reader.Id+"/disable", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 status code for disabling an api key, got %d", resp.StatusCode)
+	}
+	for _, token := range []string{reader.Token, rotated.Token} {
+		req, err = http.NewRequest(http.MethodGet, serverURL+"/buckets/bucket1", http.NoBody)
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending request: ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401 status code for a rotated or disabled api key, got %d", resp.StatusCode)
+		}
+	}
+
+	// ========================================================
+
+	t.Log("downloading small_blob from a public-read bucket...")
+	resp, err = http.Get(serverURL + "/public/bucket1/small_blob")
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 status code for a private bucket, got %d", resp.StatusCode)
+	}
+	req, err = http.NewRequest(http.MethodPut, serverURL+"/buckets/bucket1/policy?policy=public-read", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 status code for setting the bucket policy, got %d", resp.StatusCode)
+	}
+	resp, err = http.Get(serverURL + "/public/bucket1/small_blob")
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal("error reading download: ", err)
+	}
+	if resp.StatusCode != http.StatusOK || !bytes.HasPrefix(body, []byte("This is a")) {
+		t.Fatalf("expected 200 status code and small_blob's content, got %d", resp.StatusCode)
+	}
+	resp, err = http.Get(serverURL + "/public/bucket1")
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 status code for listing a public-read bucket, got %d", resp.StatusCode)
+	}
+
+	// ========================================================
+
 	t.Log("creating big_blob...")
 	req, err = http.NewRequest(http.MethodPost, serverURL+"/buckets/bucket1/blobs?blob_id=big_blob", http.NoBody)
 	if err != nil {
@@ -138,6 +613,341 @@ func TestBlobServer(t *testing.T) {
 
 	// ========================================================
 
+	t.Log("uploading big_blob in chunks...")
+	bigFile, err := os.Open("big_file")
+	if err != nil {
+		t.Fatal("failed to open big file: ", err)
+	}
+	defer bigFile.Close()
+	bigFileInfo, err := bigFile.Stat()
+	if err != nil {
+		t.Fatal("failed to stat big file: ", err)
+	}
+	chunk := make([]byte, blob.MB)
+	for {
+		n, err := io.ReadFull(bigFile, chunk)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatal("failed to read big file: ", err)
+		}
+		req, err = http.NewRequest(http.MethodPut, serverURL+"/buckets/bucket1/blobs/big_blob", bytes.NewReader(chunk[:n]))
+		if err != nil {
+			t.Fatal("error creating upload request: ", err)
+		}
+		req.Header.Set("Secret-Key", "1234")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending upload request: ", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 status code for upload, got %d", resp.StatusCode)
+		}
+	}
+
+	// ========================================================
+
+	t.Log("creating access for big_blob...")
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/access?bucket_id=bucket1&blob_id=big_blob", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 status code for access creation, got %d", resp.StatusCode)
+	}
+	var access blob.Access
+	if err := json.NewDecoder(resp.Body).Decode(&access); err != nil {
+		t.Fatal("error decoding access: ", err)
+	}
+	resp.Body.Close()
+
+	// ========================================================
+
+	t.Log("downloading big_blob under a memory ceiling...")
+	runtime.GC()
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	baseline := memStats.HeapAlloc
+	peak := baseline
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+				var m runtime.MemStats
+				runtime.ReadMemStats(&m)
+				peak = max(peak, m.HeapAlloc)
+			}
+		}
+	}()
+	resp, err = http.Get(serverURL + "/access/" + access.Key)
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 status code for download, got %d", resp.StatusCode)
+	}
+	if resp.ContentLength != bigFileInfo.Size() {
+		t.Fatalf("expected content length %d, got %d", bigFileInfo.Size(), resp.ContentLength)
+	}
+	downloaded, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	close(done)
+	<-sampled
+	if err != nil {
+		t.Fatal("error reading download: ", err)
+	}
+	if downloaded != bigFileInfo.Size() {
+		t.Fatalf("expected %d bytes, got %d", bigFileInfo.Size(), downloaded)
+	}
+	if growth := int64(peak) - int64(baseline); growth > bigFileInfo.Size()/2 {
+		t.Fatalf("download grew the heap by %d bytes for a %d bytes blob", growth, bigFileInfo.Size())
+	}
+	bigFileHash := sha256.New()
+	if _, err := io.Copy(bigFileHash, io.NewSectionReader(bigFile, 0, bigFileInfo.Size())); err != nil {
+		t.Fatal("failed to hash big file: ", err)
+	}
+	bigFileSha256 := hex.EncodeToString(bigFileHash.Sum(nil))
+	if etag := resp.Header.Get("ETag"); etag != `"`+bigFileSha256+`"` {
+		t.Fatalf("expected ETag %q, got %q", bigFileSha256, etag)
+	}
+
+	t.Log("sending HEAD for big_blob...")
+	resp, err = http.Head(serverURL + "/access/" + access.Key)
+	if err != nil {
+		t.Fatal("error sending head request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 status code for head, got %d", resp.StatusCode)
+	}
+	if resp.ContentLength != bigFileInfo.Size() {
+		t.Fatalf("expected content length %d, got %d", bigFileInfo.Size(), resp.ContentLength)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" || resp.Header.Get("Last-Modified") == "" {
+		t.Fatalf("expected Accept-Ranges and Last-Modified headers, got %v", resp.Header)
+	}
+	if offset := resp.Header.Get("Upload-Offset"); offset != fmt.Sprint(bigFileInfo.Size()) {
+		t.Fatalf("expected upload offset %d, got %q", bigFileInfo.Size(), offset)
+	}
+
+	t.Log("revalidating big_blob with If-None-Match...")
+	req, err = http.NewRequest(http.MethodGet, serverURL+"/access/"+access.Key, http.NoBody)
+	if err != nil {
+		t.Fatal("error creating download request: ", err)
+	}
+	req.Header.Set("If-None-Match", `"`+bigFileSha256+`"`)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 status code for fresh ETag, got %d", resp.StatusCode)
+	}
+
+	t.Log("downloading several ranges of big_blob...")
+	req, err = http.NewRequest(http.MethodGet, serverURL+"/access/"+access.Key, http.NoBody)
+	if err != nil {
+		t.Fatal("error creating download request: ", err)
+	}
+	req.Header.Set("Range", "bytes=0-4,21-25")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending download request: ", err)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected 206 status code for ranges, got %d", resp.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("expected multipart/byteranges, got %q", resp.Header.Get("Content-Type"))
+	}
+	ranges := multipart.NewReader(resp.Body, params["boundary"])
+	for _, expected := range []string{"This ", "is a "} {
+		part, err := ranges.NextPart()
+		if err != nil {
+			t.Fatal("error reading range part: ", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal("error reading range part: ", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("expected range %q, got %q", expected, data)
+		}
+	}
+	if _, err := ranges.NextPart(); !errors.Is(err, io.EOF) {
+		t.Fatal("expected exactly two range parts, got ", err)
+	}
+	resp.Body.Close()
+
+	t.Log("appending to big_blob with a stale If-Match...")
+	req, err = http.NewRequest(http.MethodPut, serverURL+"/buckets/bucket1/blobs/big_blob", bytes.NewReader([]byte("stale")))
+	if err != nil {
+		t.Fatal("error creating upload request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	req.Header.Set("If-Match", `"stale"`)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending upload request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 status code for stale If-Match, got %d", resp.StatusCode)
+	}
+
+	// ========================================================
+
+	t.Log("uploading big_blob_copy with parallel multipart parts...")
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/buckets/bucket1/blobs?blob_id=big_blob_copy", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 status code for creation, got %d", resp.StatusCode)
+	}
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/buckets/bucket1/blobs/big_blob_copy/uploads", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 status code for upload creation, got %d", resp.StatusCode)
+	}
+	var upload blob.Upload
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		t.Fatal("error decoding upload: ", err)
+	}
+	resp.Body.Close()
+
+	partSize := int64(blob.MB)
+	partCount := int((bigFileInfo.Size() + partSize - 1) / partSize)
+	partErrs := make(chan error, partCount)
+	for i := 0; i < partCount; i++ {
+		go func(number int) {
+			section := io.NewSectionReader(bigFile, int64(number-1)*partSize, partSize)
+			url := fmt.Sprintf("%s/buckets/bucket1/blobs/big_blob_copy/uploads/%s/parts/%d", serverURL, upload.Id, number)
+			req, err := http.NewRequest(http.MethodPut, url, section)
+			if err != nil {
+				partErrs <- err
+				return
+			}
+			req.Header.Set("Secret-Key", "1234")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				partErrs <- err
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				partErrs <- fmt.Errorf("expected 200 status code for part %d, got %d", number, resp.StatusCode)
+				return
+			}
+			partErrs <- nil
+		}(i + 1)
+	}
+	parts := []int{}
+	for i := 0; i < partCount; i++ {
+		if err := <-partErrs; err != nil {
+			t.Fatal("error uploading part: ", err)
+		}
+		parts = append(parts, i+1)
+	}
+	completeBody, err := json.Marshal(map[string]any{"parts": parts})
+	if err != nil {
+		t.Fatal("error encoding complete request: ", err)
+	}
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/buckets/bucket1/blobs/big_blob_copy/uploads/"+upload.Id+"/complete", bytes.NewReader(completeBody))
+	if err != nil {
+		t.Fatal("error creating complete request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending complete request: ", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 status code for upload completion, got %d", resp.StatusCode)
+	}
+	var completed blob.Blob
+	if err := json.NewDecoder(resp.Body).Decode(&completed); err != nil {
+		t.Fatal("error decoding blob: ", err)
+	}
+	resp.Body.Close()
+	if int64(completed.Size) != bigFileInfo.Size() {
+		t.Fatalf("expected assembled size %d, got %d", bigFileInfo.Size(), completed.Size)
+	}
+	if completed.Sha256 != bigFileSha256 {
+		t.Fatalf("expected assembled sha256 %s, got %s", bigFileSha256, completed.Sha256)
+	}
+
+	t.Log("replacing big_blob_copy with another multipart upload...")
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/buckets/bucket1/blobs/big_blob_copy/uploads", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		t.Fatal("error decoding upload: ", err)
+	}
+	resp.Body.Close()
+	req, err = http.NewRequest(http.MethodPut, serverURL+"/buckets/bucket1/blobs/big_blob_copy/uploads/"+upload.Id+"/parts/1", bytes.NewReader([]byte("replaced")))
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	resp.Body.Close()
+	req, err = http.NewRequest(http.MethodPost, serverURL+"/buckets/bucket1/blobs/big_blob_copy/uploads/"+upload.Id+"/complete", bytes.NewReader([]byte(`{"parts":[1]}`)))
+	if err != nil {
+		t.Fatal("error creating complete request: ", err)
+	}
+	req.Header.Set("Secret-Key", "1234")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending complete request: ", err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completed); err != nil {
+		t.Fatal("error decoding blob: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || completed.Size != len("replaced") {
+		t.Fatalf("expected the upload to replace the content, got %d, %+v", resp.StatusCode, completed)
+	}
+
+	// ========================================================
+
 	// Delete the blob
 	t.Log("deleting 'small_blob'...")
 	deleteBlobReq, err := http.NewRequest(http.MethodDelete, serverURL+"/buckets/bucket1/blobs/small_blob", nil)
@@ -0,0 +1,196 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	"github.com/assaidy/blob/client"
+	"github.com/assaidy/blob/utils"
+)
+
+func TestClient(t *testing.T) {
+	t.Log("starting blob server...")
+	s := blobtest.NewServer(t, blob.ServerConfig{
+		MaxChunkSize: 1 * blob.KB,
+		Storage:      blob.NewMemoryStorage(),
+	})
+	serverURL, c := s.URL, s.Client
+
+	ctx := context.Background()
+
+	// ========================================================
+
+	t.Log("checking decoded errors...")
+	_, err := c.GetBucket(ctx, "missing")
+	var apiE *utils.APIError
+	if !errors.As(err, &apiE) || apiE.Code != http.StatusNotFound || apiE.Message != "bucket not found" {
+		t.Fatalf("expected a 404 api error, got %v", err)
+	}
+	if _, err := client.New(client.Config{URL: serverURL}).GetAllBuckets(ctx); client.StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %v", err)
+	}
+
+	// ========================================================
+
+	t.Log("writing a blob in chunks...")
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBlob(ctx, "bucket1", "blob1", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("This is a line.\n"), 300) // 4800 bytes, 5 chunks.
+	w, err := c.NewWriter(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Offset() != len(content) {
+		t.Fatalf("expected offset %d, got %d", len(content), w.Offset())
+	}
+
+	b, err := c.GetBlob(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Size != len(content) || b.ContentType != "text/plain" {
+		t.Fatalf("unexpected blob %+v", b)
+	}
+
+	t.Log("retrying a chunk that was already written...")
+	if size, err := c.WriteChunk(ctx, "bucket1", "blob1", len(content)-16, content[len(content)-16:]); err != nil || size != len(content) {
+		t.Fatalf("expected the chunk to be taken as written, got %d, %v", size, err)
+	}
+
+	// ========================================================
+
+	t.Log("reading a blob in ranges...")
+	r, err := c.NewReader(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(content)) {
+		t.Fatalf("expected size %d, got %d", len(content), r.Size())
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("read content doesn't match the written content")
+	}
+
+	buf := make([]byte, 2000)
+	if n, err := r.ReadAt(buf, 1000); err != nil || !bytes.Equal(buf[:n], content[1000:3000]) {
+		t.Fatalf("unexpected ReadAt result %d, %v", n, err)
+	}
+	if n, err := r.ReadAt(buf, 4000); err != io.EOF || !bytes.Equal(buf[:n], content[4000:]) {
+		t.Fatalf("expected a short ReadAt with io.EOF, got %d, %v", n, err)
+	}
+	if _, err := r.Seek(-16, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "This is a line.\n" {
+		t.Fatalf("unexpected read after seek %q, %v", got, err)
+	}
+
+	t.Log("reading a changed blob...")
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", len(content), []byte("more")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAt(buf, 0); client.StatusCode(err) != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a changed blob, got %v", err)
+	}
+
+	// ========================================================
+
+	t.Log("reading with an access key...")
+	access, err := c.CreateAccess(ctx, "bucket1", "blob1", client.AccessOptions{ExpiresIn: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := c.NewAccessReader(ctx, access.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(ar); err != nil || !bytes.Equal(got, append(content, "more"...)) {
+		t.Fatalf("unexpected read with access key, %v", err)
+	}
+	if err := c.DeleteAccess(ctx, access.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	// ========================================================
+
+	t.Log("retrying transient errors...")
+	attempts := 0
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"maxChunkSize":1024}`))
+	}))
+	defer flaky.Close()
+	info, err := client.New(client.Config{URL: flaky.URL, MinBackoff: time.Millisecond}).Info(ctx)
+	if err != nil || info.MaxChunkSize != 1024 || attempts != 3 {
+		t.Fatalf("expected success on the third attempt, got %v after %d attempts", err, attempts)
+	}
+	attempts = 0
+	_, err = client.New(client.Config{URL: flaky.URL, MaxRetries: -1}).Info(ctx)
+	if client.StatusCode(err) != http.StatusServiceUnavailable || attempts != 1 {
+		t.Fatalf("expected a single failed attempt, got %v after %d attempts", err, attempts)
+	}
+	attempts = 0
+	_, err = client.New(client.Config{URL: flaky.URL, MinBackoff: time.Millisecond}).CreateBucket(ctx, "bucket2", "")
+	if client.StatusCode(err) != http.StatusServiceUnavailable || attempts != 1 {
+		t.Fatalf("expected a POST to be sent once, got %v after %d attempts", err, attempts)
+	}
+
+	// ========================================================
+
+	t.Log("rejecting responses that aren't the requested range...")
+	ignoring := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.Header.Get("Range") == "":
+			w.Write([]byte(`{"maxChunkSize":4}`))
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "8")
+		default: // The whole blob, as if Range was ignored.
+			w.Write([]byte("whole bl"))
+		}
+	}))
+	defer ignoring.Close()
+	ir, err := client.New(client.Config{URL: ignoring.URL, MaxRetries: -1}).NewAccessReader(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ir.ReadAt(make([]byte, 4), 4); err == nil || n != 0 {
+		t.Fatalf("expected a ReadAt of an ignored range to fail, got %d, %v", n, err)
+	}
+
+	// ========================================================
+
+	t.Log("deleting...")
+	if err := c.DeleteBlob(ctx, "bucket1", "blob1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteBucket(ctx, "bucket1"); err != nil {
+		t.Fatal(err)
+	}
+}
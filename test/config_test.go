@@ -0,0 +1,87 @@
+package blob
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/internal/config"
+)
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blobd.yaml")
+	file := "addr: \":4000\"\nmaxChunkSize: 2MB\nsecretKey: from-file\nsweepInterval: 10s\ns3:\n  credentials:\n    AKID: secret\n"
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BLOB_MAX_CHUNK_SIZE", "4MB")
+	t.Setenv("BLOB_SECRET_KEY", "from-env")
+
+	flags := flag.NewFlagSet("blobd", flag.ContinueOnError)
+	settings := config.RegisterFlags(flags)
+	if err := flags.Parse([]string{"-secret-key", "from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(path, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":4000" || cfg.MaxChunkSize != 4*blob.MB || cfg.SecretKey != "from-flag" ||
+		cfg.SweepInterval != 10*time.Second || cfg.ShutdownTimeout != 30*time.Second || cfg.S3.Credentials["AKID"] != "secret" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	t.Log("loading a TOML file...")
+	tomlPath := filepath.Join(t.TempDir(), "blobd.toml")
+	file = "addr = \":5000\"\nmaxChunkSize = \"2MB\"\nsweepInterval = \"10s\"\nrequireSealed = true\n\n[s3.credentials]\nAKID = \"secret\"\n"
+	if err := os.WriteFile(tomlPath, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg = config.Default()
+	if err := cfg.LoadFile(tomlPath); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":5000" || cfg.MaxChunkSize != 2*blob.MB || cfg.SweepInterval != 10*time.Second || !cfg.RequireSealed || cfg.S3.Credentials["AKID"] != "secret" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if err := os.WriteFile(tomlPath, []byte("maxChunkSiz = \"4MB\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.LoadFile(tomlPath); err == nil || !strings.Contains(err.Error(), "maxChunkSiz") {
+		t.Fatalf("expected an unknown TOML field to fail, got %v", err)
+	}
+
+	t.Log("checking invalid configs...")
+	if err := flags.Parse([]string{"-max-chunk-size", "8XB"}); err == nil {
+		t.Fatal("expected an invalid size flag to fail")
+	}
+	t.Setenv("BLOB_MAX_CHUNK_SIZE", "0")
+	t.Setenv("BLOB_METADATA_DIR", "")
+	_, err = config.Load(path, &config.Flags{})
+	if err == nil || !strings.Contains(err.Error(), "MaxChunkSize must be positive") || !strings.Contains(err.Error(), "MetadataDir must be set") {
+		t.Fatalf("expected every invalid field to be reported, got %v", err)
+	}
+	if err := os.WriteFile(path, []byte("maxChunkSize: 2MB\nmaxChunkSiz: 4MB\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(path, &config.Flags{}); err == nil || !strings.Contains(err.Error(), "maxChunkSiz") {
+		t.Fatalf("expected an unknown field to fail, got %v", err)
+	}
+	t.Setenv("BLOB_SECRET_KEY", "")
+	if err := os.WriteFile(path, []byte("maxChunkSize: 2MB\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(path, &config.Flags{}); err == nil || !strings.Contains(err.Error(), "SigningKey or SecretKey must be set") {
+		t.Fatalf("expected a config without keys to fail, got %v", err)
+	}
+
+	t.Log("failing to create a server...")
+	if _, err := blob.New(blob.ServerConfig{MaxChunkSize: 1 * blob.MB, SecretKey: "1234", RootDir: filepath.Join(path, "blobs"), MetadataDir: t.TempDir()}); err == nil {
+		t.Fatal("expected a root dir under a file to fail")
+	}
+}
@@ -0,0 +1,283 @@
+package blob
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	"github.com/assaidy/blob/client"
+)
+
+func TestLifecycle(t *testing.T) {
+	s := blobtest.NewServer(t, blob.ServerConfig{LifecycleInterval: 10 * time.Millisecond})
+	ctx := context.Background()
+	c := s.Client
+
+	write := func(blobId, content string) {
+		t.Helper()
+		if err := c.CreateBlob(ctx, "bucket1", blobId, "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.WriteChunk(ctx, "bucket1", blobId, 0, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBucketVersioning(ctx, "bucket1", true); err != nil {
+		t.Fatal(err)
+	}
+	write("logs-1", "first")
+	write("logs-2", "second")
+	write("data-1", "third")
+	write("logs-old", "old")
+	if err := c.DeleteBlob(ctx, "bucket1", "logs-old"); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/buckets/bucket1/blobs/logs-2/uploads", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", blobtest.SecretKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 for creating an upload, got %d", resp.StatusCode)
+	}
+
+	rules := []*blob.LifecycleRule{
+		{Id: "logs", Prefix: "logs-", ExpireAfterDays: 7, PurgeVersionsAfterDays: 30},
+		{Id: "uploads", AbortUploadsAfterHours: 24},
+	}
+
+	t.Log("previewing lifecycle rules...")
+	actions, err := c.PreviewBucketLifecycle(ctx, "bucket1", rules, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions.ExpiredBlobs) != 0 || len(actions.PurgedVersions) != 0 || len(actions.AbortedUploads) != 0 {
+		t.Fatalf("expected no actions yet, got %+v", actions)
+	}
+	actions, err = c.PreviewBucketLifecycle(ctx, "bucket1", rules, time.Now().Add(8*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions.ExpiredBlobs) != 2 || actions.ExpiredBlobs[0].Id != "logs-1" || actions.ExpiredBlobs[1].Id != "logs-2" ||
+		len(actions.PurgedVersions) != 0 || len(actions.AbortedUploads) != 1 {
+		t.Fatalf("expected the logs to expire and the upload to be aborted, got %+v", actions)
+	}
+	actions, err = c.PreviewBucketLifecycle(ctx, "bucket1", rules, time.Now().Add(31*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions.PurgedVersions) != 2 || actions.PurgedVersions[0].Id != "logs-old" {
+		t.Fatalf("expected the older version and delete marker to be purged, got %+v", actions)
+	}
+	if rules, err := c.GetBucketLifecycle(ctx, "bucket1"); err != nil || len(rules) != 0 {
+		t.Fatalf("expected a dry run not to save the rules, got %+v, %v", rules, err)
+	}
+
+	// ========================================================
+
+	t.Log("saving lifecycle rules...")
+	if err := c.SetBucketLifecycle(ctx, "bucket1", rules); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := c.GetBucketLifecycle(ctx, "bucket1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 || *saved[0] != *rules[0] || *saved[1] != *rules[1] {
+		t.Fatalf("expected the saved rules, got %+v", saved)
+	}
+	// The scheduler leaves everything that isn't old enough alone.
+	time.Sleep(50 * time.Millisecond)
+	if blobs, err := c.GetAllBlobs(ctx, "bucket1"); err != nil || len(blobs) != 3 {
+		t.Fatalf("expected every blob to be kept, got %+v, %v", blobs, err)
+	}
+	if versions, err := c.GetVersions(ctx, "bucket1", "logs-old"); err != nil || len(versions) != 2 {
+		t.Fatalf("expected the older versions to be kept, got %+v, %v", versions, err)
+	}
+
+	// ========================================================
+
+	t.Log("rejecting invalid lifecycle rules...")
+	for name, invalid := range map[string][]*blob.LifecycleRule{
+		"no id":        {{AbortUploadsAfterHours: 1}},
+		"duplicate id": {{Id: "a", ExpireAfterDays: 1}, {Id: "a", ExpireAfterDays: 2}},
+		"negative age": {{Id: "a", ExpireAfterDays: -1}},
+		"no action":    {{Id: "a", Prefix: "logs-"}},
+	} {
+		if err := c.SetBucketLifecycle(ctx, "bucket1", invalid); client.StatusCode(err) != http.StatusBadRequest {
+			t.Fatalf("expected 400 for rules with %s, got %v", name, err)
+		}
+	}
+	if err := c.SetBucketLifecycle(ctx, "missing", rules); client.StatusCode(err) != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing bucket, got %v", err)
+	}
+	if err := c.SetBucketLifecycle(ctx, "bucket1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := c.GetBucketLifecycle(ctx, "bucket1"); err != nil || len(saved) != 0 {
+		t.Fatalf("expected the rules to be removed, got %+v, %v", saved, err)
+	}
+}
+
+// renameHookStorage is a storage that calls hook before every rename.
+type renameHookStorage struct {
+	blob.Storage
+	hook func(oldKey string)
+}
+
+func (me *renameHookStorage) Rename(oldKey, newKey string) error {
+	me.hook(oldKey)
+	return me.Storage.Rename(oldKey, newKey)
+}
+
+func TestApplyLifecycle(t *testing.T) {
+	storage := &renameHookStorage{Storage: blob.NewMemoryStorage(), hook: func(string) {}}
+	s := blobtest.NewServer(t, blob.ServerConfig{Storage: storage})
+	ctx := context.Background()
+	c := s.Client
+
+	write := func(bucketId, blobId, content string) {
+		t.Helper()
+		if err := c.CreateBlob(ctx, bucketId, blobId, "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.WriteChunk(ctx, bucketId, blobId, 0, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(bucketId, blobId string) bool {
+		t.Helper()
+		_, err := c.GetBlob(ctx, bucketId, blobId)
+		if err != nil && client.StatusCode(err) != http.StatusNotFound {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	rules := []*blob.LifecycleRule{
+		{Id: "logs", Prefix: "logs-", ExpireAfterDays: 7, PurgeVersionsAfterDays: 30},
+		{Id: "uploads", AbortUploadsAfterHours: 24},
+	}
+	for _, bucketId := range []string{"bucket1", "bucket2"} {
+		if _, err := c.CreateBucket(ctx, bucketId, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.SetBucketLifecycle(ctx, bucketId, rules); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("bucket1", "logs-1", "log 1")
+	write("bucket1", "logs-2", "log 2")
+	write("bucket1", "logs-held", "held")
+	write("bucket1", "logs-retained", "retained")
+	write("bucket1", "data-1", "data")
+	if _, err := c.SetBlobLegalHold(ctx, "bucket1", "logs-held", true); err != nil {
+		t.Fatal(err)
+	}
+	retainUntil := time.Now().Add(24 * time.Hour)
+	if _, err := c.SetBlobRetention(ctx, "bucket1", "logs-retained", &retainUntil); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/buckets/bucket1/blobs/data-1/uploads", http.NoBody)
+	if err != nil {
+		t.Fatal("error creating request: ", err)
+	}
+	req.Header.Set("Secret-Key", blobtest.SecretKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("error sending request: ", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 for creating an upload, got %d", resp.StatusCode)
+	}
+	if err := c.SetBucketVersioning(ctx, "bucket2", true); err != nil {
+		t.Fatal(err)
+	}
+	write("bucket2", "logs-old", "old")
+	if err := c.DeleteBlob(ctx, "bucket2", "logs-old"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ========================================================
+
+	t.Log("applying lifecycle rules before anything is old enough...")
+	if err := s.Server.ApplyLifecycle(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, blobId := range []string{"logs-1", "logs-2", "logs-held", "logs-retained", "data-1"} {
+		if !exists("bucket1", blobId) {
+			t.Fatalf("expected %s to be kept", blobId)
+		}
+	}
+
+	// ========================================================
+
+	t.Log("expiring blobs and aborting uploads...")
+	// Whichever of logs-1 and logs-2 is expired first, the other is appended to
+	// while it's moved into the trash, so it changed since it was planned.
+	var changed string
+	storage.hook = func(oldKey string) {
+		for expired, other := range map[string]string{"logs-1": "logs-2", "logs-2": "logs-1"} {
+			if changed == "" && strings.HasSuffix(oldKey, "/"+expired) {
+				changed = other
+				if _, err := c.WriteChunk(ctx, "bucket1", other, len("log 1"), []byte(" more")); err != nil {
+					t.Error("error appending: ", err)
+				}
+			}
+		}
+	}
+	if err := s.Server.ApplyLifecycle(time.Now().Add(8 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	storage.hook = func(string) {}
+	if changed == "" {
+		t.Fatal("expected logs-1 or logs-2 to be moved into the trash")
+	}
+	for blobId, kept := range map[string]bool{
+		"logs-1":        changed == "logs-1",
+		"logs-2":        changed == "logs-2",
+		"logs-held":     true,
+		"logs-retained": true,
+		"data-1":        true,
+	} {
+		if exists("bucket1", blobId) != kept {
+			t.Fatalf("expected %s to be kept: %v", blobId, kept)
+		}
+	}
+	if items, err := c.GetTrash(ctx); err != nil || len(items) != 1 {
+		t.Fatalf("expected the expired blob to be trashed, got %+v, %v", items, err)
+	}
+	actions, err := c.PreviewBucketLifecycle(ctx, "bucket1", rules, time.Now().Add(8*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions.AbortedUploads) != 0 {
+		t.Fatalf("expected the upload to be aborted, got %+v", actions.AbortedUploads)
+	}
+
+	// ========================================================
+
+	t.Log("purging older versions...")
+	if versions, err := c.GetVersions(ctx, "bucket2", "logs-old"); err != nil || len(versions) != 2 {
+		t.Fatalf("expected the older versions to be kept so far, got %+v, %v", versions, err)
+	}
+	if err := s.Server.ApplyLifecycle(time.Now().Add(31 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err := c.GetAllVersions(ctx, "bucket2"); err != nil || len(versions) != 0 {
+		t.Fatalf("expected the older versions to be purged, got %+v, %v", versions, err)
+	}
+}
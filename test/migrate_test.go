@@ -0,0 +1,121 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// baselineSchema is the schema of the first release, before migrations.
+const baselineSchema = `
+CREATE TABLE buckets (
+    id TEXT,
+    created_at TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+CREATE TABLE blobs (
+    id TEXT,
+    bucket_id TEXT,
+    size INTEGER,
+    created_at TIMESTAMP,
+
+    PRIMARY KEY (id, bucket_id),
+    FOREIGN KEY (bucket_id) REFERENCES buckets(id) ON DELETE CASCADE
+);
+CREATE TABLE accesses (
+    key TEXT,
+    bucket_id TEXT,
+    blob_id TEXT,
+    created_at TIMESTAMP,
+
+    PRIMARY KEY (key),
+    FOREIGN KEY (bucket_id, blob_id) REFERENCES blobs(bucket_id, id) ON DELETE CASCADE
+);
+`
+
+func TestMigrate(t *testing.T) {
+	metadataDir, rootDir := t.TempDir(), t.TempDir()
+
+	t.Log("creating a database of the first release...")
+	db, err := sql.Open("sqlite3", filepath.Join(metadataDir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	now := time.Now().UTC()
+	for _, query := range []string{
+		baselineSchema,
+		`INSERT INTO buckets (id, created_at) VALUES ('bucket1', ?);`,
+		`INSERT INTO blobs (id, bucket_id, size, created_at) VALUES ('blob1', 'bucket1', 5, ?);`,
+		`INSERT INTO accesses (key, bucket_id, blob_id, created_at) VALUES ('key1', 'bucket1', 'blob1', ?);`,
+	} {
+		if _, err := db.Exec(query, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(rootDir, "bucket1"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootDir, "bucket1", "blob1"), []byte("hello"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// ========================================================
+
+	t.Log("migrating the database...")
+	s := blobtest.NewServer(t, blob.ServerConfig{MetadataDir: metadataDir, RootDir: rootDir})
+	ctx := context.Background()
+	c := s.Client
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil || version == 0 {
+		t.Fatalf("expected the database to be migrated, got version %d, %v", version, err)
+	}
+	var checksum string
+	if err := db.QueryRow(`SELECT sha256 FROM blobs WHERE id = 'blob1';`).Scan(&checksum); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("hello"))
+	if checksum != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected the existing blob to be hashed, got %q", checksum)
+	}
+
+	b, err := c.GetBlob(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Size != 5 || b.Sha256 != checksum || b.ContentType != "application/octet-stream" || b.State != blob.BlobOpen || b.VersionId == "" || !b.UpdatedAt.Equal(b.CreatedAt) {
+		t.Fatalf("expected the existing blob with defaults, got %+v", b)
+	}
+	if bucket, err := c.GetBucket(ctx, "bucket1"); err != nil || bucket.Policy != blob.PolicyPrivate || bucket.Versioning {
+		t.Fatalf("expected a private bucket without versioning, got %+v, %v", bucket, err)
+	}
+
+	// ========================================================
+
+	t.Log("using the migrated blob...")
+	r, err := c.NewAccessReader(ctx, "key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := io.ReadAll(r); err != nil || string(content) != "hello" {
+		t.Fatalf("expected the existing access key to download 'hello', got %q, %v", content, err)
+	}
+	if size, err := c.WriteChunk(ctx, "bucket1", "blob1", 5, []byte(" world")); err != nil || size != 11 {
+		t.Fatalf("expected the existing blob to be appendable, got %d, %v", size, err)
+	}
+	sum = sha256.Sum256([]byte("hello world"))
+	if b, err := c.GetBlob(ctx, "bucket1", "blob1"); err != nil || b.Sha256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected the checksum to carry over the append, got %+v, %v", b, err)
+	}
+}
@@ -0,0 +1,191 @@
+package blob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	"github.com/assaidy/blob/client"
+)
+
+func TestObjectLock(t *testing.T) {
+	s := blobtest.NewServer(t, blob.ServerConfig{})
+	ctx := context.Background()
+	c := s.Client
+
+	write := func(bucketId, blobId, content string) {
+		t.Helper()
+		if err := c.CreateBlob(ctx, bucketId, blobId, "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.WriteChunk(ctx, bucketId, blobId, 0, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	overwrite := func(bucketId, blobId string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, s.URL+"/buckets/"+bucketId+"/blobs?blob_id="+blobId+"&overwrite=true", http.NoBody)
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Secret-Key", blobtest.SecretKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending request: ", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	at := func(d time.Duration) *time.Time {
+		retainUntil := time.Now().Add(d).UTC().Truncate(time.Second)
+		return &retainUntil
+	}
+
+	t.Log("retaining a blob...")
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+	write("bucket1", "blob1", "hello")
+	retained, err := c.SetBlobRetention(ctx, "bucket1", "blob1", at(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retained.RetainUntil == nil || !retained.RetainUntil.After(time.Now()) {
+		t.Fatalf("expected the blob to be retained, got %+v", retained)
+	}
+	if err := c.DeleteBlob(ctx, "bucket1", "blob1"); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for deleting a retained blob, got %v", err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 5, []byte(" world")); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for writing to a retained blob, got %v", err)
+	}
+	if status := overwrite("bucket1", "blob1"); status != http.StatusForbidden {
+		t.Fatalf("expected 403 for overwriting a retained blob, got %d", status)
+	}
+	if err := c.DeleteBucket(ctx, "bucket1"); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for deleting a bucket with a retained blob, got %v", err)
+	}
+	for name, retainUntil := range map[string]*time.Time{"shortening": at(time.Minute), "clearing": nil} {
+		if _, err := c.SetBlobRetention(ctx, "bucket1", "blob1", retainUntil); client.StatusCode(err) != http.StatusForbidden {
+			t.Fatalf("expected 403 for %s an active retention, got %v", name, err)
+		}
+	}
+	extended := at(2 * time.Hour)
+	if _, err := c.SetBlobRetention(ctx, "bucket1", "blob1", extended); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.GetBlob(ctx, "bucket1", "blob1"); err != nil || b.Size != 5 || !b.RetainUntil.Equal(*extended) {
+		t.Fatalf("expected the retained blob to be intact and its retention extended, got %+v, %v", b, err)
+	}
+
+	// ========================================================
+
+	t.Log("holding a blob...")
+	write("bucket1", "blob2", "held")
+	if held, err := c.SetBlobLegalHold(ctx, "bucket1", "blob2", true); err != nil || !held.LegalHold {
+		t.Fatalf("expected the blob to be held, got %+v, %v", held, err)
+	}
+	if err := c.DeleteBlob(ctx, "bucket1", "blob2"); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for deleting a held blob, got %v", err)
+	}
+	if _, err := c.SetBlobLegalHold(ctx, "bucket1", "blob2", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteBlob(ctx, "bucket1", "blob2"); err != nil {
+		t.Fatal(err)
+	}
+
+	write("bucket1", "blob3", "expired")
+	if _, err := c.SetBlobRetention(ctx, "bucket1", "blob3", at(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if status := overwrite("bucket1", "blob3"); status != http.StatusCreated {
+		t.Fatalf("expected 201 for overwriting a blob whose retention is over, got %d", status)
+	}
+	if err := c.DeleteBlob(ctx, "bucket1", "blob3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ========================================================
+
+	t.Log("retaining blobs by default...")
+	if _, err := c.CreateBucket(ctx, "bucket2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBucketObjectLock(ctx, "bucket2", blob.ObjectLock{DefaultRetentionDays: 1}); err != nil {
+		t.Fatal(err)
+	}
+	retainedForADay := func(b *blob.Blob) bool {
+		return b.RetainUntil != nil && b.RetainUntil.After(time.Now().Add(23*time.Hour))
+	}
+	// Blobs being uploaded in chunks are retained from their first write, and
+	// can still be appended to until they're sealed.
+	if err := c.CreateBlob(ctx, "bucket2", "blob1", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.GetBlob(ctx, "bucket2", "blob1"); err != nil || b.RetainUntil != nil {
+		t.Fatalf("expected the empty blob not to be retained yet, got %+v, %v", b, err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket2", "blob1", 0, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.GetBlob(ctx, "bucket2", "blob1"); err != nil || !retainedForADay(b) {
+		t.Fatalf("expected the written blob to be retained for a day, got %+v, %v", b, err)
+	}
+	if err := c.DeleteBlob(ctx, "bucket2", "blob1"); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for deleting an open blob retained by default, got %v", err)
+	}
+	if _, err := c.TruncateBlob(ctx, "bucket2", "blob1", 0); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for truncating an open blob retained by default, got %v", err)
+	}
+	if _, err := c.ReplaceBlob(ctx, "bucket2", "blob1", []byte("bye")); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for replacing an open blob retained by default, got %v", err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket2", "blob1", 5, []byte(" world")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.SealBlob(ctx, "bucket2", "blob1", 11, ""); err != nil || !retainedForADay(b) {
+		t.Fatalf("expected the sealed blob to be retained for a day, got %+v, %v", b, err)
+	}
+	if err := c.DeleteBlob(ctx, "bucket2", "blob1"); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for deleting a blob retained by default, got %v", err)
+	}
+	// Content written as a whole is retained right away.
+	if err := c.CreateBlob(ctx, "bucket2", "blob2", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReplaceBlob(ctx, "bucket2", "blob2", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.GetBlob(ctx, "bucket2", "blob2"); err != nil || !retainedForADay(b) {
+		t.Fatalf("expected the written blob to be retained for a day, got %+v, %v", b, err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket2", "blob2", 5, []byte(" world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteBlob(ctx, "bucket2", "blob2"); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for deleting a blob retained by default, got %v", err)
+	}
+	// Legal holds stop appends too, unless the bucket allows them.
+	if _, err := c.SetBlobLegalHold(ctx, "bucket2", "blob2", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket2", "blob2", 11, []byte("!")); client.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 for appending to a held blob, got %v", err)
+	}
+	if err := c.SetBucketObjectLock(ctx, "bucket2", blob.ObjectLock{DefaultRetentionDays: 1, AllowAppends: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket2", "blob2", 11, []byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	if bucket, err := c.GetBucket(ctx, "bucket2"); err != nil || bucket.ObjectLock.DefaultRetentionDays != 1 || !bucket.ObjectLock.AllowAppends {
+		t.Fatalf("expected the object lock of the bucket, got %+v, %v", bucket, err)
+	}
+	if err := c.SetBucketObjectLock(ctx, "bucket2", blob.ObjectLock{DefaultRetentionDays: -1}); client.StatusCode(err) != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a negative default retention, got %v", err)
+	}
+}
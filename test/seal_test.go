@@ -0,0 +1,126 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	"github.com/assaidy/blob/client"
+)
+
+func TestSeal(t *testing.T) {
+	s := blobtest.NewServer(t, blob.ServerConfig{RequireSealed: true})
+	ctx := context.Background()
+	c := s.Client
+
+	read := func() (string, error) {
+		t.Helper()
+		r, err := c.NewReader(ctx, "bucket1", "blob1")
+		if err != nil {
+			return "", err
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content), nil
+	}
+
+	t.Log("refusing to serve an open blob...")
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBlob(ctx, "bucket1", "blob1", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 0, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.GetBlob(ctx, "bucket1", "blob1"); err != nil || b.State != blob.BlobOpen {
+		t.Fatalf("expected an open blob, got %+v, %v", b, err)
+	}
+	if _, err := read(); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for downloading an open blob, got %v", err)
+	}
+	if _, err := c.CreateAccess(ctx, "bucket1", "blob1", client.AccessOptions{}); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for a download key of an open blob, got %v", err)
+	}
+	if _, err := c.CreateAccess(ctx, "bucket1", "blob1", client.AccessOptions{Scope: blob.ScopeUpload}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ========================================================
+
+	t.Log("sealing a blob...")
+	hash := sha256.Sum256([]byte("hello"))
+	if _, err := c.SealBlob(ctx, "bucket1", "blob1", 4, ""); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for sealing with the wrong size, got %v", err)
+	}
+	if _, err := c.SealBlob(ctx, "bucket1", "blob1", -1, hex.EncodeToString(hash[:4])); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for sealing with the wrong checksum, got %v", err)
+	}
+	sealed, err := c.SealBlob(ctx, "bucket1", "blob1", 5, hex.EncodeToString(hash[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed.State != blob.BlobSealed || sealed.Size != 5 {
+		t.Fatalf("expected a sealed blob, got %+v", sealed)
+	}
+	if got, err := read(); err != nil || got != "hello" {
+		t.Fatalf("expected the sealed blob to read 'hello', got %q, %v", got, err)
+	}
+	if _, err := c.CreateAccess(ctx, "bucket1", "blob1", client.AccessOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 5, []byte(" world")); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for writing to a sealed blob, got %v", err)
+	}
+	if _, err := c.SealBlob(ctx, "bucket1", "blob1", -1, ""); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for sealing a sealed blob, got %v", err)
+	}
+
+	// ========================================================
+
+	t.Log("overwriting a sealed blob...")
+	do := func(method, path string, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, s.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Secret-Key", blobtest.SecretKey)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending request: ", err)
+		}
+		return resp
+	}
+	resp := do(http.MethodPost, "/buckets/bucket1/blobs?blob_id=blob1&overwrite=true", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for overwriting a sealed blob, got %d", resp.StatusCode)
+	}
+	var upload blob.Upload
+	resp = do(http.MethodPost, "/buckets/bucket1/blobs/blob1/uploads", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		t.Fatal("error decoding upload: ", err)
+	}
+	resp.Body.Close()
+	do(http.MethodPut, "/buckets/bucket1/blobs/blob1/uploads/"+upload.Id+"/parts/1", []byte("replaced")).Body.Close()
+	resp = do(http.MethodPost, "/buckets/bucket1/blobs/blob1/uploads/"+upload.Id+"/complete", []byte(`{"parts":[1]}`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for completing an upload over a sealed blob, got %d", resp.StatusCode)
+	}
+	if b, err := c.GetBlob(ctx, "bucket1", "blob1"); err != nil || b.State != blob.BlobSealed || b.Size != 5 {
+		t.Fatalf("expected the sealed blob to be kept, got %+v, %v", b, err)
+	}
+}
@@ -0,0 +1,174 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	"github.com/assaidy/blob/client"
+)
+
+func TestServerLifecycle(t *testing.T) {
+	t.Log("serving on a unix socket...")
+	socket := filepath.Join(t.TempDir(), "blob.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal("error listening: ", err)
+	}
+	s := blob.NewServer(blob.ServerConfig{
+		MaxChunkSize: 1 * blob.MB,
+		SecretKey:    "1234",
+		MetadataDir:  t.TempDir(),
+		Storage:      blob.NewMemoryStorage(),
+	})
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+
+	// Request bodies wait for "100 Continue", so the upload's body is only read
+	// once the server is handling it.
+	httpClient := &http.Client{Transport: &http.Transport{
+		ExpectContinueTimeout: 5 * time.Second,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	do := func(method, path string, body io.Reader) (*http.Response, error) {
+		req, err := http.NewRequest(method, "http://blob"+path, body)
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Secret-Key", "1234")
+		req.Header.Set("Expect", "100-continue")
+		return httpClient.Do(req)
+	}
+	for _, path := range []string{"/buckets?bucket_id=bucket1", "/buckets/bucket1/blobs?blob_id=blob1"} {
+		resp, err := do(http.MethodPost, path, http.NoBody)
+		if err != nil {
+			t.Fatal("error sending request: ", err)
+		}
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected 201 for %s, got %d", path, resp.StatusCode)
+		}
+	}
+
+	// ========================================================
+
+	t.Log("shutting down during an upload...")
+	body, bodyWriter := io.Pipe()
+	uploaded := make(chan *http.Response, 1)
+	go func() {
+		resp, err := do(http.MethodPut, "/buckets/bucket1/blobs/blob1", body)
+		if err != nil {
+			t.Error("error sending upload: ", err)
+		}
+		uploaded <- resp
+	}()
+	bodyWriter.Write([]byte("hello "))
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- s.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expected shutdown to wait for the upload, got %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	bodyWriter.Write([]byte("world"))
+	bodyWriter.Close()
+	if resp := <-uploaded; resp == nil || resp.StatusCode != http.StatusOK || resp.Header.Get("Upload-Offset") != "11" || !resp.Close {
+		t.Fatalf("expected the in-flight upload to finish and close its connection, got %v", resp)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal("error shutting down: ", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatal("expected Serve to return nil after shutdown, got ", err)
+	}
+
+	// ========================================================
+
+	t.Log("checking the closed server...")
+	if _, err := do(http.MethodGet, "/buckets", http.NoBody); err == nil {
+		t.Fatal("expected requests to fail after shutdown")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal("expected Close after Shutdown to succeed, got ", err)
+	}
+}
+
+// blockingStorage is a storage whose appends block, once block is set, until
+// release is closed. started receives every blocked append.
+type blockingStorage struct {
+	blob.Storage
+	block   atomic.Bool
+	started chan struct{}
+	release chan struct{}
+}
+
+func (me *blockingStorage) OpenAppend(key string) (io.WriteCloser, error) {
+	if me.block.Load() {
+		me.started <- struct{}{}
+		<-me.release
+	}
+	return me.Storage.OpenAppend(key)
+}
+
+func TestCloseWaitsForHandlers(t *testing.T) {
+	storage := &blockingStorage{Storage: blob.NewMemoryStorage(), started: make(chan struct{}, 1), release: make(chan struct{})}
+	config := blob.ServerConfig{MaxChunkSize: 1 * blob.MB, SecretKey: "1234", MetadataDir: t.TempDir(), Storage: storage}
+	s := blob.NewServer(config)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("error listening: ", err)
+	}
+	go s.Serve(ln)
+	ctx := context.Background()
+	c := client.New(client.Config{URL: "http://" + ln.Addr().String(), SecretKey: "1234", MaxRetries: -1})
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBlob(ctx, "bucket1", "blob1", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ========================================================
+
+	t.Log("closing during an append...")
+	storage.block.Store(true)
+	go c.WriteChunk(ctx, "bucket1", "blob1", 0, []byte("hello"))
+	<-storage.started
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close() }()
+	select {
+	case err := <-closed:
+		t.Fatalf("expected Close to wait for the append, got %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	storage.block.Store(false)
+	close(storage.release)
+	if err := <-closed; err != nil {
+		t.Fatal("error closing: ", err)
+	}
+
+	t.Log("checking the append after reopening...")
+	reopened := blobtest.NewServer(t, config)
+	if b, err := reopened.Client.GetBlob(ctx, "bucket1", "blob1"); err != nil || b.Size != 5 {
+		t.Fatalf("expected the append to be recorded, got %+v, %v", b, err)
+	}
+	if r, err := reopened.Client.NewReader(ctx, "bucket1", "blob1"); err != nil {
+		t.Fatal(err)
+	} else if content, err := io.ReadAll(r); err != nil || string(content) != "hello" {
+		t.Fatalf("expected 'hello', got %q, %v", content, err)
+	}
+}
@@ -0,0 +1,356 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	"github.com/assaidy/blob/client"
+)
+
+func TestStorage(t *testing.T) {
+	backends := map[string]blob.Storage{
+		"local":  blob.NewLocalStorage(t.TempDir()),
+		"memory": blob.NewMemoryStorage(),
+	}
+
+	for name, storage := range backends {
+		t.Run(name, func(t *testing.T) {
+			w, err := storage.OpenAppend("bucket1/blob1")
+			if err != nil {
+				t.Fatal("error opening for append: ", err)
+			}
+			for _, chunk := range []string{"hello ", "world"} {
+				if _, err := w.Write([]byte(chunk)); err != nil {
+					t.Fatal("error appending: ", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal("error closing appender: ", err)
+			}
+
+			size, err := storage.Stat("bucket1/blob1")
+			if err != nil {
+				t.Fatal("error stating: ", err)
+			}
+			if size != 11 {
+				t.Fatalf("expected size 11, got %d", size)
+			}
+
+			r, err := storage.OpenReader("bucket1/blob1")
+			if err != nil {
+				t.Fatal("error opening for read: ", err)
+			}
+			data := make([]byte, 5)
+			if _, err := r.ReadAt(data, 6); err != nil && !errors.Is(err, io.EOF) {
+				t.Fatal("error reading: ", err)
+			}
+			r.Close()
+			if string(data) != "world" {
+				t.Fatalf("expected 'world', got %q", data)
+			}
+
+			if _, err := storage.OpenAppend("../escape"); !errors.Is(err, blob.ErrInvalidKey) {
+				t.Fatal("expected invalid key error, got ", err)
+			}
+
+			if err := storage.Rename("bucket1/blob1", ".versions/bucket1/v1"); err != nil {
+				t.Fatal("error renaming: ", err)
+			}
+			if _, err := storage.Stat("bucket1/blob1"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal("expected not exist error after rename, got ", err)
+			}
+			if size, err := storage.Stat(".versions/bucket1/v1"); err != nil || size != 11 {
+				t.Fatalf("expected the renamed object of size 11, got %d, %v", size, err)
+			}
+			if err := storage.Rename(".versions/bucket1/v1", "bucket1/blob1"); err != nil {
+				t.Fatal("error renaming back: ", err)
+			}
+
+			if err := storage.WriteAt("bucket1/blob1", []byte("there!"), 6); err != nil {
+				t.Fatal("error writing at an offset: ", err)
+			}
+			if err := storage.Truncate("bucket1/blob1", 11); err != nil {
+				t.Fatal("error truncating: ", err)
+			}
+			r, err = storage.OpenReader("bucket1/blob1")
+			if err != nil {
+				t.Fatal("error opening for read: ", err)
+			}
+			data = make([]byte, 11)
+			if _, err := r.ReadAt(data, 0); err != nil && !errors.Is(err, io.EOF) {
+				t.Fatal("error reading: ", err)
+			}
+			r.Close()
+			if string(data) != "hello there" {
+				t.Fatalf("expected 'hello there', got %q", data)
+			}
+			if err := storage.WriteAt("bucket1/missing", []byte("x"), 0); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal("expected not exist error for writing to a missing object, got ", err)
+			}
+
+			if err := storage.Delete("bucket1/blob1"); err != nil {
+				t.Fatal("error deleting: ", err)
+			}
+			if _, err := storage.Stat("bucket1/blob1"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal("expected not exist error, got ", err)
+			}
+
+			for _, key := range []string{"bucket2/a", "bucket2/b"} {
+				w, err := storage.OpenAppend(key)
+				if err != nil {
+					t.Fatal("error opening for append: ", err)
+				}
+				w.Close()
+			}
+			if err := storage.DeletePrefix("bucket2/"); err != nil {
+				t.Fatal("error deleting prefix: ", err)
+			}
+			if _, err := storage.Stat("bucket2/a"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal("expected not exist error, got ", err)
+			}
+
+			for _, key := range []string{"bucket3/x/", "bucket3//x", "bucket3/./x"} {
+				if _, err := storage.OpenAppend(key); !errors.Is(err, blob.ErrInvalidKey) {
+					t.Fatalf("expected invalid key error for %q, got %v", key, err)
+				}
+			}
+			w, err = storage.OpenAppend("bucket3/a/b")
+			if err != nil {
+				t.Fatal("error opening for append: ", err)
+			}
+			w.Close()
+			if _, err := storage.OpenAppend("bucket3/a"); !errors.Is(err, blob.ErrKeyConflict) {
+				t.Fatal("expected a conflict for an object over others, got ", err)
+			}
+			if _, err := storage.OpenAppend("bucket3/a/b/c"); !errors.Is(err, blob.ErrKeyConflict) {
+				t.Fatal("expected a conflict for an object under another, got ", err)
+			}
+			if err := storage.Rename("bucket3/a/b", "bucket3/a/b/c"); !errors.Is(err, blob.ErrKeyConflict) {
+				t.Fatal("expected a conflict for renaming under another object, got ", err)
+			}
+			if _, err := storage.Stat("bucket3/a"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatal("expected not exist error for the parent of an object, got ", err)
+			}
+			if err := storage.Delete("bucket3/a/b"); err != nil {
+				t.Fatal("error deleting: ", err)
+			}
+			if w, err := storage.OpenAppend("bucket3/a"); err != nil {
+				t.Fatal("expected the key of a deleted parent to be free, got ", err)
+			} else {
+				w.Close()
+			}
+		})
+	}
+}
+
+// failingStorage is a storage whose appends write half of their data and fail
+// while fail is set.
+type failingStorage struct {
+	blob.Storage
+	fail atomic.Bool
+}
+
+func (me *failingStorage) OpenAppend(key string) (io.WriteCloser, error) {
+	w, err := me.Storage.OpenAppend(key)
+	if err != nil {
+		return nil, err
+	}
+	return &failingWriter{WriteCloser: w, fail: &me.fail}, nil
+}
+
+type failingWriter struct {
+	io.WriteCloser
+	fail *atomic.Bool
+}
+
+func (me *failingWriter) Write(p []byte) (int, error) {
+	if !me.fail.Load() {
+		return me.WriteCloser.Write(p)
+	}
+	n, _ := me.WriteCloser.Write(p[:len(p)/2])
+	return n, errors.New("disk is full")
+}
+
+func TestFailedAppend(t *testing.T) {
+	storage := &failingStorage{Storage: blob.NewMemoryStorage()}
+	s := blobtest.NewServer(t, blob.ServerConfig{Storage: storage})
+	ctx := context.Background()
+	c := s.Client
+
+	read := func() string {
+		t.Helper()
+		r, err := c.NewReader(ctx, "bucket1", "blob1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	t.Log("rolling back a failed append...")
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBlob(ctx, "bucket1", "blob1", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 0, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	storage.fail.Store(true)
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 5, []byte(" world")); client.StatusCode(err) != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for a failed append, got %v", err)
+	}
+	storage.fail.Store(false)
+	if got := read(); got != "hello" {
+		t.Fatalf("expected the failed append to be cut off, got %q", got)
+	}
+	if size, err := c.WriteChunk(ctx, "bucket1", "blob1", 5, []byte(" world")); err != nil || size != 11 {
+		t.Fatalf("expected the retry to append at the same offset, got %d, %v", size, err)
+	}
+	if got := read(); got != "hello world" {
+		t.Fatalf("expected 'hello world', got %q", got)
+	}
+}
+
+func TestFailedReplace(t *testing.T) {
+	dir := t.TempDir()
+	storage := &failingRenameStorage{Storage: blob.NewLocalStorage(dir)}
+	s := blobtest.NewServer(t, blob.ServerConfig{Storage: storage})
+	ctx := context.Background()
+	c := s.Client
+
+	files := func() []string {
+		t.Helper()
+		var files []string
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() {
+				rel, _ := filepath.Rel(dir, path)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	t.Log("keeping the content and versions of a failed replace...")
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBucketVersioning(ctx, "bucket1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBlob(ctx, "bucket1", "blob1", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 0, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	tmp := ".tmp/"
+	storage.fail.Store(&tmp)
+	if _, err := c.ReplaceBlob(ctx, "bucket1", "blob1", []byte("bye")); client.StatusCode(err) != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for a failed replace, got %v", err)
+	}
+	storage.fail.Store(nil)
+	r, err := c.NewReader(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := io.ReadAll(r); err != nil || string(content) != "hello" {
+		t.Fatalf("expected the content to be kept, got %q, %v", content, err)
+	}
+	if versions, err := c.GetAllVersions(ctx, "bucket1"); err != nil || len(versions) != 1 {
+		t.Fatalf("expected no older version to be recorded, got %+v, %v", versions, err)
+	}
+	if files := files(); !slices.Equal(files, []string{"bucket1/blob1"}) {
+		t.Fatalf("expected nothing to be left behind, got %v", files)
+	}
+
+	t.Log("archiving the content of a replace once it succeeds...")
+	if _, err := c.ReplaceBlob(ctx, "bucket1", "blob1", []byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err := c.GetAllVersions(ctx, "bucket1"); err != nil || len(versions) != 2 || versions[1].Size != 5 || versions[0].VersionId == versions[1].VersionId {
+		t.Fatalf("expected the old content to be archived, got %+v, %v", versions, err)
+	}
+	if files := files(); len(files) != 2 {
+		t.Fatalf("expected the blob and its older version, got %v", files)
+	}
+
+	t.Log("keeping the content and versions of a failed restore...")
+	versions, err := c.GetAllVersions(ctx, "bucket1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.fail.Store(&tmp)
+	if _, err := c.RestoreVersion(ctx, "bucket1", "blob1", versions[1].VersionId); client.StatusCode(err) != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for a failed restore, got %v", err)
+	}
+	storage.fail.Store(nil)
+	if r, err = c.NewReader(ctx, "bucket1", "blob1"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := io.ReadAll(r); err != nil || string(content) != "bye" {
+		t.Fatalf("expected the content to be kept, got %q, %v", content, err)
+	}
+	if versions, err := c.GetAllVersions(ctx, "bucket1"); err != nil || len(versions) != 2 {
+		t.Fatalf("expected no older version to be recorded, got %+v, %v", versions, err)
+	}
+	if files := files(); len(files) != 2 {
+		t.Fatalf("expected nothing to be left behind, got %v", files)
+	}
+}
+
+func TestNestedBlobIds(t *testing.T) {
+	for name, storage := range map[string]blob.Storage{"local": nil, "memory": blob.NewMemoryStorage()} {
+		t.Run(name, func(t *testing.T) {
+			s := blobtest.NewServer(t, blob.ServerConfig{Storage: storage})
+			ctx := context.Background()
+			c := s.Client
+			if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			t.Log("rejecting ids with trailing slashes or empty segments...")
+			for _, blobId := range []string{"x/", "a//b"} {
+				if err := c.CreateBlob(ctx, "bucket1", blobId, "text/plain"); client.StatusCode(err) != http.StatusBadRequest {
+					t.Fatalf("expected 400 for blob id %q, got %v", blobId, err)
+				}
+			}
+
+			t.Log("refusing blob ids nested in each other...")
+			if err := c.CreateBlob(ctx, "bucket1", "a/b", "text/plain"); err != nil {
+				t.Fatal(err)
+			}
+			for _, blobId := range []string{"a", "a/b/c"} {
+				if err := c.CreateBlob(ctx, "bucket1", blobId, "text/plain"); client.StatusCode(err) != http.StatusConflict {
+					t.Fatalf("expected 409 for blob id %q, got %v", blobId, err)
+				}
+			}
+			if blobs, err := c.GetAllBlobs(ctx, "bucket1"); err != nil || len(blobs) != 1 {
+				t.Fatalf("expected only a/b, got %+v, %v", blobs, err)
+			}
+			if err := c.DeleteBlob(ctx, "bucket1", "a/b"); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.CreateBlob(ctx, "bucket1", "a", "text/plain"); err != nil {
+				t.Fatal("expected a to be free once a/b is deleted, got ", err)
+			}
+		})
+	}
+}
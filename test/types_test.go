@@ -0,0 +1,32 @@
+package blob
+
+import (
+	"testing"
+
+	"github.com/assaidy/blob"
+)
+
+func TestParseDataUnite(t *testing.T) {
+	valid := map[string]blob.DataUnite{
+		"1024":   1 * blob.KB,
+		"8MB":    8 * blob.MB,
+		"512 kb": 512 * blob.KB,
+		"2GB":    2 * blob.GB,
+		"10B":    10 * blob.Byte,
+	}
+	for text, expected := range valid {
+		size, err := blob.ParseDataUnite(text)
+		if err != nil || size != expected {
+			t.Fatalf("expected %q to parse as %d, got %d, %v", text, expected, size, err)
+		}
+	}
+	for _, text := range []string{"", "MB", "-1KB", "1.5MB", "8XB"} {
+		if _, err := blob.ParseDataUnite(text); err == nil {
+			t.Fatalf("expected %q to be invalid", text)
+		}
+	}
+
+	if (8*blob.MB).String() != "8MB" || (1500*blob.Byte).String() != "1500B" {
+		t.Fatalf("unexpected formatting %s, %s", 8*blob.MB, 1500*blob.Byte)
+	}
+}
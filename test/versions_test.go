@@ -0,0 +1,162 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	"github.com/assaidy/blob/client"
+)
+
+func TestVersioning(t *testing.T) {
+	s := blobtest.NewServer(t, blob.ServerConfig{Storage: blob.NewMemoryStorage()})
+	ctx := context.Background()
+	c := s.Client
+
+	send := func(method, path string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, s.URL+path, http.NoBody)
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Secret-Key", blobtest.SecretKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending request: ", err)
+		}
+		return resp
+	}
+	readVersion := func(versionId string) string {
+		t.Helper()
+		resp := send(http.MethodGet, "/buckets/bucket1/blobs/blob1/versions/"+versionId+"/content")
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 for the content of version %s, got %d", versionId, resp.StatusCode)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal("error reading response: ", err)
+		}
+		return string(body)
+	}
+	overwrite := func() {
+		t.Helper()
+		resp := send(http.MethodPost, "/buckets/bucket1/blobs?blob_id=blob1&overwrite=true")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected 201 for overwriting a blob, got %d", resp.StatusCode)
+		}
+	}
+
+	t.Log("overwriting a blob in a versioned bucket...")
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBucketVersioning(ctx, "bucket1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBlob(ctx, "bucket1", "blob1", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 0, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	overwrite()
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 0, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := c.GetVersions(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || !versions[0].IsLatest || versions[0].ArchivedAt != nil || versions[1].IsLatest || versions[1].ArchivedAt == nil {
+		t.Fatalf("expected the current and one older version, got %+v", versions)
+	}
+	first, second := versions[1].VersionId, versions[0].VersionId
+	if got := readVersion(first); got != "first" {
+		t.Fatalf("expected the older version to read 'first', got %q", got)
+	}
+	if got := readVersion(second); got != "second" {
+		t.Fatalf("expected the current version to read 'second', got %q", got)
+	}
+
+	// ========================================================
+
+	t.Log("deleting a versioned blob...")
+	if err := c.DeleteBlob(ctx, "bucket1", "blob1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBlob(ctx, "bucket1", "blob1"); client.StatusCode(err) != http.StatusNotFound {
+		t.Fatalf("expected 404 for a deleted blob, got %v", err)
+	}
+	versions, err = c.GetVersions(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || !versions[0].DeleteMarker || !versions[0].IsLatest || versions[1].VersionId != second {
+		t.Fatalf("expected a delete marker before the older versions, got %+v", versions)
+	}
+	marker := versions[0].VersionId
+	if got := readVersion(second); got != "second" {
+		t.Fatalf("expected the deleted content to read 'second', got %q", got)
+	}
+	resp := send(http.MethodGet, "/buckets/bucket1/blobs/blob1/versions/"+marker+"/content")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for the content of a delete marker, got %d", resp.StatusCode)
+	}
+
+	// ========================================================
+
+	t.Log("restoring and purging versions...")
+	restored, err := c.RestoreVersion(ctx, "bucket1", "blob1", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Size != 5 || restored.VersionId == first || restored.ContentType != "text/plain" {
+		t.Fatalf("unexpected restored blob %+v", restored)
+	}
+	if got := readVersion(restored.VersionId); got != "first" {
+		t.Fatalf("expected the restored content to read 'first', got %q", got)
+	}
+	if _, err := c.RestoreVersion(ctx, "bucket1", "blob1", marker); client.StatusCode(err) != http.StatusBadRequest {
+		t.Fatalf("expected 400 for restoring a delete marker, got %v", err)
+	}
+	if err := c.PurgeVersion(ctx, "bucket1", "blob1", restored.VersionId); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for purging the current version, got %v", err)
+	}
+	for _, versionId := range []string{marker, second} {
+		if err := c.PurgeVersion(ctx, "bucket1", "blob1", versionId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.PurgeVersion(ctx, "bucket1", "blob1", second); client.StatusCode(err) != http.StatusNotFound {
+		t.Fatalf("expected 404 for a purged version, got %v", err)
+	}
+	versions, err = c.GetAllVersions(ctx, "bucket1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].VersionId != restored.VersionId || versions[1].VersionId != first {
+		t.Fatalf("expected the restored and the first version, got %+v", versions)
+	}
+
+	// ========================================================
+
+	t.Log("overwriting a blob without versioning...")
+	if err := c.SetBucketVersioning(ctx, "bucket1", false); err != nil {
+		t.Fatal(err)
+	}
+	overwrite()
+	if versions, err = c.GetVersions(ctx, "bucket1", "blob1"); err != nil || len(versions) != 2 || versions[0].Size != 0 || versions[1].VersionId != first {
+		t.Fatalf("expected the older version to be kept and the overwritten one dropped, got %+v, %v", versions, err)
+	}
+
+	if err := c.DeleteBucket(ctx, "bucket1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,190 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/assaidy/blob"
+	"github.com/assaidy/blob/blobtest"
+	"github.com/assaidy/blob/client"
+)
+
+// failingWriteStorage is a storage whose in-place writes fail while fail is set.
+type failingWriteStorage struct {
+	blob.Storage
+	fail atomic.Bool
+}
+
+func (me *failingWriteStorage) WriteAt(key string, data []byte, offset int64) error {
+	if me.fail.Load() {
+		return errors.New("write failed")
+	}
+	return me.Storage.WriteAt(key, data, offset)
+}
+
+func TestWriteModes(t *testing.T) {
+	storage := &failingWriteStorage{Storage: blob.NewMemoryStorage()}
+	s := blobtest.NewServer(t, blob.ServerConfig{Storage: storage})
+	ctx := context.Background()
+	c := s.Client
+
+	// expect checks the content of blob1 and that its checksum was kept up to date.
+	expect := func(content string) {
+		t.Helper()
+		r, err := c.NewReader(ctx, "bucket1", "blob1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Fatalf("expected the blob to read %q, got %q", content, got)
+		}
+		b, err := c.GetBlob(ctx, "bucket1", "blob1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(content))
+		if b.Size != len(content) || b.Sha256 != hex.EncodeToString(sum[:]) {
+			t.Fatalf("expected the size and sha256 of %q, got %+v", content, b)
+		}
+	}
+	put := func(mode, contentRange, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, s.URL+"/buckets/bucket1/blobs/blob1?mode="+mode, strings.NewReader(body))
+		if err != nil {
+			t.Fatal("error creating request: ", err)
+		}
+		req.Header.Set("Secret-Key", blobtest.SecretKey)
+		if contentRange != "" {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("error sending request: ", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Log("writing at offsets...")
+	if _, err := c.CreateBucket(ctx, "bucket1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateBlob(ctx, "bucket1", "blob1", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 0, []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := c.WriteAt(ctx, "bucket1", "blob1", 6, []byte("there")); err != nil || size != 11 {
+		t.Fatalf("expected a size of 11, got %d, %v", size, err)
+	}
+	expect("hello there")
+	if size, err := c.WriteAt(ctx, "bucket1", "blob1", 11, []byte("!!")); err != nil || size != 13 {
+		t.Fatalf("expected a size of 13, got %d, %v", size, err)
+	}
+	if _, err := c.WriteAt(ctx, "bucket1", "blob1", 20, []byte("x")); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for writing past the end, got %v", err)
+	}
+	resp := put("write", "bytes 0-4/*", "HELLO")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Upload-Offset") != "13" {
+		t.Fatalf("expected 200 and an Upload-Offset of 13, got %d, %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	expect("HELLO there!!")
+	if resp := put("write", "", "x"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a write without an offset, got %d", resp.StatusCode)
+	}
+	if resp := put("unknown", "", "x"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown mode, got %d", resp.StatusCode)
+	}
+
+	// ========================================================
+
+	t.Log("truncating and replacing...")
+	if b, err := c.TruncateBlob(ctx, "bucket1", "blob1", 5); err != nil || b.Size != 5 {
+		t.Fatalf("expected a size of 5, got %+v, %v", b, err)
+	}
+	expect("HELLO")
+	if _, err := c.TruncateBlob(ctx, "bucket1", "blob1", 10); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for truncating past the end, got %v", err)
+	}
+	before, err := c.GetBlob(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := c.ReplaceBlob(ctx, "bucket1", "blob1", []byte("new content")); err != nil || size != 11 {
+		t.Fatalf("expected a size of 11, got %d, %v", size, err)
+	}
+	expect("new content")
+	if _, err := c.WriteChunk(ctx, "bucket1", "blob1", 11, []byte(" appended")); err != nil {
+		t.Fatal(err)
+	}
+	expect("new content appended")
+	after, err := c.GetBlob(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.VersionId == before.VersionId || after.ContentType != "text/plain" {
+		t.Fatalf("expected a new version id and the same content type, got %+v", after)
+	}
+
+	// ========================================================
+
+	t.Log("changing a versioned blob in place...")
+	if err := c.SetBucketVersioning(ctx, "bucket1", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteAt(ctx, "bucket1", "blob1", 0, []byte("NEW")); err != nil {
+		t.Fatal(err)
+	}
+	expect("NEW content appended")
+	versions, err := c.GetVersions(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[1].VersionId != after.VersionId || versions[1].Size != len("new content appended") {
+		t.Fatalf("expected the content before the write to be kept, got %+v", versions)
+	}
+	current, err := c.GetBlob(ctx, "bucket1", "blob1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.fail.Store(true)
+	if _, err := c.WriteAt(ctx, "bucket1", "blob1", 0, []byte("x")); client.StatusCode(err) != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for a failed write, got %v", err)
+	}
+	storage.fail.Store(false)
+	if b, err := c.GetBlob(ctx, "bucket1", "blob1"); err != nil || b.VersionId != current.VersionId {
+		t.Fatalf("expected a failed write to keep the version id, got %+v, %v", b, err)
+	}
+	if versions, err := c.GetVersions(ctx, "bucket1", "blob1"); err != nil || len(versions) != 2 {
+		t.Fatalf("expected a failed write not to archive a version, got %+v, %v", versions, err)
+	}
+
+	if _, err := c.SealBlob(ctx, "bucket1", "blob1", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WriteAt(ctx, "bucket1", "blob1", 0, []byte("x")); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for writing to a sealed blob, got %v", err)
+	}
+	if _, err := c.TruncateBlob(ctx, "bucket1", "blob1", 0); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for truncating a sealed blob, got %v", err)
+	}
+	if _, err := c.ReplaceBlob(ctx, "bucket1", "blob1", []byte("replaced")); client.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for replacing a sealed blob, got %v", err)
+	}
+	expect("NEW content appended")
+	if versions, err := c.GetVersions(ctx, "bucket1", "blob1"); err != nil || len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %+v, %v", versions, err)
+	}
+}
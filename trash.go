@@ -0,0 +1,313 @@
+package blob
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/oklog/ulid/v2"
+)
+
+// DefaultTrashRetention is how long deleted buckets and blobs can be restored
+// when ServerConfig.TrashRetention is unset.
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// Deleted buckets and blobs are moved into the trash: their rows into the
+// trashed_ shadow tables and their content under trashKey. Blobs deleted from
+// versioned buckets aren't trashed, since their content is kept as an older
+// version. Uploads and access keys of trashed blobs are deleted for good.
+
+// trashBlob moves a blob into the trash. The blob must be locked.
+func (me *Server) trashBlob(blob *Blob, now time.Time) error {
+	item := &TrashItem{
+		Id:        ulid.Make().String(),
+		Kind:      TrashBlob,
+		BucketId:  blob.BucketId,
+		BlobId:    blob.Id,
+		Size:      blob.Size,
+		DeletedAt: now,
+		ExpiresAt: now.Add(me.trashRetention),
+	}
+
+	key := blobKey(blob.BucketId, blob.Id)
+	undo, err := me.moveKeys([]string{key}, liveKey, func(key string) string { return trashKey(item.Id, key) })
+	if err != nil {
+		return err
+	}
+	if err := me.metadata.trashBlob(item, []string{key}); err != nil {
+		return utils.InternalServerError(errors.Join(err, undo()))
+	}
+
+	return nil
+}
+
+// trashBucket moves a bucket into the trash, along with its blobs and older
+// versions. The blobs must be locked, see lockBucketBlobs. If anything fails,
+// the content already moved is moved back.
+func (me *Server) trashBucket(bucketId string, blobs []*Blob, now time.Time) error {
+	item := &TrashItem{
+		Id:        ulid.Make().String(),
+		Kind:      TrashBucket,
+		BucketId:  bucketId,
+		DeletedAt: now,
+		ExpiresAt: now.Add(me.trashRetention),
+	}
+
+	versions, err := me.metadata.getVersionsPerBucket(bucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	var keys []string
+	for _, blob := range blobs {
+		keys = append(keys, blobKey(bucketId, blob.Id))
+		item.Size += blob.Size
+	}
+	for _, version := range versions {
+		if !version.DeleteMarker {
+			keys = append(keys, versionKey(bucketId, version.VersionId))
+			item.Size += version.Size
+		}
+	}
+
+	undo, err := me.moveKeys(keys, liveKey, func(key string) string { return trashKey(item.Id, key) })
+	if err != nil {
+		return err
+	}
+	if err := me.metadata.trashBucket(item, keys, len(blobs), len(versions)); err != nil {
+		if undoErr := undo(); undoErr != nil {
+			return utils.InternalServerError(errors.Join(err, undoErr))
+		}
+		if errors.Is(err, errBucketChanged) {
+			return utils.ConflictError("bucket changed while it was deleted, try again")
+		}
+		return utils.InternalServerError(err)
+	}
+
+	// Only leftovers like empty directories remain.
+	return me.deleteBucketContent(bucketId)
+}
+
+// moveKeys renames the content of every key from from(key) to to(key), moving
+// back what was already renamed if one fails. The returned function moves
+// everything back, for when recording the move fails.
+func (me *Server) moveKeys(keys []string, from, to func(key string) string) (undo func() error, err error) {
+	var moved []string
+	undo = func() error {
+		var errs []error
+		for _, key := range slices.Backward(moved) {
+			errs = append(errs, me.storage.Rename(to(key), from(key)))
+		}
+		return errors.Join(errs...)
+	}
+	for _, key := range keys {
+		if err := me.storage.Rename(from(key), to(key)); err != nil {
+			return nil, storageError(errors.Join(err, undo()))
+		}
+		moved = append(moved, key)
+	}
+	return undo, nil
+}
+
+// liveKey is the key of content outside the trash, for moveKeys.
+func liveKey(key string) string { return key }
+
+// lockBucketBlobs locks every blob of a bucket, so writes to them wait, and
+// returns them along with the function unlocking them. It fails if blobs are
+// added before they're locked.
+func (me *Server) lockBucketBlobs(bucketId string) ([]*Blob, func(), error) {
+	blobs, err := me.metadata.getBlobsPerBucket(bucketId)
+	if err != nil {
+		return nil, nil, utils.InternalServerError(err)
+	}
+	var keys []string
+	for _, blob := range blobs {
+		keys = append(keys, blobKey(bucketId, blob.Id))
+	}
+	unlock := me.blobLocks.lockAll(keys)
+
+	// The blobs may have changed before they were locked.
+	blobs, err = me.metadata.getBlobsPerBucket(bucketId)
+	if err != nil {
+		unlock()
+		return nil, nil, utils.InternalServerError(err)
+	}
+	for _, blob := range blobs {
+		if !slices.Contains(keys, blobKey(bucketId, blob.Id)) {
+			unlock()
+			return nil, nil, utils.ConflictError("bucket changed while it was deleted, try again")
+		}
+	}
+	return blobs, unlock, nil
+}
+
+// removeBucket deletes a bucket along with its uploads, blobs and older
+// versions, into the trash unless it's disabled. Buckets with locked blobs
+// aren't deleted.
+func (me *Server) removeBucket(bucketId string) error {
+	blobs, unlock, err := me.lockBucketBlobs(bucketId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Retentions and legal holds are only set under blobLocks, so the check
+	// holds until the bucket is deleted.
+	now := time.Now().UTC()
+	if locked, err := me.metadata.checkIfBucketHasLockedBlobs(bucketId, now); err != nil {
+		return utils.InternalServerError(err)
+	} else if locked {
+		return utils.ForbiddenError("bucket has locked blobs")
+	}
+	uploads, err := me.metadata.getUploadsPerBucket(bucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	if me.trashRetention > 0 {
+		if err := me.trashBucket(bucketId, blobs, now); err != nil {
+			return err
+		}
+	} else {
+		if err := me.metadata.deleteBucket(bucketId); err != nil {
+			return utils.InternalServerError(err)
+		}
+		if err := me.deleteBucketContent(bucketId); err != nil {
+			return err
+		}
+	}
+
+	// The uploads are only discarded once the bucket is gone, so they can
+	// still be completed if it couldn't be deleted.
+	return me.discardUploads(uploads...)
+}
+
+// purgeTrashItem permanently deletes a trashed item and its content.
+func (me *Server) purgeTrashItem(id string) error {
+	if err := me.metadata.deleteTrashItem(id); err != nil {
+		return utils.InternalServerError(err)
+	}
+	if err := me.storage.DeletePrefix(trashItemPrefix(id)); err != nil {
+		return storageError(err)
+	}
+	return nil
+}
+
+// purgeExpiredTrash permanently deletes trashed items whose retention is over.
+func (me *Server) purgeExpiredTrash() error {
+	ids, err := me.metadata.getExpiredTrashItemIds(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, id := range ids {
+		errs = append(errs, me.purgeTrashItem(id))
+	}
+	return errors.Join(errs...)
+}
+
+// trashItemParam returns the trashed item of a trash route, after checking the
+// credentials have scope on its bucket.
+func (me *Server) trashItemParam(c *fiber.Ctx, scope string) (*TrashItem, error) {
+	id := pathParam(c, "trash_id")
+	if id == "" {
+		return nil, utils.BadRequestError("invalid value for path param trash_id")
+	}
+
+	if exists, err := me.metadata.checkIfTrashItemExists(id); err != nil {
+		return nil, utils.InternalServerError(err)
+	} else if !exists {
+		return nil, utils.NotFoundError("trash item not found")
+	}
+
+	item, err := me.metadata.getTrashItem(id)
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+	if err := authorize(c, scope, item.BucketId); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+func (me *Server) handleGetTrash(c *fiber.Ctx) error {
+	items, err := me.metadata.getAllTrashItems()
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	// Only list the items of buckets the API key is allowed on.
+	apiKey := credentials(c)
+	items = slices.DeleteFunc(items, func(item *TrashItem) bool {
+		return !allowsBucket(apiKey, item.BucketId)
+	})
+	return sendConditionalJSON(c, items, time.Time{})
+}
+
+// handleRestoreTrashItem moves a trashed item back where it was deleted from,
+// unless a bucket or blob was created there since.
+func (me *Server) handleRestoreTrashItem(c *fiber.Ctx) error {
+	item, err := me.trashItemParam(c, ScopeWrite)
+	if err != nil {
+		return err
+	}
+
+	keys, err := me.metadata.getTrashKeys(item.Id)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	// Nothing can be written where the content moves back to until the item
+	// is restored.
+	locked := keys
+	if item.Kind == TrashBlob {
+		locked = []string{blobKey(item.BucketId, item.BlobId)}
+	}
+	unlock := me.blobLocks.lockAll(locked)
+	defer unlock()
+
+	switch item.Kind {
+	case TrashBucket:
+		if exists, err := me.metadata.checkIfBucketExists(item.BucketId); err != nil {
+			return utils.InternalServerError(err)
+		} else if exists {
+			return utils.ConflictError("bucket already exists")
+		}
+	case TrashBlob:
+		if exists, err := me.metadata.checkIfBucketExists(item.BucketId); err != nil {
+			return utils.InternalServerError(err)
+		} else if !exists {
+			return utils.ConflictError("bucket of the blob doesn't exist, restore it first")
+		}
+		if exists, err := me.metadata.checkIfBlobExists(item.BucketId, item.BlobId); err != nil {
+			return utils.InternalServerError(err)
+		} else if exists {
+			return utils.ConflictError("blob already exists")
+		}
+	}
+
+	undo, err := me.moveKeys(keys, func(key string) string { return trashKey(item.Id, key) }, liveKey)
+	if err != nil {
+		return err
+	}
+	if err := me.metadata.restoreTrashItem(item.Id); err != nil {
+		return utils.InternalServerError(errors.Join(err, undo()))
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handlePurgeTrashItem permanently deletes a trashed item before it expires.
+func (me *Server) handlePurgeTrashItem(c *fiber.Ctx) error {
+	item, err := me.trashItemParam(c, ScopeDelete)
+	if err != nil {
+		return err
+	}
+
+	if err := me.purgeTrashItem(item.Id); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -1,8 +1,13 @@
 package blob
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/assaidy/blob/types"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -16,30 +21,106 @@ const (
 	TB   DataUnite = 1024 * GB
 )
 
-type Server struct {
-	rootDir      string
-	secretKey    string
-	maxChunkSize DataUnite
-	router       *fiber.App
-	metadata     *metadataStorage
+// dataUnites are the suffixes of human-readable sizes, biggest first.
+var dataUnites = []struct {
+	suffix string
+	unite  DataUnite
+}{{"TB", TB}, {"GB", GB}, {"MB", MB}, {"KB", KB}, {"B", Byte}}
+
+// ParseDataUnite parses a size like "8MB", "512 KB" or "1024", which is in
+// bytes. Suffixes are case insensitive.
+func ParseDataUnite(s string) (DataUnite, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	unite := Byte
+	for _, u := range dataUnites {
+		if number, ok := strings.CutSuffix(value, u.suffix); ok {
+			value, unite = strings.TrimSpace(number), u.unite
+			break
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid data size %q", s)
+	}
+	return DataUnite(n) * unite, nil
 }
 
-type Bucket struct {
-	Id        string    `json:"id"`
-	CreatedAt time.Time `json:"createdAt"`
-	Blobs     []*Blob   `json:"blobs"`
+// String formats the size with the biggest suffix that divides it, like "8MB".
+func (me DataUnite) String() string {
+	for _, u := range dataUnites {
+		if me != 0 && me%u.unite == 0 {
+			return strconv.Itoa(int(me/u.unite)) + u.suffix
+		}
+	}
+	return strconv.Itoa(int(me)) + "B"
 }
 
-type Blob struct {
-	Id        string    `json:"id"`
-	BucketId  string    `json:"bucketId"`
-	Size      int       `json:"size"`
-	CreatedAt time.Time `json:"createdAt"`
+func (me DataUnite) MarshalText() ([]byte, error) {
+	return []byte(me.String()), nil
 }
 
-type Access struct {
-	Key       string    `json:"key"`
-	BucketId  string    `json:"bucketId"`
-	BlobId    string    `json:"blobId"`
-	CreatedAt time.Time `json:"createdAt"`
+func (me *DataUnite) UnmarshalText(text []byte) error {
+	size, err := ParseDataUnite(string(text))
+	if err != nil {
+		return err
+	}
+	*me = size
+	return nil
 }
+
+type Server struct {
+	storage        Storage
+	secretKey      string
+	signingKey     string
+	s3             *S3Config
+	maxChunkSize   DataUnite
+	trashRetention time.Duration // Deletes are permanent if not positive.
+	requireSealed  bool
+	router         *fiber.App
+	metadata       *metadataStorage
+	blobLocks      *keyedMutex
+	done           chan struct{}  // Closed by Close to stop the background jobs.
+	jobs           sync.WaitGroup // Background jobs, see runPeriodically.
+	handlers       sync.WaitGroup // In-flight handlers, see mwTrackHandlers.
+	handlersMu     sync.Mutex     // Guards closing and adding to handlers.
+	closing        bool           // Set by Close, which refuses new requests.
+	closeOnce      sync.Once
+	closeErr       error
+}
+
+// The types exchanged with clients live in the types package, so clients don't
+// depend on the server. They're aliased here for the server and its users.
+type (
+	Info             = types.Info
+	Bucket           = types.Bucket
+	ObjectLock       = types.ObjectLock
+	Blob             = types.Blob
+	Version          = types.Version
+	LifecycleRule    = types.LifecycleRule
+	LifecycleActions = types.LifecycleActions
+	TrashItem        = types.TrashItem
+	Access           = types.Access
+	APIKey           = types.APIKey
+	Upload           = types.Upload
+	Part             = types.Part
+)
+
+const (
+	PolicyPrivate    = types.PolicyPrivate
+	PolicyPublicRead = types.PolicyPublicRead
+	PolicyPublicList = types.PolicyPublicList
+
+	BlobOpen   = types.BlobOpen
+	BlobSealed = types.BlobSealed
+
+	TrashBucket = types.TrashBucket
+	TrashBlob   = types.TrashBlob
+
+	ScopeDownload = types.ScopeDownload
+	ScopeUpload   = types.ScopeUpload
+
+	ScopeRead   = types.ScopeRead
+	ScopeWrite  = types.ScopeWrite
+	ScopeDelete = types.ScopeDelete
+	ScopeAdmin  = types.ScopeAdmin
+)
@@ -0,0 +1,155 @@
+// Package types holds the types the blob server and its clients exchange. It
+// depends on nothing but the standard library, so clients don't pull in the
+// server's dependencies.
+package types
+
+import "time"
+
+// Info describes the limits of a server to its clients.
+type Info struct {
+	MaxChunkSize int `json:"maxChunkSize"` // Upload chunks and download ranges can't be bigger.
+}
+
+// Policies of buckets, controlling anonymous access to them.
+const (
+	PolicyPrivate    = "private"     // Only accessible with credentials.
+	PolicyPublicRead = "public-read" // Blobs can be downloaded by anyone under /public.
+	PolicyPublicList = "public-list" // Like PolicyPublicRead, and the blobs can be listed by anyone.
+)
+
+type Bucket struct {
+	Id         string     `json:"id"`
+	Policy     string     `json:"policy"`
+	Versioning bool       `json:"versioning"` // Whether older versions of blobs are kept when they're replaced or deleted.
+	ObjectLock ObjectLock `json:"objectLock"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Blobs      []*Blob    `json:"blobs"`
+}
+
+// ObjectLock is how a bucket locks its blobs, so they can't be replaced or
+// deleted until their retention is over.
+type ObjectLock struct {
+	DefaultRetentionDays int  `json:"defaultRetentionDays"` // Blobs are retained this many days after their content is first written, written as a whole or sealed, but open ones can still be appended to. Zero disables it.
+	AllowAppends         bool `json:"allowAppends"`         // Whether locked blobs can still be appended to, which keeps their content intact.
+}
+
+// States of blobs.
+const (
+	BlobOpen   = "open"   // The blob may still be appended to.
+	BlobSealed = "sealed" // The blob is complete; its content can only be replaced as a whole.
+)
+
+type Blob struct {
+	Id          string     `json:"id"`
+	BucketId    string     `json:"bucketId"`
+	Size        int        `json:"size"`
+	ContentType string     `json:"contentType"`
+	Sha256      string     `json:"sha256"` // Hex encoded checksums of the blob's content.
+	Crc32c      string     `json:"crc32c"`
+	Md5         string     `json:"md5"`
+	Version     int        `json:"version"`     // Incremented on every change of the blob's content.
+	VersionId   string     `json:"versionId"`   // Changed when the content is replaced, not when it's appended to.
+	State       string     `json:"state"`       // BlobOpen or BlobSealed.
+	RetainUntil *time.Time `json:"retainUntil"` // The blob can't be replaced or deleted before then. nil if it's not retained.
+	LegalHold   bool       `json:"legalHold"`   // The blob can't be replaced or deleted while it's set.
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// Version is a generation of a blob's content in a versioned bucket: the
+// current one, an older one kept when it was replaced or deleted, or a delete
+// marker recording that the blob was deleted.
+type Version struct {
+	Blob
+	DeleteMarker bool       `json:"deleteMarker"` // Delete markers have no content.
+	IsLatest     bool       `json:"isLatest"`     // Whether it's the current content, or the latest delete marker of a deleted blob.
+	ArchivedAt   *time.Time `json:"archivedAt"`   // When it was replaced or deleted. nil for the current version.
+}
+
+// LifecycleRule deletes blobs, older versions and uploads of a bucket once they
+// reach an age. Zero ages disable their action.
+type LifecycleRule struct {
+	Id                     string `json:"id"`
+	Prefix                 string `json:"prefix"`                 // Only blobs whose ids start with it. Empty matches every blob.
+	ExpireAfterDays        int    `json:"expireAfterDays"`        // Deletes blobs that weren't changed for this many days.
+	PurgeVersionsAfterDays int    `json:"purgeVersionsAfterDays"` // Purges older versions and delete markers archived this many days ago.
+	AbortUploadsAfterHours int    `json:"abortUploadsAfterHours"` // Aborts multipart uploads created this many hours ago.
+}
+
+// LifecycleActions are what the lifecycle rules of a bucket do at some time, or
+// would do in a dry run.
+type LifecycleActions struct {
+	ExpiredBlobs   []*Blob    `json:"expiredBlobs"`
+	PurgedVersions []*Version `json:"purgedVersions"`
+	AbortedUploads []*Upload  `json:"abortedUploads"`
+}
+
+// Kinds of trashed items.
+const (
+	TrashBucket = "bucket"
+	TrashBlob   = "blob"
+)
+
+// TrashItem is a deleted bucket, along with its blobs and older versions, or a
+// deleted blob. It can be restored until it expires and is purged.
+type TrashItem struct {
+	Id        string    `json:"id"`
+	Kind      string    `json:"kind"` // TrashBucket or TrashBlob.
+	BucketId  string    `json:"bucketId"`
+	BlobId    string    `json:"blobId"` // Empty for buckets.
+	Size      int       `json:"size"`   // Total size of the trashed content.
+	DeletedAt time.Time `json:"deletedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Scopes of access keys and signed URLs.
+const (
+	ScopeDownload = "download"
+	ScopeUpload   = "upload"
+)
+
+type Access struct {
+	Key                string     `json:"key"`
+	BucketId           string     `json:"bucketId"`
+	BlobId             string     `json:"blobId"`
+	Scope              string     `json:"scope"`              // ScopeDownload or ScopeUpload.
+	ExpiresAt          *time.Time `json:"expiresAt"`          // nil if the key never expires.
+	MaxDownloads       *int       `json:"maxDownloads"`       // nil if downloads are unlimited.
+	RemainingDownloads *int       `json:"remainingDownloads"` // nil if downloads are unlimited.
+	MaxSize            *int       `json:"maxSize"`            // Upload keys only. nil if the blob may grow unlimited.
+	ContentType        *string    `json:"contentType"`        // Upload keys only. nil if any content type is accepted.
+	CreatedAt          time.Time  `json:"createdAt"`
+}
+
+// Scopes of API keys. Admin keys have every scope.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+	ScopeAdmin  = "admin"
+)
+
+type APIKey struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	Buckets   []string  `json:"buckets"` // Patterns of the buckets the key is allowed on, like "team-*". See path.Match.
+	Disabled  bool      `json:"disabled"`
+	Token     string    `json:"token,omitempty"` // Only returned when the key is created or rotated.
+	CreatedAt time.Time `json:"createdAt"`
+	RotatedAt time.Time `json:"rotatedAt"`
+}
+
+type Upload struct {
+	Id        string    `json:"id"`
+	BucketId  string    `json:"bucketId"`
+	BlobId    string    `json:"blobId"`
+	CreatedAt time.Time `json:"createdAt"`
+	Parts     []*Part   `json:"parts"`
+}
+
+type Part struct {
+	Number    int       `json:"number"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
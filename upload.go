@@ -0,0 +1,507 @@
+package blob
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Resumable uploads follow the core protocol of tus 1.0 (https://tus.io/protocols/resumable-upload),
+// using the blob size as the upload offset.
+const (
+	tusVersion             = "1.0.0"
+	tusContentType         = "application/offset+octet-stream"
+	headerTusResumable     = "Tus-Resumable"
+	headerTusVersion       = "Tus-Version"
+	headerUploadOffset     = "Upload-Offset"
+	headerUploadDeferLen   = "Upload-Defer-Length"
+	noUploadOffset         = -1
+	contentRangeBytePrefix = "bytes "
+)
+
+// Modes of PUT requests to blobs, in the mode query param.
+const (
+	writeModeAppend  = "append"  // Appends the body, the default.
+	writeModeReplace = "replace" // Replaces the content with the body.
+	writeModeWrite   = "write"   // Writes the body at an offset, over the content.
+)
+
+// blobCheck validates the current state of a blob before it's modified. It
+// runs while the blob is locked.
+type blobCheck func(blob *Blob) error
+
+// checkOffset returns a blobCheck that fails with a conflict unless the blob
+// size matches offset. noUploadOffset disables the check.
+func checkOffset(offset int) blobCheck {
+	return func(blob *Blob) error {
+		if offset != noUploadOffset && offset != blob.Size {
+			return utils.ConflictError("upload offset doesn't match the blob size")
+		}
+		return nil
+	}
+}
+
+// checkWithinSize returns a blobCheck that fails with a conflict if offset is
+// past the end of the blob.
+func checkWithinSize(offset int) blobCheck {
+	return func(blob *Blob) error {
+		if offset > blob.Size {
+			return utils.ConflictError(fmt.Sprintf("blob is only %d bytes", blob.Size))
+		}
+		return nil
+	}
+}
+
+// checkWritePreconditions returns a blobCheck that evaluates the If-Match and
+// If-Unmodified-Since headers of a write request against the blob.
+func checkWritePreconditions(c *fiber.Ctx) blobCheck {
+	return func(blob *Blob) error {
+		return checkPreconditions(c, blobETag(blob), blob.UpdatedAt)
+	}
+}
+
+// checkMaxSize returns a blobCheck that fails unless the blob stays within
+// maxSize bytes after n more bytes are appended to it.
+func checkMaxSize(maxSize, n int) blobCheck {
+	return func(blob *Blob) error {
+		if blob.Size+n > maxSize {
+			return utils.RequestEntityTooLargeError(fmt.Sprintf("blob can't grow beyond %d bytes", maxSize))
+		}
+		return nil
+	}
+}
+
+// checkContentType returns a blobCheck that fails unless the media type of a
+// write request is contentType. tus chunks always have tusContentType, so the
+// blob's own content type is checked instead.
+func checkContentType(c *fiber.Ctx, contentType string) blobCheck {
+	return func(blob *Blob) error {
+		value := c.Get(fiber.HeaderContentType)
+		if value == tusContentType {
+			value = blob.ContentType
+		}
+		if mediaType, _, err := mime.ParseMediaType(value); err != nil || mediaType != contentType {
+			return utils.UnsupportedMediaTypeError("content type must be " + contentType)
+		}
+		return nil
+	}
+}
+
+// appendToBlob appends everything read from src to a blob and returns its new
+// size. If any of checks fails, nothing is written and its error is returned
+// along with the current size. The returned size is noUploadOffset if the blob
+// doesn't exist.
+func (me *Server) appendToBlob(bucketId, blobId string, src io.Reader, checks ...blobCheck) (int, error) {
+	unlock := me.blobLocks.lock(blobKey(bucketId, blobId))
+	defer unlock()
+
+	blob, err := me.metadata.getBlob(bucketId, blobId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return noUploadOffset, utils.NotFoundError("blob not found")
+		}
+		return noUploadOffset, utils.InternalServerError(err)
+	}
+
+	checks = append([]blobCheck{checkOpen(), me.checkAppendable()}, checks...)
+	for _, check := range checks {
+		if err := check(blob); err != nil {
+			return blob.Size, err
+		}
+	}
+
+	state, err := me.metadata.getBlobHashState(bucketId, blobId)
+	if err != nil {
+		return blob.Size, utils.InternalServerError(err)
+	}
+	hasher, err := newBlobHasher(state)
+	if err != nil {
+		return blob.Size, utils.InternalServerError(err)
+	}
+
+	key := blobKey(bucketId, blobId)
+	file, err := me.storage.OpenAppend(key)
+	if err != nil {
+		return blob.Size, storageError(err)
+	}
+
+	// Whatever a failed append wrote is cut off again, so the content always
+	// matches the recorded size and a retry appends at the same offset.
+	oldSize := blob.Size
+	rollback := func(err error) error {
+		return utils.InternalServerError(errors.Join(err, me.storage.Truncate(key, int64(oldSize))))
+	}
+	written, err := io.Copy(io.MultiWriter(file, hasher), src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return oldSize, rollback(err)
+	}
+
+	if state, err = hasher.state(); err != nil {
+		return oldSize, rollback(err)
+	}
+	blob.Size += int(written)
+	blob.Version++
+	blob.UpdatedAt = time.Now().UTC()
+	hasher.sum(blob)
+	if oldSize == 0 && blob.Size > 0 {
+		if err := me.retainNewContent(blob, blob.UpdatedAt); err != nil {
+			return oldSize, rollback(err)
+		}
+	}
+	if err := me.metadata.updateBlobContent(blob, state); err != nil {
+		return oldSize, rollback(err)
+	}
+
+	return blob.Size, nil
+}
+
+// replaceBlob replaces the content of a blob with everything read from src and
+// returns it, creating the blob if it doesn't exist. The new content gets a new
+// version id and state, and the old one is kept in versioned buckets. An empty
+// contentType keeps the current one. If any of checks fails, nothing is
+// written and its error is returned.
+func (me *Server) replaceBlob(bucketId, blobId, contentType, state string, src io.Reader, checks ...blobCheck) (*Blob, error) {
+	unlock := me.blobLocks.lock(blobKey(bucketId, blobId))
+	defer unlock()
+
+	created := false
+	blob, err := me.metadata.getBlob(bucketId, blobId)
+	if errors.Is(err, sql.ErrNoRows) {
+		blob, created = &Blob{Id: blobId, BucketId: bucketId, CreatedAt: time.Now().UTC()}, true
+	} else if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+
+	if !created {
+		checks = append([]blobCheck{checkUnlocked(time.Now().UTC())}, checks...)
+	}
+	for _, check := range checks {
+		if err := check(blob); err != nil {
+			return nil, err
+		}
+	}
+
+	hasher, err := newBlobHasher(nil)
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+
+	// The new content is written aside and renamed into place, so readers see
+	// either the old or the new content, and a failed copy changes nothing.
+	tmp := tmpKey()
+	file, err := me.storage.OpenAppend(tmp)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	written, err := io.Copy(io.MultiWriter(file, hasher), src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, utils.InternalServerError(errors.Join(err, me.storage.Delete(tmp)))
+	}
+
+	// The old content is copied aside, and only kept as an older version once
+	// the new one is in place.
+	record, discard := func(time.Time) error { return nil }, func() {}
+	if !created {
+		if record, discard, err = me.archiveCopy(blob); err != nil {
+			me.storage.Delete(tmp)
+			return nil, err
+		}
+	}
+	if err := me.storage.Rename(tmp, blobKey(bucketId, blobId)); err != nil {
+		discard()
+		return nil, storageError(errors.Join(err, me.storage.Delete(tmp)))
+	}
+
+	hashState, err := hasher.state()
+	if err != nil {
+		discard()
+		return nil, utils.InternalServerError(err)
+	}
+	blob.Size = int(written)
+	if contentType != "" {
+		blob.ContentType = contentType
+	}
+	blob.Version++
+	blob.VersionId = newVersionId()
+	blob.State = state
+	blob.UpdatedAt = time.Now().UTC()
+	hasher.sum(blob)
+	// Empty open blobs are placeholders for content still to be appended, and
+	// aren't retained until it is.
+	if state == BlobSealed || blob.Size > 0 {
+		if err := me.retainNewContent(blob, blob.UpdatedAt); err != nil {
+			discard()
+			return nil, err
+		}
+	}
+	if created {
+		if err := me.metadata.createBlob(blob); err != nil {
+			return nil, utils.InternalServerError(err)
+		}
+	}
+	if err := me.metadata.updateBlobContent(blob, hashState); err != nil {
+		discard()
+		return nil, utils.InternalServerError(err)
+	}
+	if err := record(blob.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return blob, nil
+}
+
+// changeBlob changes the content of a blob in place with change, which returns
+// its new size, rehashes it and returns the blob. Sealed and locked blobs can't
+// be changed. The changed content gets a new version id, and in versioned
+// buckets the old content is kept as an older version once the change
+// succeeds. If any of checks fails, nothing is changed. Unlike appends, every
+// change costs time in the size of the whole blob: it's hashed again and, in
+// versioned buckets, copied first.
+func (me *Server) changeBlob(bucketId, blobId string, change func(key string, blob *Blob) (int, error), checks ...blobCheck) (*Blob, error) {
+	unlock := me.blobLocks.lock(blobKey(bucketId, blobId))
+	defer unlock()
+
+	blob, err := me.metadata.getBlob(bucketId, blobId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.NotFoundError("blob not found")
+		}
+		return nil, utils.InternalServerError(err)
+	}
+
+	now := time.Now().UTC()
+	checks = append([]blobCheck{checkOpen(), checkUnlocked(now)}, checks...)
+	for _, check := range checks {
+		if err := check(blob); err != nil {
+			return nil, err
+		}
+	}
+
+	record, discard, err := me.archiveCopy(blob)
+	if err != nil {
+		return nil, err
+	}
+	key := blobKey(bucketId, blobId)
+	size, err := change(key, blob)
+	if err != nil {
+		discard()
+		return nil, err
+	}
+	if err := record(now); err != nil {
+		return nil, err
+	}
+	blob.VersionId = newVersionId()
+
+	// The hash state only carries over appends, so the whole content is
+	// hashed again.
+	hasher, err := me.hashContent(key, size)
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+	hashState, err := hasher.state()
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+
+	oldSize := blob.Size
+	blob.Size = size
+	blob.Version++
+	blob.UpdatedAt = now
+	hasher.sum(blob)
+	if oldSize == 0 && blob.Size > 0 {
+		if err := me.retainNewContent(blob, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := me.metadata.updateBlobContent(blob, hashState); err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+
+	return blob, nil
+}
+
+// parseUploadOffset returns the offset a client states for a chunk, either with
+// an Upload-Offset header or a Content-Range header like "bytes 0-1023/*".
+// It returns noUploadOffset if neither is given.
+func parseUploadOffset(c *fiber.Ctx) (int, error) {
+	if value := strings.TrimSpace(c.Get(headerUploadOffset)); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, utils.BadRequestError("invalid value for header Upload-Offset")
+		}
+		return offset, nil
+	}
+
+	value := strings.TrimSpace(c.Get(fiber.HeaderContentRange))
+	if value == "" {
+		return noUploadOffset, nil
+	}
+	invalid := utils.BadRequestError("invalid value for header Content-Range")
+	if !strings.HasPrefix(value, contentRangeBytePrefix) {
+		return 0, invalid
+	}
+	span, _, ok := strings.Cut(strings.TrimPrefix(value, contentRangeBytePrefix), "/")
+	if !ok {
+		return 0, invalid
+	}
+	first, last, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, invalid
+	}
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 {
+		return 0, invalid
+	}
+	end, err := strconv.Atoi(last)
+	if err != nil || end < start {
+		return 0, invalid
+	}
+	if end-start+1 != len(c.Body()) {
+		return 0, utils.BadRequestError("Content-Range doesn't match the body length")
+	}
+	return start, nil
+}
+
+func (me *Server) handleTusOptions(c *fiber.Ctx) error {
+	c.Set(headerTusResumable, tusVersion)
+	c.Set(headerTusVersion, tusVersion)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (me *Server) handlePatchBlob(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, blobId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("blob not found")
+	}
+
+	return me.patchBlob(c, bucketId, blobId)
+}
+
+// writeToBlob appends the body of a PUT request to a blob, after checks.
+func (me *Server) writeToBlob(c *fiber.Ctx, bucketId, blobId string, checks ...blobCheck) error {
+	offset, err := parseUploadOffset(c)
+	if err != nil {
+		return err
+	}
+	if err := verifyChunk(c, c.Body()); err != nil {
+		return err
+	}
+
+	checks = append([]blobCheck{checkOffset(offset), checkWritePreconditions(c)}, checks...)
+	size, err := me.appendToBlob(bucketId, blobId, bytes.NewReader(c.Body()), checks...)
+	if size != noUploadOffset {
+		c.Set(headerUploadOffset, strconv.Itoa(size))
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// replaceWithBody replaces the content of a blob with the body of a PUT
+// request, keeping its content type. Sealed blobs can't be replaced.
+func (me *Server) replaceWithBody(c *fiber.Ctx, bucketId, blobId string) error {
+	if err := verifyChunk(c, c.Body()); err != nil {
+		return err
+	}
+
+	blob, err := me.replaceBlob(bucketId, blobId, "", BlobOpen, bytes.NewReader(c.Body()), checkOpen(), checkWritePreconditions(c))
+	if err != nil {
+		return err
+	}
+
+	c.Set(headerUploadOffset, strconv.Itoa(blob.Size))
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// writeAtOffset writes the body of a PUT request over the content of a blob,
+// at the offset of its Content-Range or Upload-Offset header. The offset can't
+// be past the end of the blob, but the body may go past it.
+func (me *Server) writeAtOffset(c *fiber.Ctx, bucketId, blobId string) error {
+	offset, err := parseUploadOffset(c)
+	if err != nil {
+		return err
+	}
+	if offset == noUploadOffset {
+		return utils.BadRequestError("missing header Content-Range or Upload-Offset")
+	}
+	if err := verifyChunk(c, c.Body()); err != nil {
+		return err
+	}
+
+	data := c.Body()
+	blob, err := me.changeBlob(bucketId, blobId, func(key string, blob *Blob) (int, error) {
+		if err := me.storage.WriteAt(key, data, int64(offset)); err != nil {
+			return 0, storageError(err)
+		}
+		return max(blob.Size, offset+len(data)), nil
+	}, checkWithinSize(offset), checkWritePreconditions(c))
+	if err != nil {
+		return err
+	}
+
+	c.Set(headerUploadOffset, strconv.Itoa(blob.Size))
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// patchBlob appends the body of a tus PATCH request to a blob, after checks.
+func (me *Server) patchBlob(c *fiber.Ctx, bucketId, blobId string, checks ...blobCheck) error {
+	c.Set(headerTusResumable, tusVersion)
+	if c.Get(headerTusResumable) != tusVersion {
+		c.Set(headerTusVersion, tusVersion)
+		return utils.PreconditionFailedError("unsupported tus version")
+	}
+	if c.Get(fiber.HeaderContentType) != tusContentType {
+		return utils.UnsupportedMediaTypeError("content type must be " + tusContentType)
+	}
+	if c.Get(headerUploadOffset) == "" {
+		return utils.BadRequestError("missing header Upload-Offset")
+	}
+
+	offset, err := parseUploadOffset(c)
+	if err != nil {
+		return err
+	}
+	if err := verifyChunk(c, c.Body()); err != nil {
+		return err
+	}
+
+	checks = append([]blobCheck{checkOffset(offset), checkWritePreconditions(c)}, checks...)
+	size, err := me.appendToBlob(bucketId, blobId, bytes.NewReader(c.Body()), checks...)
+	if size != noUploadOffset {
+		c.Set(headerUploadOffset, strconv.Itoa(size))
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -73,3 +73,45 @@ func UnauthorizedError() *APIError {
 		Message: "unauthorized",
 	}
 }
+
+func ForbiddenError(msg string) *APIError {
+	return &APIError{
+		Code:    http.StatusForbidden,
+		Message: msg,
+	}
+}
+
+func PreconditionFailedError(msg string) *APIError {
+	return &APIError{
+		Code:    http.StatusPreconditionFailed,
+		Message: msg,
+	}
+}
+
+func RequestEntityTooLargeError(msg string) *APIError {
+	return &APIError{
+		Code:    http.StatusRequestEntityTooLarge,
+		Message: msg,
+	}
+}
+
+func UnsupportedMediaTypeError(msg string) *APIError {
+	return &APIError{
+		Code:    http.StatusUnsupportedMediaType,
+		Message: msg,
+	}
+}
+
+func GoneError(msg string) *APIError {
+	return &APIError{
+		Code:    http.StatusGone,
+		Message: msg,
+	}
+}
+
+func ServiceUnavailableError(msg string) *APIError {
+	return &APIError{
+		Code:    http.StatusServiceUnavailable,
+		Message: msg,
+	}
+}
@@ -0,0 +1,361 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/assaidy/blob/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/oklog/ulid/v2"
+)
+
+// Blobs in versioned buckets keep their older content when it's replaced, by
+// PUT requests with mode=replace, S3 PUT requests, completed S3 multipart
+// uploads, restores or blob creation with overwrite, changed in place by writes
+// at an offset or truncation, or deleted. Appending to a blob doesn't start a
+// new version.
+// Older versions are stored under versionKey and recorded in blob_versions,
+// along with delete markers, until they're purged.
+
+// newVersionId returns the id of a new version of a blob's content.
+func newVersionId() string {
+	return ulid.Make().String()
+}
+
+// archiveContent moves the content of a blob that is about to be replaced or
+// deleted into an older version archived at at, and returns true, if its
+// bucket is versioned. Otherwise, the content is left for the caller to
+// overwrite or delete. The blob must be locked.
+func (me *Server) archiveContent(blob *Blob, at time.Time) (bool, error) {
+	versioning, err := me.metadata.getBucketVersioning(blob.BucketId)
+	if err != nil {
+		return false, utils.InternalServerError(err)
+	}
+	if !versioning {
+		return false, nil
+	}
+
+	if err := me.storage.Rename(blobKey(blob.BucketId, blob.Id), versionKey(blob.BucketId, blob.VersionId)); err != nil {
+		return false, storageError(err)
+	}
+	if err := me.metadata.createVersion(&Version{Blob: *blob, ArchivedAt: &at}); err != nil {
+		return false, utils.InternalServerError(err)
+	}
+	return true, nil
+}
+
+// archiveCopy copies the content of a blob that is about to be changed in
+// place or replaced, if its bucket is versioned. Once the change is done,
+// record keeps the copy as an older version archived at at; if it fails,
+// discard deletes the copy. The blob must be locked.
+func (me *Server) archiveCopy(blob *Blob) (record func(at time.Time) error, discard func(), err error) {
+	record, discard = func(time.Time) error { return nil }, func() {}
+	versioning, err := me.metadata.getBucketVersioning(blob.BucketId)
+	if err != nil {
+		return nil, nil, utils.InternalServerError(err)
+	}
+	if !versioning {
+		return record, discard, nil
+	}
+
+	key := versionKey(blob.BucketId, blob.VersionId)
+	src, err := me.storage.OpenReader(blobKey(blob.BucketId, blob.Id))
+	if err != nil {
+		return nil, nil, storageError(err)
+	}
+	defer src.Close()
+	dst, err := me.storage.OpenAppend(key)
+	if err != nil {
+		return nil, nil, storageError(err)
+	}
+	if _, err := io.Copy(dst, io.NewSectionReader(src, 0, int64(blob.Size))); err != nil {
+		dst.Close()
+		return nil, nil, utils.InternalServerError(errors.Join(err, me.storage.Delete(key)))
+	}
+	if err := dst.Close(); err != nil {
+		return nil, nil, utils.InternalServerError(errors.Join(err, me.storage.Delete(key)))
+	}
+
+	version := &Version{Blob: *blob}
+	record = func(at time.Time) error {
+		version.ArchivedAt = &at
+		if err := me.metadata.createVersion(version); err != nil {
+			return utils.InternalServerError(errors.Join(err, me.storage.Delete(key)))
+		}
+		return nil
+	}
+	discard = func() { me.storage.Delete(key) }
+	return record, discard, nil
+}
+
+// purgeVersion permanently deletes an older version or a delete marker.
+func (me *Server) purgeVersion(bucketId, versionId string) error {
+	if err := me.metadata.deleteVersion(versionId); err != nil {
+		return utils.InternalServerError(err)
+	}
+	if err := me.storage.Delete(versionKey(bucketId, versionId)); err != nil {
+		return storageError(err)
+	}
+	return nil
+}
+
+// versionsOf merges current blobs with the older versions and delete markers
+// of a bucket, which are ordered by blob and newest first. The result is
+// ordered by blob id, each blob's current version first.
+func versionsOf(blobs []*Blob, older []*Version) []*Version {
+	var (
+		current = map[string]*Blob{}
+		byBlob  = map[string][]*Version{}
+		ids     []string
+	)
+	for _, blob := range blobs {
+		current[blob.Id] = blob
+		ids = append(ids, blob.Id)
+	}
+	for _, version := range older {
+		if _, ok := current[version.Id]; !ok && len(byBlob[version.Id]) == 0 {
+			ids = append(ids, version.Id)
+		}
+		byBlob[version.Id] = append(byBlob[version.Id], version)
+	}
+	slices.Sort(ids)
+
+	versions := []*Version{}
+	for _, id := range ids {
+		if blob, ok := current[id]; ok {
+			versions = append(versions, &Version{Blob: *blob, IsLatest: true})
+		} else if latest := byBlob[id][0]; latest.DeleteMarker {
+			latest.IsLatest = true
+		}
+		versions = append(versions, byBlob[id]...)
+	}
+	return versions
+}
+
+// blobVersions returns every version of a blob, newest first. It fails with
+// not found if there are none.
+func (me *Server) blobVersions(bucketId, blobId string) ([]*Version, error) {
+	var blobs []*Blob
+	if exists, err := me.metadata.checkIfBlobExists(bucketId, blobId); err != nil {
+		return nil, utils.InternalServerError(err)
+	} else if exists {
+		blob, err := me.metadata.getBlob(bucketId, blobId)
+		if err != nil {
+			return nil, utils.InternalServerError(err)
+		}
+		blobs = append(blobs, blob)
+	}
+
+	older, err := me.metadata.getVersionsPerBlob(bucketId, blobId)
+	if err != nil {
+		return nil, utils.InternalServerError(err)
+	}
+
+	versions := versionsOf(blobs, older)
+	if len(versions) == 0 {
+		return nil, utils.NotFoundError("blob not found")
+	}
+	return versions, nil
+}
+
+// findVersion returns a version of a blob, which may be its current one.
+func (me *Server) findVersion(bucketId, blobId, versionId string) (*Version, error) {
+	versions, err := me.blobVersions(bucketId, blobId)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(versions, func(version *Version) bool { return version.VersionId == versionId })
+	if i < 0 {
+		return nil, utils.NotFoundError("version not found")
+	}
+	return versions[i], nil
+}
+
+// versionParams returns the path params of a version route.
+func versionParams(c *fiber.Ctx) (bucketId, blobId, versionId string, err error) {
+	bucketId = pathParam(c, "bucket_id")
+	blobId = pathParam(c, "blob_id")
+	versionId = pathParam(c, "version_id")
+	if bucketId == "" {
+		return "", "", "", utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return "", "", "", utils.BadRequestError("invalid value for path param blob_id")
+	}
+	if versionId == "" {
+		return "", "", "", utils.BadRequestError("invalid value for path param version_id")
+	}
+	return bucketId, blobId, versionId, nil
+}
+
+func (me *Server) handleSetBucketVersioning(c *fiber.Ctx) error {
+	bucketId := pathParam(c, "bucket_id")
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	versioning, err := strconv.ParseBool(strings.TrimSpace(c.Query("enabled")))
+	if err != nil {
+		return utils.BadRequestError("invalid value for query param enabled")
+	}
+
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("bucket not found")
+	}
+
+	// Suspending versioning keeps the older versions; later changes just don't
+	// add to them.
+	if err := me.metadata.setBucketVersioning(bucketId, versioning); err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (me *Server) handleGetVersionsPerBucket(c *fiber.Ctx) error {
+	bucketId := pathParam(c, "bucket_id")
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("bucket not found")
+	}
+
+	blobs, err := me.metadata.getBlobsPerBucket(bucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+	older, err := me.metadata.getVersionsPerBucket(bucketId)
+	if err != nil {
+		return utils.InternalServerError(err)
+	}
+
+	return sendConditionalJSON(c, versionsOf(blobs, older), time.Time{})
+}
+
+func (me *Server) handleGetVersionsPerBlob(c *fiber.Ctx) error {
+	var (
+		bucketId = pathParam(c, "bucket_id")
+		blobId   = pathParam(c, "blob_id")
+	)
+	if bucketId == "" {
+		return utils.BadRequestError("invalid value for path param bucket_id")
+	}
+	if blobId == "" {
+		return utils.BadRequestError("invalid value for path param blob_id")
+	}
+
+	if exists, err := me.metadata.checkIfBucketExists(bucketId); err != nil {
+		return utils.InternalServerError(err)
+	} else if !exists {
+		return utils.NotFoundError("bucket not found")
+	}
+
+	versions, err := me.blobVersions(bucketId, blobId)
+	if err != nil {
+		return err
+	}
+
+	return sendConditionalJSON(c, versions, time.Time{})
+}
+
+func (me *Server) handleGetVersion(c *fiber.Ctx) error {
+	bucketId, blobId, versionId, err := versionParams(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := me.findVersion(bucketId, blobId, versionId)
+	if err != nil {
+		return err
+	}
+
+	return sendConditionalJSON(c, version, version.UpdatedAt)
+}
+
+// handleDownloadVersion serves the content of a version, for GET and HEAD
+// requests.
+func (me *Server) handleDownloadVersion(c *fiber.Ctx) error {
+	bucketId, blobId, versionId, err := versionParams(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := me.findVersion(bucketId, blobId, versionId)
+	if err != nil {
+		return err
+	}
+	if version.DeleteMarker {
+		return utils.NotFoundError("version is a delete marker")
+	}
+
+	if version.ArchivedAt == nil {
+		return me.serveBlob(c, &version.Blob)
+	}
+	return me.serveContent(c, &version.Blob, versionKey(bucketId, versionId), serveOptions{})
+}
+
+// handleRestoreVersion makes an older version of a blob its current content,
+// as a new version, restoring the blob if it was deleted.
+func (me *Server) handleRestoreVersion(c *fiber.Ctx) error {
+	bucketId, blobId, versionId, err := versionParams(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := me.findVersion(bucketId, blobId, versionId)
+	if err != nil {
+		return err
+	}
+	if version.DeleteMarker {
+		return utils.BadRequestError("delete markers can't be restored")
+	}
+	if version.ArchivedAt == nil {
+		return utils.ConflictError("version is already current")
+	}
+
+	file, err := me.storage.OpenReader(versionKey(bucketId, versionId))
+	if err != nil {
+		return storageError(err)
+	}
+	defer file.Close()
+
+	src := io.NewSectionReader(file, 0, int64(version.Size))
+	blob, err := me.replaceBlob(bucketId, blobId, version.ContentType, version.State, src, checkWritePreconditions(c))
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(blob)
+}
+
+// handlePurgeVersion permanently deletes an older version or a delete marker
+// of a blob. The current version is only removed by deleting the blob.
+func (me *Server) handlePurgeVersion(c *fiber.Ctx) error {
+	bucketId, blobId, versionId, err := versionParams(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := me.findVersion(bucketId, blobId, versionId)
+	if err != nil {
+		return err
+	}
+	if version.ArchivedAt == nil {
+		return utils.ConflictError("the current version can't be purged, delete the blob instead")
+	}
+
+	if err := me.purgeVersion(bucketId, versionId); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}